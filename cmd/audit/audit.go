@@ -0,0 +1,36 @@
+package audit
+
+import (
+	"datavault/cmd/internal"
+	"datavault/configs"
+	"encoding/json"
+	"log"
+)
+
+// Config is the part of a gatekeeper or vault configuration naming its audit log
+type Config struct {
+	Audit *internal.AuditConfig `json:"audit"` // Audit log of the component
+}
+
+// Exec verifies the hash chain of the audit log named by a gatekeeper or vault configuration
+func Exec() {
+	var config Config
+	err := json.Unmarshal(configs.Instance.ConfigFileData, &config)
+	if err != nil {
+		log.Fatalf("Error parsing configuration: %v\n", err)
+	}
+	if config.Audit == nil || config.Audit.Dir == "" {
+		log.Fatalf("The configuration has no audit log\n")
+	}
+
+	verification, err := internal.VerifyAuditLog(config.Audit.Dir)
+	if err != nil {
+		log.Fatalf("Audit log verification failed after %d entries: %v\n", verification.Entries, err)
+	}
+	if verification.Entries == 0 {
+		log.Printf("Audit log %s holds no entry\n", config.Audit.Dir)
+		return
+	}
+	log.Printf("Audit log %s is intact: %d entries in %d segments, from entry %d to %d\n",
+		config.Audit.Dir, verification.Entries, verification.Segments, verification.FirstSeq, verification.LastSeq)
+}
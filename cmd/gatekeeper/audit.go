@@ -0,0 +1,59 @@
+package gatekeeper
+
+import (
+	"datavault/cmd/internal"
+	"encoding/json"
+	"io"
+	"net/http"
+	"slices"
+)
+
+// auditLog records the mutating requests served by the gatekeeper, nil when disabled
+var auditLog *internal.AuditLog
+
+// HandlerAudit returns entries of the audit log of the gatekeeper, or of one vault of the cluster with vault=<address>
+//
+// Entries are selected by time range (from, to), namespace and group.
+func HandlerAudit(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if address := query.Get("vault"); address != "" {
+		if !slices.Contains(CurrentCluster().Addresses, address) {
+			http.Error(w, "unknown vault "+address, http.StatusBadRequest)
+			return
+		}
+		query.Del("vault")
+		resp, err := vaultClient.Get(address, "/audit", query)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer resp.Body.Close()
+		w.Header().Set("Content-Type", resp.Header.Get("Content-Type"))
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body)
+		return
+	}
+
+	if auditLog == nil {
+		http.Error(w, "audit log is disabled", http.StatusNotFound)
+		return
+	}
+	auditQuery, err := internal.ParseAuditQuery(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	entries, err := auditLog.Query(auditQuery)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(entries)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
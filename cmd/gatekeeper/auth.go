@@ -0,0 +1,224 @@
+package gatekeeper
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"datavault/cmd/internal"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+)
+
+// Permissions granted by access rules
+const (
+	PermissionRead   = "read"   // List groups, read groups and elements
+	PermissionWrite  = "write"  // Upload elements
+	PermissionDelete = "delete" // Delete groups and elements
+	PermissionAdmin  = "admin"  // Read the cluster state: stats, hints, members and ring
+
+	PermissionRetention = "retention" // Change the retention dates and legal holds of groups and elements
+)
+
+// AccessRule grants permissions on the groups matching a pattern, in the namespaces matching a pattern
+type AccessRule struct {
+	Namespace   string   `json:"namespace"`   // Namespace or pattern ("*", "team-*"), defaults to the default namespace
+	Groups      string   `json:"groups"`      // Group id or pattern ("*", "photos-*"), defaults to every group
+	Permissions []string `json:"permissions"` // Granted permissions: read, write, delete, admin, retention
+}
+
+// APIKey is a key clients authenticate with and the access it grants
+type APIKey struct {
+	Name  string       `json:"name"`  // Name of the key holder, used in error messages
+	Key   string       `json:"key"`   // Secret sent by clients in the Authorization or X-API-Key header
+	Rules []AccessRule `json:"rules"` // Access rules of the key
+}
+
+// apiKeyContextKey is the context key of the API key of an authenticated request
+type apiKeyContextKey struct{}
+
+// AuthEnabled reports whether clients must authenticate with an API key
+func AuthEnabled() bool {
+	return len(KeeperConfig.APIKeys) > 0
+}
+
+// ValidateAPIKeys checks that keys are unique and that their rules are well formed
+func ValidateAPIKeys(keys []APIKey) error {
+	seen := make(map[string]bool)
+	for i, key := range keys {
+		if key.Key == "" {
+			return fmt.Errorf("API key %d (%s) is empty", i, key.Name)
+		}
+		if seen[key.Key] {
+			return fmt.Errorf("API key %d (%s) is a duplicate", i, key.Name)
+		}
+		seen[key.Key] = true
+
+		for j, rule := range key.Rules {
+			if _, err := path.Match(rule.Namespace, ""); err != nil {
+				return fmt.Errorf("API key %s rule %d: invalid namespace pattern %q", key.Name, j, rule.Namespace)
+			}
+			if _, err := path.Match(rule.Groups, ""); err != nil {
+				return fmt.Errorf("API key %s rule %d: invalid groups pattern %q", key.Name, j, rule.Groups)
+			}
+			for _, permission := range rule.Permissions {
+				switch permission {
+				case PermissionRead, PermissionWrite, PermissionDelete, PermissionAdmin, PermissionRetention:
+				default:
+					return fmt.Errorf("API key %s rule %d: unknown permission %q", key.Name, j, permission)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// requestKey returns the secret a client sent in the Authorization (Bearer) or X-API-Key header
+func requestKey(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(token)
+	}
+	return ""
+}
+
+// FindAPIKey returns the configured key matching a secret, comparing digests in constant time
+func FindAPIKey(secret string) *APIKey {
+	digest := sha256.Sum256([]byte(secret))
+
+	var found *APIKey
+	for i := range KeeperConfig.APIKeys {
+		candidate := sha256.Sum256([]byte(KeeperConfig.APIKeys[i].Key))
+		if subtle.ConstantTimeCompare(digest[:], candidate[:]) == 1 {
+			found = &KeeperConfig.APIKeys[i]
+		}
+	}
+
+	return found
+}
+
+// Allows reports whether a key grants a permission on a group of a namespace, admin is granted on the whole cluster
+func (k *APIKey) Allows(permission, namespace, groupId string) bool {
+	for _, rule := range k.Rules {
+		if permission == PermissionAdmin {
+			if slices.Contains(rule.Permissions, PermissionAdmin) {
+				return true
+			}
+			continue
+		}
+
+		namespacePattern := rule.Namespace
+		if namespacePattern == "" {
+			namespacePattern = internal.DefaultNamespace
+		}
+		if matched, _ := path.Match(namespacePattern, namespace); !matched {
+			continue
+		}
+
+		pattern := rule.Groups
+		if pattern == "" {
+			pattern = "*"
+		}
+		if matched, _ := path.Match(pattern, groupId); matched && slices.Contains(rule.Permissions, permission) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CanAccess reports whether the client of a request holds a permission on a group of a namespace
+func CanAccess(r *http.Request, permission, namespace, groupId string) bool {
+	if !AuthEnabled() {
+		return true
+	}
+
+	key, ok := r.Context().Value(apiKeyContextKey{}).(*APIKey)
+	return ok && key.Allows(permission, namespace, groupId)
+}
+
+// Authenticate rejects requests without a known API key and remembers the key of the others
+func Authenticate(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !AuthEnabled() {
+			next(w, r)
+			return
+		}
+
+		key := FindAPIKey(requestKey(r))
+		if key == nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="datavault"`)
+			http.Error(w, "missing or unknown API key", http.StatusUnauthorized)
+			return
+		}
+		internal.AuditPrincipal(r, key.Name)
+
+		next(w, r.WithContext(context.WithValue(r.Context(), apiKeyContextKey{}, key)))
+	}
+}
+
+// Authorize requires a permission on the group of the request, or a valid pre-signed URL
+func Authorize(permission string, next http.HandlerFunc) http.HandlerFunc {
+	authorize := Authenticate(func(w http.ResponseWriter, r *http.Request) {
+		namespace := RequestNamespace(r)
+		groupId := r.URL.Query().Get("groupId")
+		if !CanAccess(r, permission, namespace, groupId) {
+			message := "permission denied: " + permission
+			if permission != PermissionAdmin {
+				message += " on group " + groupId + " of namespace " + namespace
+			}
+			http.Error(w, message, http.StatusForbidden)
+			return
+		}
+
+		next(w, r)
+	})
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !PresignEnabled() || !internal.IsPresigned(r) {
+			authorize(w, r)
+			return
+		}
+
+		// The signature grants exactly the method, namespace, group and element of the URL
+		err := internal.VerifyPresignedURL(r, KeeperConfig.URLSecret)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		internal.AuditPrincipal(r, "presigned")
+		if r.Method != http.MethodPut {
+			next(w, r)
+			return
+		}
+
+		// Upload URLs are used once, by the first upload that commits
+		finish, err := ClaimUpload(r)
+		if errors.Is(err, ErrURLUsed) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		recorder := &uploadRecorder{ResponseWriter: w}
+		next(recorder, r)
+		finish(recorder.status == http.StatusOK)
+	}
+}
+
+// SignVaultRequest signs a request to a vault with the vault secret and strips client credentials
+func SignVaultRequest(r *http.Request) {
+	r.Header.Del("Authorization")
+	r.Header.Del("X-API-Key")
+	if KeeperConfig.VaultSecret != "" {
+		internal.SignRequest(r, KeeperConfig.VaultSecret)
+	}
+}
@@ -0,0 +1,321 @@
+package gatekeeper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned without contacting a vault while its circuit breaker is open
+var ErrCircuitOpen = errors.New("vault circuit breaker is open")
+
+// breakerState is the state of the circuit breaker of a vault
+type breakerState int
+
+const (
+	breakerClosed   breakerState = iota // Requests flow to the vault
+	breakerOpen                         // Requests fail fast until the cooldown has passed
+	breakerHalfOpen                     // A single probe request decides whether to close the breaker
+)
+
+// String returns the name of the breaker state
+func (s breakerState) String() string {
+	switch s {
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// breaker counts the consecutive failures of a vault and stops traffic to it once they pass a threshold
+type breaker struct {
+	mu       sync.Mutex
+	state    breakerState
+	failures int       // Consecutive failures while closed
+	openedAt time.Time // Time the breaker last opened
+}
+
+// allow reports whether a request may be sent to the vault
+func (b *breaker) allow(cooldown time.Duration) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.openedAt) < cooldown {
+			return false
+		}
+		b.state = breakerHalfOpen
+		return true
+	case breakerHalfOpen:
+		return false
+	default:
+		return true
+	}
+}
+
+// success closes the breaker
+func (b *breaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.state = breakerClosed
+	b.failures = 0
+}
+
+// failure records a failed request and reports whether it opened the breaker
+func (b *breaker) failure(threshold int) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	if b.state == breakerHalfOpen || (b.state == breakerClosed && b.failures >= threshold) {
+		b.state = breakerOpen
+		b.openedAt = time.Now()
+		return true
+	}
+	return false
+}
+
+// abandon gives up a request that says nothing about the vault, a half-open breaker lets the next request probe again
+func (b *breaker) abandon() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == breakerHalfOpen {
+		b.state = breakerOpen
+	}
+}
+
+// VaultClient sends gatekeeper requests to vaults over pooled connections
+//
+// Every vault has a circuit breaker that opens after consecutive transport
+// failures. Body-less idempotent requests are retried with exponential backoff.
+type VaultClient struct {
+	transport *http.Transport
+	client    *http.Client
+	proxy     *httputil.ReverseProxy
+
+	mu       sync.Mutex
+	breakers map[string]*breaker
+}
+
+// vaultClient is the client used for all gatekeeper-to-vault traffic
+var vaultClient *VaultClient
+
+// proxyTargetKey is the context key of the vault a proxied request is forwarded to
+type proxyTargetKey struct{}
+
+// vaultScheme returns the scheme of requests to vaults
+func vaultScheme() string {
+	if KeeperConfig.VaultTLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// VaultURL returns the URL of a path on a vault
+func VaultURL(address, path string, query url.Values) string {
+	return vaultScheme() + "://" + address + path + "?" + query.Encode()
+}
+
+// NewVaultClient creates a vault client from the gatekeeper configuration
+func NewVaultClient() (*VaultClient, error) {
+	c := &VaultClient{
+		transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: (&net.Dialer{
+				Timeout:   time.Duration(KeeperConfig.ConnectTimeout) * time.Second,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			MaxIdleConnsPerHost: KeeperConfig.MaxIdleConns,
+			IdleConnTimeout:     90 * time.Second,
+		},
+		breakers: make(map[string]*breaker),
+	}
+	if KeeperConfig.VaultTLS != nil {
+		tlsConfig, err := KeeperConfig.VaultTLS.ClientConfig()
+		if err != nil {
+			return nil, err
+		}
+		c.transport.TLSClientConfig = tlsConfig
+		c.transport.ForceAttemptHTTP2 = true
+	}
+	c.client = &http.Client{Transport: c}
+	c.proxy = &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(&url.URL{Scheme: vaultScheme(), Host: pr.In.Context().Value(proxyTargetKey{}).(string)})
+			pr.Out.Host = pr.In.Host
+			SetRingVersion(pr.Out.Header)
+			SignVaultRequest(pr.Out)
+		},
+		Transport: c,
+		ModifyResponse: func(resp *http.Response) error {
+			CheckRingVersion(resp)
+			return nil
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			log.Printf("Error proxying %s %s: %v\n", r.Method, r.URL.Path, err)
+			if errors.Is(err, ErrCircuitOpen) {
+				http.Error(w, err.Error(), http.StatusServiceUnavailable)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusBadGateway)
+		},
+	}
+
+	return c, nil
+}
+
+// breakerFor returns the circuit breaker of a vault
+func (c *VaultClient) breakerFor(address string) *breaker {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	b, ok := c.breakers[address]
+	if !ok {
+		b = &breaker{}
+		c.breakers[address] = b
+	}
+	return b
+}
+
+// BreakerStates returns the state of the circuit breaker of every vault contacted so far
+func (c *VaultClient) BreakerStates() map[string]string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	states := make(map[string]string, len(c.breakers))
+	for address, b := range c.breakers {
+		b.mu.Lock()
+		states[address] = b.state.String()
+		b.mu.Unlock()
+	}
+	return states
+}
+
+// retryable reports whether a request can be sent again after a transport failure
+func retryable(req *http.Request) bool {
+	if req.Body != nil && req.Body != http.NoBody {
+		return false
+	}
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodDelete, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+// backoff returns the delay before a retry, doubling with every attempt and jittered
+func backoff(attempt int) time.Duration {
+	base := time.Duration(KeeperConfig.RetryBackoff) * time.Millisecond << attempt
+	return base + rand.N(base/2+1)
+}
+
+// RoundTrip sends a request to a vault through its circuit breaker, retrying idempotent requests
+func (c *VaultClient) RoundTrip(req *http.Request) (*http.Response, error) {
+	address := req.URL.Host
+	b := c.breakerFor(address)
+
+	for attempt := 0; ; attempt++ {
+		if !b.allow(time.Duration(KeeperConfig.BreakerCooldown) * time.Second) {
+			return nil, fmt.Errorf("%w: %s", ErrCircuitOpen, address)
+		}
+
+		// Vaults accept a signature once, every retry is signed again
+		if attempt > 0 {
+			req = req.Clone(req.Context())
+			SignVaultRequest(req)
+		}
+		resp, err := c.transport.RoundTrip(req)
+		if err == nil {
+			b.success()
+			return resp, nil
+		}
+
+		// A caller that went away says nothing about the health of the vault
+		if errors.Is(err, context.Canceled) {
+			b.abandon()
+		} else if b.failure(KeeperConfig.BreakerThreshold) {
+			log.Printf("Circuit breaker of vault %s opened: %v\n", address, err)
+		}
+		if attempt >= KeeperConfig.Retries || !retryable(req) || req.Context().Err() != nil {
+			return nil, err
+		}
+
+		select {
+		case <-time.After(backoff(attempt)):
+		case <-req.Context().Done():
+			return nil, err
+		}
+	}
+}
+
+// cancelBody cancels the deadline of a request once its response body is closed
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+// Close closes the body and releases the deadline
+func (b cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// Do sends a request to a vault, timeout bounds the whole exchange including reading the body, 0 for none
+func (c *VaultClient) Do(req *http.Request, timeout time.Duration) (*http.Response, error) {
+	SetRingVersion(req.Header)
+	SignVaultRequest(req)
+	if timeout <= 0 {
+		resp, err := c.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		CheckRingVersion(resp)
+		return resp, nil
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), timeout)
+	resp, err := c.client.Do(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	CheckRingVersion(resp)
+	resp.Body = cancelBody{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// Get sends a GET request to a vault bounded by the broadcast timeout
+func (c *VaultClient) Get(address, path string, query url.Values) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, VaultURL(address, path, query), nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(req, time.Duration(KeeperConfig.BroadcastTimeout)*time.Second)
+}
+
+// Proxy forwards a request to a vault and streams its response, bounded by the transfer timeout
+func (c *VaultClient) Proxy(w http.ResponseWriter, r *http.Request, address string) {
+	ctx := context.WithValue(r.Context(), proxyTargetKey{}, address)
+	if KeeperConfig.TransferTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(KeeperConfig.TransferTimeout)*time.Second)
+		defer cancel()
+	}
+
+	c.proxy.ServeHTTP(w, r.WithContext(ctx))
+}
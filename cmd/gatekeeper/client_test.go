@@ -0,0 +1,89 @@
+package gatekeeper
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestBreakerTransitions(t *testing.T) {
+	const threshold = 2
+	tests := []struct {
+		name  string
+		steps []string // fail, success, abandon, allow (cooldown passed) or deny (cooldown running)
+		state breakerState
+	}{
+		{name: "failures below the threshold", steps: []string{"fail"}, state: breakerClosed},
+		{name: "failures reach the threshold", steps: []string{"fail", "fail"}, state: breakerOpen},
+		{name: "success resets the failures", steps: []string{"fail", "success", "fail"}, state: breakerClosed},
+		{name: "open during the cooldown", steps: []string{"fail", "fail", "deny"}, state: breakerOpen},
+		{name: "half-open after the cooldown", steps: []string{"fail", "fail", "allow"}, state: breakerHalfOpen},
+		{name: "single probe while half-open", steps: []string{"fail", "fail", "allow", "deny"}, state: breakerHalfOpen},
+		{name: "probe success closes", steps: []string{"fail", "fail", "allow", "success"}, state: breakerClosed},
+		{name: "probe failure reopens", steps: []string{"fail", "fail", "allow", "fail", "deny"}, state: breakerOpen},
+		{name: "abandoned probe reopens", steps: []string{"fail", "fail", "allow", "abandon"}, state: breakerOpen},
+		{name: "abandoned probe lets the next request probe", steps: []string{"fail", "fail", "allow", "abandon", "allow"}, state: breakerHalfOpen},
+		{name: "abandon keeps a closed breaker", steps: []string{"fail", "abandon"}, state: breakerClosed},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b := &breaker{}
+			for i, step := range test.steps {
+				switch step {
+				case "fail":
+					b.failure(threshold)
+				case "success":
+					b.success()
+				case "abandon":
+					b.abandon()
+				case "allow":
+					if !b.allow(0) {
+						t.Fatalf("step %d: request denied", i)
+					}
+				case "deny":
+					if b.allow(time.Hour) {
+						t.Fatalf("step %d: request allowed", i)
+					}
+				}
+			}
+			if b.state != test.state {
+				t.Errorf("state %s, want %s", b.state, test.state)
+			}
+		})
+	}
+}
+
+func TestRoundTripCanceledProbe(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	client := &VaultClient{transport: &http.Transport{}, breakers: make(map[string]*breaker)}
+	address := server.Listener.Addr().String()
+	b := client.breakerFor(address)
+	b.state = breakerOpen
+	b.openedAt = time.Now().Add(-time.Hour)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.AfterFunc(50*time.Millisecond, cancel)
+	_, err = client.RoundTrip(req)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("error %v, want %v", err, context.Canceled)
+	}
+
+	if state := client.BreakerStates()[address]; state != "open" {
+		t.Errorf("state %s after a canceled probe, want open", state)
+	}
+	if !b.allow(time.Minute) {
+		t.Errorf("next request denied after a canceled probe")
+	}
+}
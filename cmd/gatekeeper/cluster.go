@@ -0,0 +1,175 @@
+package gatekeeper
+
+import (
+	"datavault/cmd/internal"
+	"log"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/serialx/hashring"
+)
+
+// Cluster is the set of vaults the gatekeeper routes requests to
+//
+// A cluster is never modified once built, membership changes swap it for a new one.
+type Cluster struct {
+	Addresses   []string              // Addresses of the vaults, sorted
+	Topology    map[string]VaultEntry // Vault entries by address
+	Ring        *hashring.HashRing    // Consistency hash ring
+	RingWeights map[string]int        // Weights the hash ring was built with
+	Version     uint64                // Version of the shared ring record, 0 when the ring is not shared
+}
+
+var (
+	currentCluster atomic.Pointer[Cluster] // Cluster used to route requests
+	clusterMu      sync.Mutex              // Serializes cluster updates
+	gossipNode     *internal.GossipNode    // Gossip membership of the gatekeeper, nil when disabled
+)
+
+// CurrentCluster returns the cluster used to route requests
+func CurrentCluster() *Cluster {
+	return currentCluster.Load()
+}
+
+// Replicas returns the number of replicas of each group, bounded by the number of vaults
+func (c *Cluster) Replicas() int {
+	return min(KeeperConfig.Replicas, len(c.Addresses))
+}
+
+// WriteQuorum returns the number of replicas that must acknowledge a write
+func (c *Cluster) WriteQuorum() int {
+	return min(KeeperConfig.WriteQuorum, c.Replicas())
+}
+
+// ReadQuorum returns the number of replicas that must answer a read
+func (c *Cluster) ReadQuorum() int {
+	return min(KeeperConfig.ReadQuorum, c.Replicas())
+}
+
+// BuildCluster builds a cluster from vault entries, the first entry of an address wins
+func BuildCluster(vaults []VaultEntry) *Cluster {
+	return BuildClusterWithWeights(vaults, nil, 0)
+}
+
+// BuildClusterWithWeights builds a cluster with the ring weights of a shared ring record
+//
+// Nil weights are computed from the configured weight mode, vaults missing
+// from the weights get a weight of 1.
+func BuildClusterWithWeights(vaults []VaultEntry, weights map[string]int, version uint64) *Cluster {
+	cluster := &Cluster{
+		Addresses: make([]string, 0, len(vaults)),
+		Topology:  make(map[string]VaultEntry),
+		Version:   version,
+	}
+	for _, vault := range vaults {
+		if _, ok := cluster.Topology[vault.Address]; ok {
+			continue
+		}
+		cluster.Addresses = append(cluster.Addresses, vault.Address)
+		cluster.Topology[vault.Address] = vault
+	}
+	sort.Strings(cluster.Addresses)
+
+	if weights == nil {
+		cluster.RingWeights = ComputeRingWeights(cluster.Addresses)
+	} else {
+		cluster.RingWeights = make(map[string]int, len(cluster.Addresses))
+		for _, address := range cluster.Addresses {
+			cluster.RingWeights[address] = max(weights[address], 1)
+		}
+	}
+	cluster.Ring = hashring.NewWithWeights(cluster.RingWeights)
+
+	return cluster
+}
+
+// UpdateCluster rebuilds the cluster from the configured vaults and the vaults known through gossip
+//
+// Dead vaults stay in the ring until gossip forgets them, so that their
+// groups keep routing to them and receive hints. Vaults that left are removed.
+// With a shared ring the change is proposed to the vaults instead.
+func UpdateCluster() {
+	if SharedRingEnabled() {
+		TriggerRingSync()
+		return
+	}
+
+	clusterMu.Lock()
+	defer clusterMu.Unlock()
+
+	vaults, _ := knownVaults()
+	installCluster(BuildCluster(vaults))
+}
+
+// knownVaults returns the configured vaults and the vaults known through gossip, and the vaults that left
+func knownVaults() ([]VaultEntry, map[string]bool) {
+	vaults := append(make([]VaultEntry, 0, len(KeeperConfig.Vaults)), KeeperConfig.Vaults...)
+	left := make(map[string]bool)
+	if gossipNode != nil {
+		for _, member := range gossipNode.Members() {
+			if member.Role != "vault" || member.Service == "" {
+				continue
+			}
+			if member.State == internal.MemberLeft {
+				left[member.Service] = true
+				continue
+			}
+			vaults = append(vaults, VaultEntry{
+				Address: member.Service,
+				Zone:    member.Labels["zone"],
+				Rack:    member.Labels["rack"],
+				Host:    member.Labels["host"],
+			})
+		}
+	}
+
+	return vaults, left
+}
+
+// installCluster swaps the cluster used to route requests and logs ring changes
+func installCluster(cluster *Cluster) {
+	previous := CurrentCluster()
+	currentCluster.Store(cluster)
+
+	if previous == nil || !equalWeights(previous.RingWeights, cluster.RingWeights) {
+		for _, address := range cluster.Addresses {
+			log.Printf("Vault %s is in the ring with weight %d\n", address, cluster.RingWeights[address])
+		}
+	}
+}
+
+// equalWeights reports whether two rings have the same vaults and weights
+func equalWeights(a, b map[string]int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for address, weight := range a {
+		if b[address] != weight {
+			return false
+		}
+	}
+	return true
+}
+
+// StartGossip joins the gossip membership and follows vaults joining and leaving
+func StartGossip() error {
+	node, err := internal.NewGossipNode(*KeeperConfig.Gossip, internal.Member{
+		Role:    "gatekeeper",
+		Service: KeeperConfig.Advertise,
+	})
+	if err != nil {
+		return err
+	}
+
+	gossipNode = node
+	node.OnChange(func([]internal.Member) { UpdateCluster() })
+
+	err = node.Join(KeeperConfig.Gossip.Seeds)
+	if err != nil {
+		log.Printf("Error joining gossip seeds: %v\n", err)
+	}
+	UpdateCluster()
+
+	return nil
+}
@@ -0,0 +1,314 @@
+package gatekeeper
+
+import (
+	"bytes"
+	"datavault/cmd/internal"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// copySource is the source of a copy or a move and its elements, read from a quorum of its replicas
+type copySource struct {
+	Namespace string
+	GroupId   string
+	Records   []internal.Record   // Elements to copy
+	Holders   map[string][]string // Replicas holding each element, by element id
+	Addresses []string            // Replicas of the source group
+}
+
+// readCopySource reads the elements of a group from a read quorum of its replicas, a single element when elementId is not empty
+//
+// On failure the error is written and false is returned.
+func readCopySource(w http.ResponseWriter, namespace, groupId, elementId string) (copySource, bool) {
+	addresses := LocateGroup(namespace, groupId)
+	if len(addresses) == 0 {
+		http.Error(w, "group cannot be assigned to a vault", http.StatusBadRequest)
+		return copySource{}, false
+	}
+
+	query := url.Values{"namespace": {namespace}, "groupId": {groupId}}
+	results, listings, answered := ReadListings("/group", query, addresses)
+	if Acknowledged(results) < CurrentCluster().ReadQuorum() {
+		WriteReplicaFailure(w, "read quorum not reached", results)
+		return copySource{}, false
+	}
+	records, repairs := MergeListings(namespace, groupId, addresses, listings, answered)
+	RepairElements(repairs)
+
+	source := copySource{Namespace: namespace, GroupId: groupId, Holders: make(map[string][]string), Addresses: addresses}
+	for i, listing := range listings {
+		for _, record := range listing {
+			source.Holders[record.Id] = append(source.Holders[record.Id], addresses[i])
+		}
+	}
+	for _, record := range records {
+		if elementId == "" || record.Id == elementId {
+			source.Records = append(source.Records, record)
+		}
+	}
+	return source, true
+}
+
+// recordMeta returns the metadata of an element stored in the attributes of its record
+func recordMeta(record internal.Record) internal.Meta {
+	return internal.Meta{
+		FileId:        record.Id,
+		FileType:      record.Attributes["fileType"],
+		FileName:      record.Attributes["fileName"],
+		FileExtension: record.Attributes["fileExtension"],
+		FileSize:      record.Attributes["fileSize"],
+		ReceivedTime:  record.Attributes["receivedTime"],
+		GroupId:       record.Attributes["groupId"],
+		Namespace:     record.Attributes["namespace"],
+		Checksum:      record.Attributes["checksum"],
+		ExpiresAt:     record.Attributes["expiresAt"],
+		Key:           record.Attributes["key"],
+		Path:          record.Attributes["path"],
+		Retention:     internal.RetentionOf(record.Attributes),
+	}
+}
+
+// copyMeta returns the metadata of the copy of an element in another group
+//
+// The copy keeps the name, type, checksum, key, path and expiry of the
+// element. Its retention and legal hold stay with the source.
+func copyMeta(record internal.Record, namespace, groupId, receivedTime string) internal.Meta {
+	meta := recordMeta(record)
+	meta.FileId = strings.ReplaceAll(uuid.New().String(), "-", "")
+	meta.ReceivedTime = receivedTime
+	meta.GroupId = groupId
+	meta.Namespace = namespace
+	meta.Retention = internal.Retention{}
+	return meta
+}
+
+// copyRequestFor returns the copy request of a destination vault
+//
+// A vault holding an element copies it locally, the others fetch it from one
+// of the replicas holding it.
+func copyRequestFor(address string, source copySource, metadata []internal.Meta) internal.CopyRequest {
+	request := internal.CopyRequest{SourceNamespace: source.Namespace, SourceGroupId: source.GroupId}
+	for i, record := range source.Records {
+		element := internal.CopyElement{SourceId: record.Id, Meta: metadata[i]}
+		holders := source.Holders[record.Id]
+		if !slices.Contains(holders, address) && len(holders) > 0 {
+			element.Source = holders[0]
+		}
+		request.Elements = append(request.Elements, element)
+	}
+	return request
+}
+
+// CopyElements copies the elements of a source into a group on every one of its replicas
+//
+// metadata holds the metadata of the copies in the order of the source
+// records. The metadata of the group is written along with them when group is
+// not nil. token is the rollback token of the copy, empty for none.
+func CopyElements(source copySource, namespace, groupId string, addresses []string, metadata []internal.Meta, group *internal.GroupMeta, token string) []ReplicaResult {
+	query := url.Values{"namespace": {namespace}, "groupId": {groupId}}
+	if token != "" {
+		query.Set(internal.RollbackParam, token)
+	}
+	results := make([]ReplicaResult, len(addresses))
+	forEachVault(addresses, func(i int, address string) {
+		request := copyRequestFor(address, source, metadata)
+		request.Group = group
+		body, err := json.Marshal(request)
+		if err != nil {
+			results[i] = ReplicaResult{Address: address, Err: err}
+			return
+		}
+		results[i] = sendToVault(http.MethodPost, address, "/group/copy", query, "application/json", bytes.NewReader(body))
+	})
+	return results
+}
+
+// RollbackCopy deletes the copies stored by the replicas that acknowledged a copy sent with a rollback token
+func RollbackCopy(namespace, groupId, token string, metadata []internal.Meta, results []ReplicaResult) {
+	for _, result := range results {
+		if !result.OK() {
+			continue
+		}
+		for _, meta := range metadata {
+			query := url.Values{"namespace": {namespace}, "groupId": {groupId}, "elementId": {meta.FileId}, internal.PurgeParam: {"true"}, internal.RollbackParam: {token}}
+			rollback := sendToVault(http.MethodDelete, result.Address, "/group/element", query, "", nil)
+			if !rollback.OK() {
+				log.Printf("Error rolling back copy %s: %s\n", meta.FileId, rollback)
+			}
+		}
+	}
+}
+
+// deleteCopySource deletes the source of a move for good on every replica, the whole group when no element is named
+func deleteCopySource(source copySource, elementId string) []ReplicaResult {
+	query := url.Values{"namespace": {source.Namespace}, "groupId": {source.GroupId}, internal.PurgeParam: {"true"}}
+	path := "/group"
+	if elementId != "" {
+		query.Set("elementId", elementId)
+		path = "/group/element"
+	}
+	results := FanOutRequest(http.MethodDelete, path, query, source.Addresses)
+	AcknowledgeNotFound(results)
+	return results
+}
+
+// reserveCopyQuota reserves the usage of copied records in the quota of the destination group
+//
+// The returned function releases the reservation. On failure the error is
+// written and false is returned.
+func reserveCopyQuota(w http.ResponseWriter, namespace, groupId string, records []internal.Record) (func(), bool) {
+	if !QuotaApplies(namespace, groupId) {
+		return func() {}, true
+	}
+	usage := internal.Usage{Files: int64(len(records))}
+	for _, record := range records {
+		size, _ := strconv.ParseInt(record.Attributes["fileSize"], 10, 64)
+		usage.Bytes += size
+	}
+	release, err := ReserveQuota(namespace, groupId, usage)
+	if err != nil {
+		http.Error(w, err.Error(), QuotaStatus(err))
+		return nil, false
+	}
+	return release, true
+}
+
+// placeCopy returns the available vaults of the destination group of a copy
+//
+// Copies are not stored as hints, the write quorum must be reached by
+// available vaults. On failure the error is written and false is returned.
+func placeCopy(w http.ResponseWriter, namespace, groupId string) (Placement, bool) {
+	placement, err := PlaceGroup(namespace, groupId)
+	if errors.Is(err, ErrNoWritableVault) {
+		http.Error(w, err.Error(), http.StatusInsufficientStorage)
+		return Placement{}, false
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return Placement{}, false
+	}
+	return placement, true
+}
+
+// handleCopy copies or moves an element, or every element of a group, into another group
+//
+// The copy is acknowledged once the write quorum of the destination replicas
+// stored every element, and rolled back otherwise. A move then deletes the
+// source; when no replica of the source could delete it, the copy is rolled
+// back so that the move either happened or did not.
+func handleCopy(w http.ResponseWriter, r *http.Request, elementId string, move bool) {
+	namespace := RequestNamespace(r)
+	groupId := r.URL.Query().Get("groupId")
+	targetGroupId := r.URL.Query().Get(internal.TargetGroupParam)
+	targetNamespace := r.URL.Query().Get(internal.TargetNamespaceParam)
+	if targetNamespace == "" {
+		targetNamespace = namespace
+	}
+	if targetGroupId == "" {
+		http.Error(w, internal.TargetGroupParam+" is required", http.StatusBadRequest)
+		return
+	}
+	if err := internal.ValidateNamespace(targetNamespace); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if targetNamespace == namespace && targetGroupId == groupId {
+		http.Error(w, "source and destination are the same group", http.StatusBadRequest)
+		return
+	}
+	if !CanAccess(r, PermissionRead, namespace, groupId) || !CanAccess(r, PermissionWrite, targetNamespace, targetGroupId) {
+		http.Error(w, "permission denied: read on group "+groupId+" of namespace "+namespace+" and write on group "+targetGroupId+" of namespace "+targetNamespace, http.StatusForbidden)
+		return
+	}
+
+	source, ok := readCopySource(w, namespace, groupId, elementId)
+	if !ok {
+		return
+	}
+	if len(source.Records) == 0 {
+		http.Error(w, "no element to copy", http.StatusNotFound)
+		return
+	}
+
+	release, ok := reserveCopyQuota(w, targetNamespace, targetGroupId, source.Records)
+	if !ok {
+		return
+	}
+	defer release()
+
+	placement, ok := placeCopy(w, targetNamespace, targetGroupId)
+	if !ok {
+		return
+	}
+
+	receivedTime := fmt.Sprintf("%d", time.Now().UnixMilli())
+	metadata := make([]internal.Meta, len(source.Records))
+	for i, record := range source.Records {
+		metadata[i] = copyMeta(record, targetNamespace, targetGroupId, receivedTime)
+	}
+	token := NewRollbackToken()
+	results := CopyElements(source, targetNamespace, targetGroupId, placement.Vaults, metadata, nil, token)
+	if Acknowledged(results) < CurrentCluster().WriteQuorum() {
+		RollbackCopy(targetNamespace, targetGroupId, token, metadata, results)
+		WriteReplicaFailure(w, "write quorum not reached", results)
+		return
+	}
+	for _, meta := range metadata {
+		internal.AuditElements(r, meta.FileId)
+	}
+
+	if move {
+		deletes := deleteCopySource(source, elementId)
+		if Acknowledged(deletes) == 0 {
+			RollbackCopy(targetNamespace, targetGroupId, token, metadata, results)
+			WriteReplicaFailure(w, "source could not be deleted, move rolled back", deletes)
+			return
+		}
+		if Acknowledged(deletes) < CurrentCluster().WriteQuorum() {
+			WriteReplicaFailure(w, "elements were moved but the source is left on some replicas", deletes)
+			return
+		}
+	}
+
+	WriteReplicaResults(w, results, CurrentCluster().WriteQuorum())
+}
+
+// HandlerElementCopy copies an element into another group
+func HandlerElementCopy(w http.ResponseWriter, r *http.Request) {
+	elementId := r.URL.Query().Get("elementId")
+	if elementId == "" {
+		http.Error(w, "elementId is required", http.StatusBadRequest)
+		return
+	}
+	handleCopy(w, r, elementId, false)
+}
+
+// HandlerElementMove moves an element into another group
+func HandlerElementMove(w http.ResponseWriter, r *http.Request) {
+	elementId := r.URL.Query().Get("elementId")
+	if elementId == "" {
+		http.Error(w, "elementId is required", http.StatusBadRequest)
+		return
+	}
+	handleCopy(w, r, elementId, true)
+}
+
+// HandlerGroupCopy copies every element of a group into another group
+func HandlerGroupCopy(w http.ResponseWriter, r *http.Request) {
+	handleCopy(w, r, "", false)
+}
+
+// HandlerGroupMove moves every element of a group into another group and deletes the source group
+func HandlerGroupMove(w http.ResponseWriter, r *http.Request) {
+	handleCopy(w, r, "", true)
+}
@@ -0,0 +1,263 @@
+package gatekeeper
+
+import (
+	"datavault/cmd/internal"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// EventsConfig is the configuration of the change events aggregated from the vaults
+type EventsConfig struct {
+	Buffer       int    `json:"buffer"`        // Events kept for clients resuming a stream, defaults to 10000
+	PollInterval int    `json:"poll_interval"` // Interval between polls of the vaults in milliseconds, defaults to 1000
+	WebhooksFile string `json:"webhooks_file"` // File storing the registered webhooks, empty keeps them in memory only
+}
+
+// eventsDedupWindow is how long an event is remembered to drop its copies reported by other replicas
+const eventsDedupWindow = time.Minute
+
+// eventsPollLimit is the number of events read from a vault per request
+const eventsPollLimit = 1000
+
+// eventsHeartbeat is the interval of the comments keeping idle event streams open
+const eventsHeartbeat = 15 * time.Second
+
+var (
+	events *internal.EventBuffer // Events aggregated from the vaults, nil when disabled
+
+	eventsMu     sync.Mutex
+	vaultCursors = make(map[string]string) // Cursor of the last event read from each vault
+	seenEvents   = make(map[string]int64)  // Expiry in unix milliseconds of the events already aggregated, by dedup key
+)
+
+// EventsEnabled reports whether the gatekeeper aggregates the change events of the vaults
+func EventsEnabled() bool {
+	return KeeperConfig.Events != nil
+}
+
+// InitEvents creates the event buffer and loads the registered webhooks
+func InitEvents() error {
+	if KeeperConfig.Events.Buffer <= 0 {
+		KeeperConfig.Events.Buffer = 10000
+	}
+	if KeeperConfig.Events.PollInterval <= 0 {
+		KeeperConfig.Events.PollInterval = 1000
+	}
+	events = internal.NewEventBuffer(KeeperConfig.Events.Buffer)
+	return LoadWebhooks()
+}
+
+// eventKey returns the key identifying the copies of an event reported by the replicas of a group
+func eventKey(eventType, namespace, groupId, elementId string) string {
+	return eventType + "|" + namespace + "|" + groupId + "|" + elementId
+}
+
+// ingestEvent appends an event read from a vault unless a replica already reported it, and dispatches it to the webhooks
+//
+// Seeing an element created forgets its deletion and the deletion of its group,
+// and the other way around, so that an element restored or stored again is
+// reported again.
+func ingestEvent(event internal.Event, now time.Time) {
+	key := eventKey(event.Type, event.Namespace, event.GroupId, event.ElementId)
+	if expiry, ok := seenEvents[key]; ok && expiry > now.UnixMilli() {
+		return
+	}
+	seenEvents[key] = now.Add(eventsDedupWindow).UnixMilli()
+
+	switch event.Type {
+	case internal.EventElementCreated:
+		delete(seenEvents, eventKey(internal.EventElementDeleted, event.Namespace, event.GroupId, event.ElementId))
+		delete(seenEvents, eventKey(internal.EventGroupDeleted, event.Namespace, event.GroupId, ""))
+	case internal.EventElementDeleted:
+		delete(seenEvents, eventKey(internal.EventElementCreated, event.Namespace, event.GroupId, event.ElementId))
+	}
+
+	DispatchWebhooks(events.Append(event))
+}
+
+// pollVaultEvents reads the events of a vault following its cursor
+//
+// The first poll of a vault only reads its cursor, events that happened
+// before the gatekeeper started are not reported.
+func pollVaultEvents(address, cursor string) (internal.EventBatch, error) {
+	query := url.Values{"limit": {strconv.Itoa(eventsPollLimit)}}
+	if cursor != "" {
+		query.Set("cursor", cursor)
+	}
+	resp, err := vaultClient.Get(address, "/events", query)
+	if err != nil {
+		return internal.EventBatch{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return internal.EventBatch{}, fmt.Errorf("vault %s answered %s", address, resp.Status)
+	}
+
+	var batch internal.EventBatch
+	err = json.NewDecoder(resp.Body).Decode(&batch)
+	if err != nil {
+		return internal.EventBatch{}, err
+	}
+	return batch, nil
+}
+
+// CollectEvents reads the new events of every vault of the cluster and aggregates them
+func CollectEvents() {
+	addresses := CurrentCluster().Addresses
+
+	eventsMu.Lock()
+	for address := range vaultCursors {
+		if !slices.Contains(addresses, address) {
+			delete(vaultCursors, address)
+		}
+	}
+	now := time.Now()
+	for key, expiry := range seenEvents {
+		if expiry <= now.UnixMilli() {
+			delete(seenEvents, key)
+		}
+	}
+	eventsMu.Unlock()
+
+	forEachVault(addresses, func(_ int, address string) {
+		eventsMu.Lock()
+		cursor := vaultCursors[address]
+		eventsMu.Unlock()
+
+		for {
+			batch, err := pollVaultEvents(address, cursor)
+			if err != nil {
+				return
+			}
+			if batch.Reset && cursor != "" {
+				log.Printf("Events of vault %s may have been missed, resuming from its oldest event\n", address)
+			}
+
+			eventsMu.Lock()
+			for _, event := range batch.Events {
+				ingestEvent(event, time.Now())
+			}
+			vaultCursors[address] = batch.Cursor
+			eventsMu.Unlock()
+
+			cursor = batch.Cursor
+			if len(batch.Events) < eventsPollLimit {
+				return
+			}
+		}
+	})
+}
+
+// EventCollector periodically aggregates the events of the vaults
+func EventCollector() {
+	ticker := time.NewTicker(time.Duration(KeeperConfig.Events.PollInterval) * time.Millisecond)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		CollectEvents()
+	}
+}
+
+// writeEvent writes an event to a Server-Sent Events stream
+func writeEvent(w http.ResponseWriter, id, eventType string, data any) error {
+	content, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if id != "" {
+		_, err = fmt.Fprintf(w, "id: %s\n", id)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", eventType, content)
+	return err
+}
+
+// HandlerEvents streams the change events of the groups the client can read as Server-Sent Events
+//
+// Events are limited to the namespace of the request and to one group with
+// groupId. The stream resumes after the cursor given by the cursor parameter
+// or the Last-Event-ID header, and starts with a reset event when that cursor
+// is too old and events may have been missed.
+func HandlerEvents(w http.ResponseWriter, r *http.Request) {
+	if !EventsEnabled() {
+		http.Error(w, "events are disabled", http.StatusNotFound)
+		return
+	}
+
+	namespace := RequestNamespace(r)
+	groupId := r.URL.Query().Get("groupId")
+	if groupId != "" && !CanAccess(r, PermissionRead, namespace, groupId) {
+		http.Error(w, "permission denied: read on group "+groupId+" of namespace "+namespace, http.StatusForbidden)
+		return
+	}
+	cursor := r.URL.Query().Get("cursor")
+	if cursor == "" {
+		cursor = r.Header.Get("Last-Event-ID")
+	}
+
+	batch, changed, err := events.Since(cursor, eventsPollLimit)
+	if errors.Is(err, internal.ErrInvalidCursor) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	controller := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	heartbeat := time.NewTicker(eventsHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		if batch.Reset {
+			err = writeEvent(w, "", "reset", map[string]string{"cursor": batch.Cursor})
+			if err != nil {
+				return
+			}
+		}
+		for _, event := range batch.Events {
+			if event.Namespace != namespace || (groupId != "" && event.GroupId != groupId) {
+				continue
+			}
+			if !CanAccess(r, PermissionRead, event.Namespace, event.GroupId) {
+				continue
+			}
+			err = writeEvent(w, event.Id, event.Type, event)
+			if err != nil {
+				return
+			}
+		}
+		if controller.Flush() != nil {
+			return
+		}
+
+		// A partial batch reached the last event, wait for newer ones
+	wait:
+		for len(batch.Events) < eventsPollLimit {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-heartbeat.C:
+				_, err = fmt.Fprint(w, ": heartbeat\n\n")
+				if err != nil || controller.Flush() != nil {
+					return
+				}
+			case <-changed:
+				break wait
+			}
+		}
+
+		batch, changed, _ = events.Since(batch.Cursor, eventsPollLimit)
+	}
+}
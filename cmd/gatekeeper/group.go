@@ -0,0 +1,306 @@
+package gatekeeper
+
+import (
+	"bytes"
+	"datavault/cmd/internal"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
+)
+
+// ReadGroupMeta reads the metadata of a group from its replicas
+//
+// The most recently updated metadata wins, with the oldest creation time
+// reported by the replicas. The results are returned with whether a read
+// quorum answered.
+func ReadGroupMeta(namespace, groupId string, addresses []string) (internal.GroupMeta, []ReplicaResult, bool) {
+	query := url.Values{"namespace": {namespace}, "groupId": {groupId}}
+	results := FanOutRequest(http.MethodGet, "/group/meta", query, addresses)
+
+	var merged internal.GroupMeta
+	for i, result := range results {
+		if !result.OK() {
+			continue
+		}
+		var meta internal.GroupMeta
+		err := json.Unmarshal(result.Body, &meta)
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+
+		createdAt := merged.CreatedAt
+		if meta.CreatedAt > 0 && (createdAt == 0 || meta.CreatedAt < createdAt) {
+			createdAt = meta.CreatedAt
+		}
+		if meta.UpdatedAt >= merged.UpdatedAt {
+			merged = meta
+		}
+		merged.CreatedAt = createdAt
+	}
+
+	return merged, results, Acknowledged(results) >= CurrentCluster().ReadQuorum()
+}
+
+// GroupRetentionLocked reads the retention of a group from its replicas and reports whether it is locked at now
+//
+// The group is locked when one of the replicas that answered holds it locked.
+// The results are returned with whether a read quorum answered.
+func GroupRetentionLocked(namespace, groupId string, addresses []string, now time.Time) (bool, []ReplicaResult, bool) {
+	query := url.Values{"namespace": {namespace}, "groupId": {groupId}}
+	results := FanOutRequest(http.MethodGet, "/group/retention", query, addresses)
+
+	locked := false
+	for i, result := range results {
+		if !result.OK() {
+			continue
+		}
+		var retention internal.Retention
+		err := json.Unmarshal(result.Body, &retention)
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+		locked = locked || retention.Locked(now)
+	}
+
+	return locked, results, Acknowledged(results) >= CurrentCluster().ReadQuorum()
+}
+
+// HandlerGroupMeta returns the metadata of a group
+func HandlerGroupMeta(w http.ResponseWriter, r *http.Request) {
+	namespace := RequestNamespace(r)
+	groupId := r.URL.Query().Get("groupId")
+	addresses := LocateGroup(namespace, groupId)
+	if len(addresses) == 0 {
+		http.Error(w, "group cannot be assigned to a vault", http.StatusBadRequest)
+		return
+	}
+
+	meta, results, ok := ReadGroupMeta(namespace, groupId, addresses)
+	if !ok {
+		WriteReplicaFailure(w, "read quorum not reached", results)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(meta)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// HandlerGroupMetaPut replaces the description, owner and labels of a group on every replica
+//
+// The metadata is the JSON body of the request. The creation time of the group
+// is kept, and the update time is set so that the replicas store the same
+// metadata.
+func HandlerGroupMetaPut(w http.ResponseWriter, r *http.Request) {
+	namespace := RequestNamespace(r)
+	groupId := r.URL.Query().Get("groupId")
+	addresses := LocateGroup(namespace, groupId)
+	if len(addresses) == 0 {
+		http.Error(w, "group cannot be assigned to a vault", http.StatusBadRequest)
+		return
+	}
+
+	var meta internal.GroupMeta
+	err := json.NewDecoder(r.Body).Decode(&meta)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = meta.Validate()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	current, results, ok := ReadGroupMeta(namespace, groupId, addresses)
+	if !ok {
+		WriteReplicaFailure(w, "read quorum not reached", results)
+		return
+	}
+	now := time.Now().UnixMilli()
+	meta.CreatedAt = current.CreatedAt
+	if meta.CreatedAt == 0 {
+		meta.CreatedAt = now
+	}
+	meta.UpdatedAt = now
+
+	body, err := json.Marshal(meta)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	query := url.Values{"namespace": {namespace}, "groupId": {groupId}}
+	results = make([]ReplicaResult, len(addresses))
+	forEachVault(addresses, func(i int, address string) {
+		results[i] = sendToVault(http.MethodPut, address, "/group/meta", query, "application/json", bytes.NewReader(body))
+	})
+	WriteReplicaResults(w, results, CurrentCluster().WriteQuorum())
+}
+
+// rollbackRename undoes a rename on the replicas that acknowledged it
+//
+// Vaults that fetched the group delete their copy, and vaults that renamed it
+// rename it back.
+func rollbackRename(namespace, groupId, targetGroupId string, fetched, renamed []ReplicaResult) {
+	purge := url.Values{"namespace": {namespace}, "groupId": {targetGroupId}, internal.PurgeParam: {"true"}}
+	for _, result := range fetched {
+		if !result.OK() {
+			continue
+		}
+		rollback := sendToVault(http.MethodDelete, result.Address, "/group", purge, "", nil)
+		if !rollback.OK() {
+			log.Printf("Error rolling back rename of group %s: %s\n", groupId, rollback)
+		}
+	}
+
+	back := url.Values{"namespace": {namespace}, "groupId": {targetGroupId}, internal.TargetGroupParam: {groupId}}
+	for _, result := range renamed {
+		if !result.OK() {
+			continue
+		}
+		rollback := sendToVault(http.MethodPost, result.Address, "/group/rename", back, "", nil)
+		if !rollback.OK() {
+			log.Printf("Error rolling back rename of group %s: %s\n", groupId, rollback)
+		}
+	}
+}
+
+// HandlerGroupRename renames a group, keeping its elements, their ids and the metadata of the group
+//
+// Vaults holding the group and placed to hold the new name rename it in place.
+// When the new name hashes to other vaults, they fetch the elements from the
+// replicas of the group, and the vaults no longer placed to hold it delete it.
+// The rename is rolled back unless the write quorum of the new name
+// acknowledged it, and groups locked by retention are not renamed.
+func HandlerGroupRename(w http.ResponseWriter, r *http.Request) {
+	namespace := RequestNamespace(r)
+	groupId := r.URL.Query().Get("groupId")
+	targetGroupId := r.URL.Query().Get(internal.TargetGroupParam)
+	if targetGroupId == "" {
+		http.Error(w, internal.TargetGroupParam+" is required", http.StatusBadRequest)
+		return
+	}
+	if targetGroupId == groupId {
+		http.Error(w, "source and destination are the same group", http.StatusBadRequest)
+		return
+	}
+	if !CanAccess(r, PermissionRead, namespace, groupId) || !CanAccess(r, PermissionWrite, namespace, targetGroupId) {
+		http.Error(w, "permission denied: read on group "+groupId+" and write on group "+targetGroupId+" of namespace "+namespace, http.StatusForbidden)
+		return
+	}
+
+	source, ok := readCopySource(w, namespace, groupId, "")
+	if !ok {
+		return
+	}
+	meta, results, ok := ReadGroupMeta(namespace, groupId, source.Addresses)
+	if !ok {
+		WriteReplicaFailure(w, "read quorum not reached", results)
+		return
+	}
+	if len(source.Records) == 0 && meta.UpdatedAt == 0 {
+		http.Error(w, "group not found", http.StatusNotFound)
+		return
+	}
+	now := time.Now()
+	locked, results, ok := GroupRetentionLocked(namespace, groupId, source.Addresses, now)
+	if !ok {
+		WriteReplicaFailure(w, "read quorum not reached", results)
+		return
+	}
+	if locked {
+		http.Error(w, fmt.Sprintf("%v: group %s", internal.ErrRetentionLocked, groupId), http.StatusLocked)
+		return
+	}
+	for _, record := range source.Records {
+		if internal.RetentionOf(record.Attributes).Locked(now) {
+			http.Error(w, fmt.Sprintf("%v: element %s", internal.ErrRetentionLocked, record.Id), http.StatusLocked)
+			return
+		}
+	}
+
+	// The new name must be free on the vaults it is placed on
+	query := url.Values{"namespace": {namespace}, "groupId": {targetGroupId}}
+	_, listings, _ := ReadListings("/group", query, LocateGroup(namespace, targetGroupId))
+	for _, listing := range listings {
+		if len(listing) > 0 {
+			http.Error(w, "group "+targetGroupId+" already exists", http.StatusConflict)
+			return
+		}
+	}
+
+	release, ok := reserveCopyQuota(w, namespace, targetGroupId, source.Records)
+	if !ok {
+		return
+	}
+	defer release()
+
+	placement, ok := placeCopy(w, namespace, targetGroupId)
+	if !ok {
+		return
+	}
+	var local, fetch, drop []string
+	for _, address := range placement.Vaults {
+		if slices.Contains(source.Addresses, address) {
+			local = append(local, address)
+		} else {
+			fetch = append(fetch, address)
+		}
+	}
+	for _, address := range source.Addresses {
+		if !slices.Contains(placement.Vaults, address) {
+			drop = append(drop, address)
+		}
+	}
+
+	metadata := make([]internal.Meta, len(source.Records))
+	for i, record := range source.Records {
+		metadata[i] = recordMeta(record)
+		metadata[i].GroupId = targetGroupId
+	}
+
+	// Vaults fetch the elements before the vaults holding the group rename it away
+	fetched := CopyElements(source, namespace, targetGroupId, fetch, metadata, &meta, "")
+	rename := url.Values{"namespace": {namespace}, "groupId": {groupId}, internal.TargetGroupParam: {targetGroupId}}
+	renamed := FanOutRequest(http.MethodPost, "/group/rename", rename, local)
+	results = append(slices.Clone(fetched), renamed...)
+	locked = slices.ContainsFunc(results, func(result ReplicaResult) bool {
+		return result.StatusCode == http.StatusLocked
+	})
+	if locked || Acknowledged(results) < CurrentCluster().WriteQuorum() {
+		rollbackRename(namespace, groupId, targetGroupId, fetched, renamed)
+		WriteReplicaFailure(w, "group could not be renamed", results)
+		return
+	}
+
+	if len(drop) > 0 {
+		purge := url.Values{"namespace": {namespace}, "groupId": {groupId}, internal.PurgeParam: {"true"}}
+		deletes := FanOutRequest(http.MethodDelete, "/group", purge, drop)
+		AcknowledgeNotFound(deletes)
+		if Acknowledged(deletes) == 0 {
+			rollbackRename(namespace, groupId, targetGroupId, fetched, renamed)
+			WriteReplicaFailure(w, "source could not be deleted, rename rolled back", deletes)
+			return
+		}
+		if Acknowledged(deletes) < len(drop) {
+			WriteReplicaFailure(w, "group was renamed but is left on some replicas", deletes)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(metadata)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
@@ -1,11 +1,13 @@
 package gatekeeper
 
 import (
+	"datavault/cmd/internal"
 	"encoding/json"
+	"errors"
+	"mime/multipart"
 	"net/http"
-	"net/http/httputil"
 	"net/url"
-	"sync"
+	"sort"
 	"time"
 )
 
@@ -16,35 +18,61 @@ func HandlerPing(w http.ResponseWriter, r *http.Request) {
 		"instance": "gatekeeper",
 		"extended": "",
 	}
-	// Ping every Vault in the configuration
-	vaultsNumber := len(KeeperConfig.Vaults)
+	// Ping every Vault in the cluster
+	cluster := CurrentCluster()
+	vaultsNumber := len(cluster.Addresses)
 	vaultsOnline := 0
 	vaultsFailed := make([]string, 0)
+	vaultsReadOnly := make([]string, 0)
 
 	// Broadcast the ping request to all vaults
-	responses := BroadcastGETRequest("http://", "/ping", KeeperConfig.Vaults)
+	results := FanOutRequest(http.MethodGet, "/ping", url.Values{}, cluster.Addresses)
 
 	// Check the responses
-	for i, resp := range responses {
-		if resp == nil { // Skip failed requests
-			vaultsFailed = append(vaultsFailed, KeeperConfig.Vaults[i])
+	for _, result := range results {
+		if !result.OK() {
+			vaultsFailed = append(vaultsFailed, result.Address)
 			continue
 		}
+		vaultsOnline++
 
-		if resp.StatusCode == http.StatusOK {
-			vaultsOnline++
-		} else {
-			vaultsFailed = append(vaultsFailed, KeeperConfig.Vaults[i])
+		var vaultResponse map[string]string
+		if json.Unmarshal(result.Body, &vaultResponse) == nil && vaultResponse["readOnly"] == "true" {
+			vaultsReadOnly = append(vaultsReadOnly, result.Address)
 		}
 	}
 
-	results := PingResults{
-		VaultsNumber: vaultsNumber,
-		VaultsOnline: vaultsOnline,
-		VaultsFailed: vaultsFailed,
+	// Report the vaults whose circuit breaker stops traffic
+	vaultsBreakerOpen := make([]string, 0)
+	for address, state := range vaultClient.BreakerStates() {
+		if state != "closed" {
+			vaultsBreakerOpen = append(vaultsBreakerOpen, address)
+		}
+	}
+	sort.Strings(vaultsBreakerOpen)
+
+	hintsPending := 0
+	if HintsEnabled() {
+		summaries, err := SummarizeHints()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for _, summary := range summaries {
+			hintsPending += summary.Hints
+		}
 	}
 
-	tbytes, err := json.Marshal(results)
+	pingResults := PingResults{
+		VaultsNumber:      vaultsNumber,
+		VaultsOnline:      vaultsOnline,
+		VaultsFailed:      vaultsFailed,
+		VaultsReadOnly:    vaultsReadOnly,
+		VaultsBreakerOpen: vaultsBreakerOpen,
+		HintsPending:      hintsPending,
+	}
+
+	tbytes, err := json.Marshal(pingResults)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -61,60 +89,334 @@ func HandlerPing(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// HandlerGroups returns a list of groups from all vaults
-func HandlerGroups(w http.ResponseWriter, r *http.Request) {
-	// Broadcast the request to all vaults
-	responses := BroadcastGETRequest("http://", "/groups", KeeperConfig.Vaults)
+// HandlerStats returns the capacity, usage and ring weight of every vault
+func HandlerStats(w http.ResponseWriter, r *http.Request) {
+	cluster := CurrentCluster()
+	stats := GetVaultStats(cluster.Addresses)
 
-	// Check the responses
-	groups := make([]string, 0)
-	for _, resp := range responses {
-		if resp == nil { // Skip failed requests
+	results := make([]VaultStats, 0, len(cluster.Addresses))
+	for _, vault := range cluster.Addresses {
+		vaultStats, ok := stats[vault]
+		if !ok {
 			continue
 		}
-		if resp.StatusCode == http.StatusOK {
-			var _groups []string
-			err := json.NewDecoder(resp.Body).Decode(&_groups)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-			groups = append(groups, _groups...)
+		vaultStats.Weight = cluster.RingWeights[vault]
+		results = append(results, vaultStats)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(results)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// HandlerHints returns the pending hints of every vault
+func HandlerHints(w http.ResponseWriter, r *http.Request) {
+	summaries := make([]HintsSummary, 0)
+	if HintsEnabled() {
+		var err error
+		summaries, err = SummarizeHints()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(summaries)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// HandlerMembers returns the gossip members known by the gatekeeper
+func HandlerMembers(w http.ResponseWriter, r *http.Request) {
+	members := make([]internal.Member, 0)
+	if gossipNode != nil {
+		members = gossipNode.Members()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(members)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// HandlerGroups returns a page of the groups of a namespace merged from all vaults, after the optional "after" cursor
+func HandlerGroups(w http.ResponseWriter, r *http.Request) {
+	limit, err := internal.ParseGroupsLimit(r.URL.Query().Get("limit"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Gather the groups of every vault the client can read
+	namespace := RequestNamespace(r)
+	var readable func(groupId string) bool
+	if AuthEnabled() {
+		readable = func(groupId string) bool {
+			return CanAccess(r, PermissionRead, namespace, groupId)
 		}
 	}
+	page := ListGroups(CurrentCluster().Addresses, namespace, r.URL.Query().Get("after"), limit, readable)
 
 	// Write the response
 	w.Header().Set("Content-Type", "application/json")
-	err := json.NewEncoder(w).Encode(groups)
+	err = json.NewEncoder(w).Encode(page)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 }
 
-// HandlerGroup returns a list of records in a group
+// HandlerGroup returns a list of records in a group, merged from a read quorum of its replicas
+//
+// The replicas list the elements whose path starts with the prefix parameter.
+// With a delimiter, the elements are browsed as the folder named by the prefix.
 func HandlerGroup(w http.ResponseWriter, r *http.Request) {
-	YxorpRequest(w, r, r.URL.Query().Get("groupId"))
+	namespace := RequestNamespace(r)
+	groupId := r.URL.Query().Get("groupId")
+	if r.Method == http.MethodHead {
+		YxorpGroupRequest(w, r, namespace, groupId)
+		return
+	}
+
+	records, ok := QuorumListing(w, r, namespace, groupId)
+	if !ok {
+		return
+	}
+
+	var group *internal.GroupMeta
+	if r.URL.Query().Get(internal.MetaParam) == "true" {
+		meta, results, ok := ReadGroupMeta(namespace, groupId, LocateGroup(namespace, groupId))
+		if !ok {
+			WriteReplicaFailure(w, "read quorum not reached", results)
+			return
+		}
+		group = &meta
+	}
+
+	var listing any = records
+	if delimiter := r.URL.Query().Get(internal.DelimiterParam); delimiter != "" {
+		folder := internal.ListFolder(records, r.URL.Query().Get(internal.PrefixParam), delimiter)
+		folder.Group = group
+		listing = folder
+	} else if group != nil {
+		listing = internal.GroupListing{Group: *group, Elements: records}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(listing)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// QuorumListing reads a list of records of a group from a read quorum of its replicas and merges them
+//
+// The request is forwarded to the vaults as is. Diverging replicas are repaired
+// in the background. On failure the error is written and false is returned.
+func QuorumListing(w http.ResponseWriter, r *http.Request, namespace, groupId string) ([]internal.Record, bool) {
+	addresses := LocateGroup(namespace, groupId)
+	if len(addresses) == 0 {
+		http.Error(w, "group cannot be assigned to a vault", http.StatusBadRequest)
+		return nil, false
+	}
+
+	results, listings, answered := ReadListings(r.URL.Path, r.URL.Query(), addresses)
+	if Acknowledged(results) < CurrentCluster().ReadQuorum() {
+		WriteReplicaFailure(w, "read quorum not reached", results)
+		return nil, false
+	}
+
+	records, repairs := MergeListings(namespace, groupId, addresses, listings, answered)
+	RepairElements(repairs)
+
+	return records, true
+}
+
+// ReadListings reads a list of records from every vault, reporting which vaults answered with a usable listing
+func ReadListings(path string, query url.Values, addresses []string) ([]ReplicaResult, [][]internal.Record, []bool) {
+	results := FanOutRequest(http.MethodGet, path, query, addresses)
+	listings := make([][]internal.Record, len(results))
+	answered := make([]bool, len(results))
+	for i, result := range results {
+		if !result.OK() {
+			continue
+		}
+		err := json.Unmarshal(result.Body, &listings[i])
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+		answered[i] = true
+	}
+	return results, listings, answered
 }
 
-// HandlerGroupUpload uploads files to a group
+// HandlerGroupUpload uploads files to a group, acknowledged once the write quorum of replicas stored them
 func HandlerGroupUpload(w http.ResponseWriter, r *http.Request) {
-	YxorpRequest(w, r, r.URL.Query().Get("groupId"))
+	namespace := RequestNamespace(r)
+	groupId := r.URL.Query().Get("groupId")
+	expiresAt, err := internal.ExpiresAt(r.URL.Query().Get(internal.TTLParam), time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	key := r.URL.Query().Get(internal.KeyParam)
+	if key != "" && internal.ValidateKey(key) != nil {
+		http.Error(w, internal.ErrInvalidKey.Error(), http.StatusBadRequest)
+		return
+	}
+	retention, err := internal.ParseRetention(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// Locking the uploaded elements changes their retention, which writers alone cannot do
+	if retention != (internal.Retention{}) && !CanAccess(r, PermissionRetention, namespace, groupId) {
+		http.Error(w, "permission denied: "+PermissionRetention+" on group "+groupId+" of namespace "+namespace, http.StatusForbidden)
+		return
+	}
+	retention, _ = internal.Retention{}.Update(retention, time.Now())
+	extract := r.URL.Query().Get(internal.ExtractParam) == "true"
+	folder := r.URL.Query().Get(internal.FolderParam)
+	if _, err := internal.JoinPath(folder, "file"); folder != "" && err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	placement, err := PlaceGroup(namespace, groupId)
+	if errors.Is(err, ErrNoWritableVault) {
+		http.Error(w, err.Error(), http.StatusInsufficientStorage)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	// A single replica is streamed straight to its vault, unless a quota needs the size of the upload or archives are extracted
+	quota := QuotaApplies(namespace, groupId)
+	if len(placement.Vaults) == 1 && len(placement.Hinted) == 0 && !quota && !extract {
+		YxorpRequest(w, r, placement.Vaults[0])
+		return
+	}
+
+	if KeeperConfig.MAX_UPLOAD_SIZE > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, KeeperConfig.MAX_UPLOAD_SIZE)
+	}
+	defer r.Body.Close()
+	if err := r.ParseMultipartForm(KeeperConfig.IN_MEMORY_UPLOAD_SIZE); err != nil {
+		http.Error(w, "files too large", http.StatusBadRequest)
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	files := r.MultipartForm.File["files"]
+	if len(files) == 0 {
+		http.Error(w, "No files uploaded", http.StatusBadRequest)
+		return
+	}
+
+	// The entries of uploaded archives are stored as elements, with their relative path
+	var paths []string
+	if extract {
+		var extracted *multipart.Form
+		files, paths, extracted, err = ExtractUpload(files)
+		if err != nil {
+			WriteExtractError(w, err)
+			return
+		}
+		defer extracted.RemoveAll()
+	}
+
+	if quota {
+		release, err := ReserveQuota(namespace, groupId, UploadUsage(files))
+		if err != nil {
+			http.Error(w, err.Error(), QuotaStatus(err))
+			return
+		}
+		defer release()
+	}
+
+	if key != "" && len(files) != 1 {
+		http.Error(w, "a key names a single file", http.StatusBadRequest)
+		return
+	}
+
+	preset := NewUploadMeta(files, internal.Meta{Namespace: namespace, GroupId: groupId, ExpiresAt: expiresAt, Key: key, Retention: retention})
+	for i := range preset {
+		switch {
+		case i < len(paths):
+			preset[i].Path, err = internal.JoinPath(folder, paths[i])
+		case folder != "":
+			preset[i].Path, err = internal.JoinPath(folder, files[i].Filename)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	for _, meta := range preset {
+		internal.AuditElements(r, meta.FileId)
+	}
+	query := internal.PresignedParams(r.URL.Query())
+	query.Set("namespace", namespace)
+	query.Set("groupId", groupId)
+	query.Set(internal.RollbackParam, NewRollbackToken())
+	results := ReplicateUpload(query, files, preset, placement.Vaults)
+	results = append(results, StoreHints(namespace, groupId, files, preset, placement.Hinted)...)
+	if Acknowledged(results) < CurrentCluster().WriteQuorum() {
+		RollbackUpload(namespace, groupId, query.Get(internal.RollbackParam), files, results)
+		WriteReplicaFailure(w, "write quorum not reached", results)
+		return
+	}
+
+	WriteReplicaResults(w, results, CurrentCluster().WriteQuorum())
 }
 
 // HandlerGroupDelete deletes a group
 func HandlerGroupDelete(w http.ResponseWriter, r *http.Request) {
-	YxorpRequest(w, r, r.URL.Query().Get("groupId"))
+	withTrashId(r)
+	YxorpReplicasRequest(w, r, RequestNamespace(r), r.URL.Query().Get("groupId"))
+}
+
+// HandlerGroupRetention returns the retention of a group
+func HandlerGroupRetention(w http.ResponseWriter, r *http.Request) {
+	YxorpGroupRequest(w, r, RequestNamespace(r), r.URL.Query().Get("groupId"))
+}
+
+// HandlerGroupRetentionPut changes the retention or legal hold of a group on every replica
+func HandlerGroupRetentionPut(w http.ResponseWriter, r *http.Request) {
+	YxorpReplicasRequest(w, r, RequestNamespace(r), r.URL.Query().Get("groupId"))
+}
+
+// HandlerElementRetentionPut changes the retention or legal hold of an element on every replica
+func HandlerElementRetentionPut(w http.ResponseWriter, r *http.Request) {
+	YxorpReplicasRequest(w, r, RequestNamespace(r), r.URL.Query().Get("groupId"))
 }
 
 // HandlerElementGet returns a record from a group
 func HandlerElementGet(w http.ResponseWriter, r *http.Request) {
-	YxorpRequest(w, r, r.URL.Query().Get("groupId"))
+	YxorpGroupRequest(w, r, RequestNamespace(r), r.URL.Query().Get("groupId"))
+}
+
+// HandlerGroupArchive streams the elements of a group as an archive built by one of its replicas
+func HandlerGroupArchive(w http.ResponseWriter, r *http.Request) {
+	YxorpGroupRequest(w, r, RequestNamespace(r), r.URL.Query().Get("groupId"))
 }
 
 // HandlerElementUpload uploads a record to a group
 func HandleElementDelete(w http.ResponseWriter, r *http.Request) {
-	YxorpRequest(w, r, r.URL.Query().Get("groupId"))
+	withTrashId(r)
+	YxorpReplicasRequest(w, r, RequestNamespace(r), r.URL.Query().Get("groupId"))
 }
 
 // PingResults is a struct to store the results of the ping request
@@ -122,51 +424,38 @@ type PingResults struct {
 	VaultsNumber int      `json:"vaults_number"`
 	VaultsOnline int      `json:"vaults_online"`
 	VaultsFailed []string `json:"vaults_failed"`
-}
 
-// BroadcastGETRequest sends a GET request to multiple addresses
-func BroadcastGETRequest(protocol, path string, addresses []string) (responses []*http.Response) {
-
-	responses = make([]*http.Response, len(addresses))
-
-	// Broadcast the request to all vaults using goroutines
-	wg := sync.WaitGroup{}
-	for i, address := range addresses {
-		url := protocol + address + path
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
+	VaultsReadOnly    []string `json:"vaults_read_only"`
+	VaultsBreakerOpen []string `json:"vaults_breaker_open"`
+	HintsPending      int      `json:"hints_pending"`
+}
 
-			client := &http.Client{
-				Timeout: time.Duration(KeeperConfig.BroadcastTimeout) * time.Second,
-			}
-			resp, err := client.Get(url)
-			if err != nil {
-				return
-			}
-			responses[i] = resp
-		}()
+// YxorpGroupRequest forwards the request to the first vault holding a group
+func YxorpGroupRequest(w http.ResponseWriter, r *http.Request, namespace, groupId string) {
+	addresses := LocateGroup(namespace, groupId)
+	if len(addresses) == 0 {
+		http.Error(w, "group cannot be assigned to a vault", http.StatusBadRequest)
+		return
 	}
 
-	wg.Wait()
-
-	return responses
+	YxorpRequest(w, r, addresses[0])
 }
 
-// YxorpRequest forwards the request to a specific vault
-func YxorpRequest(w http.ResponseWriter, r *http.Request, ringNode string) {
-
-	address, ok := KeeperConfig.Ring.GetNode(ringNode)
-	if !ok {
+// YxorpReplicasRequest forwards a delete or a change to every replica of a group, acknowledged once the write quorum confirmed it
+func YxorpReplicasRequest(w http.ResponseWriter, r *http.Request, namespace, groupId string) {
+	addresses := LocateGroup(namespace, groupId)
+	if len(addresses) == 0 {
 		http.Error(w, "group cannot be assigned to a vault", http.StatusBadRequest)
 		return
 	}
 
-	// Create reverse proxy
-	proxy := httputil.NewSingleHostReverseProxy(&url.URL{
-		Scheme: "http",
-		Host:   address,
-	})
-	// Serve the request via the reverse proxy
-	proxy.ServeHTTP(w, r)
+	results := FanOutRequest(r.Method, r.URL.Path, r.URL.Query(), addresses)
+	AcknowledgeNotFound(results)
+	WriteReplicaResults(w, results, CurrentCluster().WriteQuorum())
+}
+
+// YxorpRequest forwards the request to a specific vault
+func YxorpRequest(w http.ResponseWriter, r *http.Request, address string) {
+	// Serve the request via the shared reverse proxy
+	vaultClient.Proxy(w, r, address)
 }
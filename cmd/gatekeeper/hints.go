@@ -0,0 +1,315 @@
+package gatekeeper
+
+import (
+	"datavault/cmd/internal"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrHintsFull is returned when storing a hint would exceed the hint storage limit
+var ErrHintsFull = errors.New("hint storage is full")
+
+// Hint is an upload destined to a vault that was unavailable, kept until it is replayed
+type Hint struct {
+	Id        string          `json:"id"`        // Hint identifier
+	Target    string          `json:"target"`    // Address of the vault the upload is destined to
+	Namespace string          `json:"namespace"` // Namespace of the group, empty for hints stored before namespaces
+	GroupId   string          `json:"groupId"`   // Group of the uploaded elements
+	Created   int64           `json:"created"`   // Creation time of the hint in milliseconds
+	Size      int64           `json:"size"`      // Size of the stored elements in bytes
+	Files     []internal.Meta `json:"files"`     // Elements of the upload
+}
+
+// HintsSummary is the pending hints of a vault
+type HintsSummary struct {
+	Target   string `json:"target"`   // Address of the vault
+	Hints    int    `json:"hints"`    // Number of pending hints
+	Elements int    `json:"elements"` // Number of pending elements
+	Size     int64  `json:"size"`     // Size of the pending elements in bytes
+	Oldest   int64  `json:"oldest"`   // Creation time of the oldest hint in milliseconds
+}
+
+var (
+	hintsMu   sync.Mutex // Guards the hint storage
+	hintsSize int64      // Bytes used by the hint storage
+)
+
+// HintsEnabled reports whether hinted handoff is configured
+func HintsEnabled() bool {
+	return KeeperConfig.HintsRoot != ""
+}
+
+// hintDir returns the directory of a hint
+func hintDir(target, hintId string) string {
+	return filepath.Join(KeeperConfig.HintsRoot, strings.ReplaceAll(target, ":", "_"), hintId)
+}
+
+// InitHints prepares the hint storage and measures the hints left by a previous run
+func InitHints() error {
+	err := os.MkdirAll(KeeperConfig.HintsRoot, 0777)
+	if err != nil {
+		return err
+	}
+
+	hints, err := PendingHints()
+	if err != nil {
+		return err
+	}
+
+	hintsMu.Lock()
+	defer hintsMu.Unlock()
+	hintsSize = 0
+	for _, hint := range hints {
+		hintsSize += hint.Size
+	}
+
+	return nil
+}
+
+// StoreHints stores an upload as a hint for every unavailable vault
+func StoreHints(namespace, groupId string, files []*multipart.FileHeader, preset []internal.Meta, targets []string) []ReplicaResult {
+	results := make([]ReplicaResult, len(targets))
+	for i, target := range targets {
+		results[i].Address = target
+		results[i].Hint, results[i].Err = StoreHint(target, namespace, groupId, files, preset)
+		if results[i].Err != nil {
+			continue
+		}
+
+		results[i].StatusCode = http.StatusOK
+		results[i].Body, results[i].Err = json.Marshal(preset)
+	}
+
+	return results
+}
+
+// StoreHint durably stores an upload destined to an unavailable vault and returns the hint id
+func StoreHint(target, namespace, groupId string, files []*multipart.FileHeader, preset []internal.Meta) (string, error) {
+	var size int64
+	for _, fileHeader := range files {
+		size += fileHeader.Size
+	}
+
+	hintsMu.Lock()
+	if KeeperConfig.MaxHintsSize > 0 && hintsSize+size > KeeperConfig.MaxHintsSize {
+		hintsMu.Unlock()
+		return "", ErrHintsFull
+	}
+	hintsSize += size
+	hintsMu.Unlock()
+
+	hint := Hint{
+		Id:        strings.ReplaceAll(uuid.New().String(), "-", ""),
+		Target:    target,
+		Namespace: namespace,
+		GroupId:   groupId,
+		Created:   time.Now().UnixMilli(),
+		Size:      size,
+		Files:     preset,
+	}
+
+	err := writeHint(hint, files)
+	if err != nil {
+		DiscardHint(target, hint.Id, size)
+		return "", err
+	}
+
+	log.Printf("Stored hint %s of group %s for vault %s\n", hint.Id, groupId, target)
+	return hint.Id, nil
+}
+
+// DiscardHint removes a hint without replaying it
+func DiscardHint(target, hintId string, size int64) error {
+	err := os.RemoveAll(hintDir(target, hintId))
+	if err != nil {
+		return err
+	}
+	releaseHint(size)
+
+	return nil
+}
+
+// writeHint writes the elements of a hint, then its description
+func writeHint(hint Hint, files []*multipart.FileHeader) error {
+	dir := hintDir(hint.Target, hint.Id)
+	err := os.MkdirAll(dir, 0777)
+	if err != nil {
+		return err
+	}
+
+	for i, fileHeader := range files {
+		_, err := internal.SaveMultipartToFile(dir, "", hint.Files[i].FileId, fileHeader)
+		if err != nil {
+			return err
+		}
+	}
+
+	hintBytes, err := json.Marshal(hint)
+	if err != nil {
+		return err
+	}
+
+	return internal.SaveBytesToFile(dir, "", "hint.json", hintBytes)
+}
+
+// releaseHint returns space to the hint storage
+func releaseHint(size int64) {
+	hintsMu.Lock()
+	defer hintsMu.Unlock()
+	hintsSize -= size
+}
+
+// PendingHints returns the stored hints, oldest first
+func PendingHints() ([]Hint, error) {
+	paths, err := filepath.Glob(filepath.Join(KeeperConfig.HintsRoot, "*", "*", "hint.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	hints := make([]Hint, 0, len(paths))
+	for _, path := range paths {
+		hintBytes, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		var hint Hint
+		err = json.Unmarshal(hintBytes, &hint)
+		if err != nil {
+			return nil, fmt.Errorf("reading hint %s: %w", path, err)
+		}
+		hints = append(hints, hint)
+	}
+
+	sort.Slice(hints, func(i, j int) bool {
+		return hints[i].Created < hints[j].Created
+	})
+
+	return hints, nil
+}
+
+// SummarizeHints returns the pending hints of every vault
+func SummarizeHints() ([]HintsSummary, error) {
+	hints, err := PendingHints()
+	if err != nil {
+		return nil, err
+	}
+
+	summaries := make([]HintsSummary, 0)
+	byTarget := make(map[string]int)
+	for _, hint := range hints {
+		i, ok := byTarget[hint.Target]
+		if !ok {
+			i = len(summaries)
+			byTarget[hint.Target] = i
+			summaries = append(summaries, HintsSummary{Target: hint.Target, Oldest: hint.Created})
+		}
+		summaries[i].Hints++
+		summaries[i].Elements += len(hint.Files)
+		summaries[i].Size += hint.Size
+	}
+
+	return summaries, nil
+}
+
+// ReplayHint uploads the elements of a hint to its vault and removes the hint
+func ReplayHint(hint Hint) error {
+	dir := hintDir(hint.Target, hint.Id)
+	for _, meta := range hint.Files {
+		file, err := os.Open(filepath.Join(dir, meta.FileId))
+		if err != nil {
+			return err
+		}
+
+		record := internal.Record{
+			Id: meta.FileId,
+			Attributes: map[string]string{
+				"fileName":      meta.FileName,
+				"fileType":      meta.FileType,
+				"receivedTime":  meta.ReceivedTime,
+				"expiresAt":     meta.ExpiresAt,
+				"key":           meta.Key,
+				"path":          meta.Path,
+				"retainUntil":   meta.RetainUntil,
+				"retentionMode": meta.RetentionMode,
+				"legalHold":     meta.LegalHold,
+			},
+		}
+		err = SendElement(hint.Target, hint.Namespace, hint.GroupId, record, file)
+		file.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	err := DiscardHint(hint.Target, hint.Id, hint.Size)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Replayed hint %s of group %s to vault %s\n", hint.Id, hint.GroupId, hint.Target)
+	return nil
+}
+
+// ReplayHints replays the pending hints of the vaults that passed a health check
+func ReplayHints() {
+	hints, err := PendingHints()
+	if err != nil {
+		log.Printf("Error reading pending hints: %v\n", err)
+		return
+	}
+
+	healthy := make(map[string]bool)
+	for _, hint := range hints {
+		up, checked := healthy[hint.Target]
+		if !checked {
+			up = VaultHealthy(hint.Target)
+			healthy[hint.Target] = up
+		}
+		if !up {
+			continue
+		}
+
+		err := ReplayHint(hint)
+		if err != nil {
+			log.Printf("Error replaying hint %s to vault %s: %v\n", hint.Id, hint.Target, err)
+			healthy[hint.Target] = false // keep the order of the remaining hints
+		}
+	}
+}
+
+// VaultHealthy checks whether a vault answers its health check
+func VaultHealthy(address string) bool {
+	resp, err := vaultClient.Get(address, "/ping", url.Values{})
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	return resp.StatusCode == http.StatusOK
+}
+
+// HintReplayer periodically replays pending hints to recovered vaults
+func HintReplayer() {
+	ticker := time.NewTicker(time.Duration(KeeperConfig.HealthInterval) * time.Second)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		ReplayHints()
+	}
+}
@@ -0,0 +1,58 @@
+package gatekeeper
+
+import (
+	"bytes"
+	"datavault/cmd/internal"
+	"mime/multipart"
+	"testing"
+)
+
+// testUploadFiles returns the file headers of a multipart upload of files with the given names
+func testUploadFiles(t *testing.T, names ...string) []*multipart.FileHeader {
+	t.Helper()
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	for _, name := range names {
+		part, err := writer.CreateFormFile("files", name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write([]byte("content of " + name))
+	}
+	writer.Close()
+
+	form, err := multipart.NewReader(body, writer.Boundary()).ReadForm(1 << 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { form.RemoveAll() })
+	return form.File["files"]
+}
+
+func TestReplayHint(t *testing.T) {
+	address, uploads := testVaultServer(t)
+	KeeperConfig.HintsRoot = t.TempDir()
+	t.Cleanup(func() { KeeperConfig.HintsRoot = "" })
+
+	preset := []internal.Meta{{FileId: "a1", ReceivedTime: "1700000000000", Path: "docs/a.txt", Retention: internal.Retention{LegalHold: "true"}}}
+	_, err := StoreHint(address, "ns", "g", testUploadFiles(t, "a.txt"), preset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hints, err := PendingHints()
+	if err != nil || len(hints) != 1 {
+		t.Fatalf("%d pending hints (%v), want 1", len(hints), err)
+	}
+
+	err = ReplayHint(hints[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	sent := <-uploads
+	if len(sent) != 1 || sent[0].FileId != "a1" || sent[0].Path != "docs/a.txt" || sent[0].LegalHold != "true" {
+		t.Errorf("replayed metadata %+v, want %+v", sent, preset)
+	}
+	if hints, _ := PendingHints(); len(hints) != 0 {
+		t.Errorf("%d hints left after the replay", len(hints))
+	}
+}
@@ -1,21 +1,68 @@
 package gatekeeper
 
 import (
+	"datavault/cmd/internal"
 	"datavault/configs"
 	"encoding/json"
 	"log"
-
-	"github.com/serialx/hashring"
+	"os"
 )
 
 // Config is the configuration for the gatekeeper server
 type Config struct {
 	Port string `json:"port"` // Port for the vault server
 
-	Vaults           []string `json:"vaults"`            // List of vaults addresses (host:port)
-	BroadcastTimeout int      `json:"broadcast_timeout"` // Timeout for broadcast requests in seconds
+	Vaults           []VaultEntry `json:"vaults"`            // List of vaults, as addresses (host:port) or entries with topology labels
+	BroadcastTimeout int          `json:"broadcast_timeout"` // Deadline of metadata requests to vaults in seconds, defaults to 5
+	Replicas         int          `json:"replicas"`          // Number of vaults holding a copy of each group, defaults to 1
+	WriteQuorum      int          `json:"write_quorum"`      // Replicas that must acknowledge uploads and deletes, defaults to a majority
+	ReadQuorum       int          `json:"read_quorum"`       // Replicas that must answer metadata reads, defaults to a majority
+	HandoffWindow    int          `json:"handoff_window"`    // Ring candidates past the replicas probed for a group before the rest of the ring, defaults to the replication factor
+
+	ConnectTimeout   int `json:"connect_timeout"`   // Timeout for connecting to a vault in seconds, defaults to 2
+	TransferTimeout  int `json:"transfer_timeout"`  // Deadline of uploads, downloads and proxied requests in seconds, 0 for none
+	Retries          int `json:"retries"`           // Retries of failed idempotent requests, defaults to 2, negative disables retries
+	RetryBackoff     int `json:"retry_backoff"`     // Delay before the first retry in milliseconds, doubled for every retry, defaults to 100
+	BreakerThreshold int `json:"breaker_threshold"` // Consecutive failures that open the circuit breaker of a vault, defaults to 5
+	BreakerCooldown  int `json:"breaker_cooldown"`  // Seconds before an open circuit breaker lets a probe request through, defaults to 10
+	MaxIdleConns     int `json:"max_idle_conns"`    // Idle connections kept open to each vault, defaults to 16
+
+	TLS      *internal.TLSConfig `json:"tls"`       // TLS configuration of the listener, nil serves plain HTTP
+	VaultTLS *internal.TLSConfig `json:"vault_tls"` // TLS configuration of requests to vaults, nil uses plain HTTP
+
+	APIKeys     []APIKey `json:"api_keys"`     // Keys clients authenticate with, empty disables authentication
+	VaultSecret string   `json:"vault_secret"` // Secret shared with the vaults, requests to vaults are signed with it when set
+
+	URLSecret        string `json:"url_secret"`         // Secret signing pre-signed URLs, shared with the vaults, empty disables them
+	PresignExpiry    int    `json:"presign_expiry"`     // Default lifetime of pre-signed URLs in seconds, defaults to 900
+	PresignMaxExpiry int    `json:"presign_max_expiry"` // Longest lifetime of pre-signed URLs in seconds, defaults to 7 days
+	PublicURL        string `json:"public_url"`         // Base URL of pre-signed URLs, defaults to the host of the minting request
+
+	Quotas QuotaConfig `json:"quotas"` // Byte and file-count quotas of groups and namespaces, zero values are unlimited
+
+	Audit  *internal.AuditConfig `json:"audit"`  // Audit log of mutating requests, nil disables it
+	Events *EventsConfig         `json:"events"` // Change events aggregated from the vaults, nil disables events and webhooks
 
-	Ring *hashring.HashRing // Consistency hash ring
+	IN_MEMORY_UPLOAD_SIZE int64 `json:"in_memory_upload_size"` // Maximum size of in-memory upload when replicating
+	MAX_UPLOAD_SIZE       int64 `json:"max_upload_size"`       // Maximum size of upload when replicating, 0 for no limit
+
+	ArchiveMaxEntries int   `json:"archive_max_entries"` // Files extracted from the archives of an upload, defaults to and at most 1000
+	ArchiveMaxSize    int64 `json:"archive_max_size"`    // Bytes extracted from the archives of an upload, defaults to 1 GiB
+	ArchiveMaxRatio   int64 `json:"archive_max_ratio"`   // Bytes extracted per byte of archive, defaults to 100, negative for no limit
+
+	HintsRoot      string `json:"hints_root"`      // Folder storing uploads for unavailable vaults, empty disables hinted handoff
+	MaxHintsSize   int64  `json:"max_hints_size"`  // Maximum size of the stored hints in bytes, defaults to 1 GiB
+	HealthInterval int    `json:"health_interval"` // Interval between health checks of vaults with pending hints in seconds
+
+	Weights    map[string]int `json:"weights"`     // Static ring weight per vault address, defaults to 1
+	WeightMode string         `json:"weight_mode"` // Ring weight mode: "static" (default) or "capacity"
+
+	Advertise string                 `json:"advertise"` // HTTP address announced to the gossip members (host:port)
+	Gossip    *internal.GossipConfig `json:"gossip"`    // Gossip membership configuration, nil disables gossip
+
+	SharedRing       bool `json:"shared_ring"`        // Share a version-stamped ring record with other gatekeepers through the vaults
+	RingSyncInterval int  `json:"ring_sync_interval"` // Interval between shared ring syncs in seconds, defaults to 5
+	RingMaxStaleness int  `json:"ring_max_staleness"` // Seconds without a successful sync before requests are rejected, defaults to 3 intervals
 }
 
 var KeeperConfig Config
@@ -30,11 +77,143 @@ func Init() {
 		log.Fatalf("Error parsing gatekeeper configuration: %v\n", err)
 	}
 
-	//Initialize the hash ring
-	nodes := make(map[string]int)
+	if KeeperConfig.WeightMode != "" && KeeperConfig.WeightMode != "static" && KeeperConfig.WeightMode != "capacity" {
+		log.Fatalf("Unknown ring weight mode: %s\n", KeeperConfig.WeightMode)
+	}
+
+	err = ValidateAPIKeys(KeeperConfig.APIKeys)
+	if err != nil {
+		log.Fatalf("Invalid API keys: %v\n", err)
+	}
+
+	if KeeperConfig.PresignMaxExpiry <= 0 {
+		KeeperConfig.PresignMaxExpiry = 7 * 24 * 3600
+	}
+	if KeeperConfig.PresignExpiry <= 0 {
+		KeeperConfig.PresignExpiry = min(900, KeeperConfig.PresignMaxExpiry)
+	}
+
+	//Validate the configured vaults
+	addresses := make(map[string]bool)
 	for _, vault := range KeeperConfig.Vaults {
-		nodes[vault] = 1
+		if addresses[vault.Address] {
+			log.Fatalf("Duplicate vault in configuration: %s\n", vault.Address)
+		}
+		addresses[vault.Address] = true
+	}
+
+	//Initialize the vault client
+	if KeeperConfig.BroadcastTimeout <= 0 {
+		KeeperConfig.BroadcastTimeout = 5
+	}
+	if KeeperConfig.ConnectTimeout <= 0 {
+		KeeperConfig.ConnectTimeout = 2
+	}
+	if KeeperConfig.Retries == 0 {
+		KeeperConfig.Retries = 2
+	}
+	if KeeperConfig.RetryBackoff <= 0 {
+		KeeperConfig.RetryBackoff = 100
+	}
+	if KeeperConfig.BreakerThreshold <= 0 {
+		KeeperConfig.BreakerThreshold = 5
+	}
+	if KeeperConfig.BreakerCooldown <= 0 {
+		KeeperConfig.BreakerCooldown = 10
+	}
+	if KeeperConfig.MaxIdleConns <= 0 {
+		KeeperConfig.MaxIdleConns = 16
+	}
+	vaultClient, err = NewVaultClient()
+	if err != nil {
+		log.Fatalf("Error configuring the vault client: %v\n", err)
 	}
 
-	KeeperConfig.Ring = hashring.NewWithWeights(nodes)
+	//Validate the replication factor
+	if KeeperConfig.Replicas <= 0 {
+		KeeperConfig.Replicas = 1
+	}
+	if KeeperConfig.WriteQuorum <= 0 {
+		KeeperConfig.WriteQuorum = KeeperConfig.Replicas/2 + 1
+	}
+	if KeeperConfig.HandoffWindow <= 0 {
+		KeeperConfig.HandoffWindow = KeeperConfig.Replicas
+	}
+	if KeeperConfig.WriteQuorum > KeeperConfig.Replicas {
+		log.Printf("Write quorum %d is larger than the replication factor, using %d\n", KeeperConfig.WriteQuorum, KeeperConfig.Replicas)
+		KeeperConfig.WriteQuorum = KeeperConfig.Replicas
+	}
+	if KeeperConfig.ReadQuorum <= 0 {
+		KeeperConfig.ReadQuorum = KeeperConfig.Replicas/2 + 1
+	}
+	if KeeperConfig.ReadQuorum > KeeperConfig.Replicas {
+		log.Printf("Read quorum %d is larger than the replication factor, using %d\n", KeeperConfig.ReadQuorum, KeeperConfig.Replicas)
+		KeeperConfig.ReadQuorum = KeeperConfig.Replicas
+	}
+	if KeeperConfig.WriteQuorum+KeeperConfig.ReadQuorum <= KeeperConfig.Replicas {
+		log.Printf("Write quorum %d and read quorum %d do not overlap, reads may miss acknowledged writes\n", KeeperConfig.WriteQuorum, KeeperConfig.ReadQuorum)
+	}
+	if KeeperConfig.IN_MEMORY_UPLOAD_SIZE <= 0 {
+		KeeperConfig.IN_MEMORY_UPLOAD_SIZE = 32 << 20
+	}
+	if KeeperConfig.ArchiveMaxEntries <= 0 || KeeperConfig.ArchiveMaxEntries > internal.MaxArchiveEntries {
+		KeeperConfig.ArchiveMaxEntries = internal.MaxArchiveEntries
+	}
+	if KeeperConfig.ArchiveMaxSize <= 0 {
+		KeeperConfig.ArchiveMaxSize = 1 << 30
+	}
+	if KeeperConfig.ArchiveMaxRatio == 0 {
+		KeeperConfig.ArchiveMaxRatio = 100
+	}
+
+	//Initialize hinted handoff
+	if HintsEnabled() {
+		if KeeperConfig.MaxHintsSize <= 0 {
+			KeeperConfig.MaxHintsSize = 1 << 30
+		}
+		if KeeperConfig.HealthInterval <= 0 {
+			KeeperConfig.HealthInterval = 10
+		}
+		err := InitHints()
+		if err != nil {
+			log.Fatalf("Error initializing hint storage: %v\n", err)
+		}
+	}
+
+	if KeeperConfig.Advertise == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			log.Fatalf("Error reading hostname: %v\n", err)
+		}
+		KeeperConfig.Advertise = hostname + ":" + KeeperConfig.Port
+	}
+
+	//Open the audit log
+	if KeeperConfig.Audit != nil {
+		auditLog, err = internal.OpenAuditLog(*KeeperConfig.Audit, "gatekeeper "+KeeperConfig.Advertise)
+		if err != nil {
+			log.Fatalf("Error opening audit log: %v\n", err)
+		}
+	}
+
+	//Initialize the change events
+	if EventsEnabled() {
+		err = InitEvents()
+		if err != nil {
+			log.Fatalf("Error loading webhooks: %v\n", err)
+		}
+	}
+
+	//Initialize the hash ring
+	if KeeperConfig.Gossip != nil {
+		err = StartGossip()
+		if err != nil {
+			log.Fatalf("Error starting gossip membership: %v\n", err)
+		}
+	}
+	if SharedRingEnabled() {
+		InitSharedRing()
+	} else if KeeperConfig.Gossip == nil {
+		UpdateCluster()
+	}
 }
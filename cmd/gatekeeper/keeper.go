@@ -3,5 +3,14 @@ package gatekeeper
 // Exec initializes the gatekeeper server and starts it
 func Exec() {
 	Init()
+	if HintsEnabled() {
+		go HintReplayer()
+	}
+	if SharedRingEnabled() {
+		go RingSyncer()
+	}
+	if EventsEnabled() {
+		go EventCollector()
+	}
 	Server()
 }
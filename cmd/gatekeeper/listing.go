@@ -0,0 +1,105 @@
+package gatekeeper
+
+import (
+	"datavault/cmd/internal"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+)
+
+// ListGroups returns a page of the groups of a namespace the readable function accepts, all of them when it is nil
+//
+// Merged pages are fetched until the page is full, so that groups filtered out
+// do not leave short or empty pages with a cursor. A cursor is only returned
+// when a readable group follows the page.
+func ListGroups(addresses []string, namespace, after string, limit int, readable func(groupId string) bool) internal.GroupsPage {
+	page := internal.GroupsPage{Groups: make([]internal.GroupSummary, 0, limit)}
+	cursor := after
+	for {
+		merged := mergeGroupsPage(addresses, namespace, cursor, limit)
+		for _, address := range merged.Unavailable {
+			if !slices.Contains(page.Unavailable, address) {
+				page.Unavailable = append(page.Unavailable, address)
+			}
+		}
+		for _, address := range merged.Partial {
+			if !slices.Contains(page.Partial, address) {
+				page.Partial = append(page.Partial, address)
+			}
+		}
+
+		for _, summary := range merged.Groups {
+			if readable != nil && !readable(summary.GroupId) {
+				continue
+			}
+			if len(page.Groups) == limit {
+				page.Next = page.Groups[limit-1].GroupId
+				return page
+			}
+			page.Groups = append(page.Groups, summary)
+		}
+
+		if merged.Next == "" {
+			return page
+		}
+		cursor = merged.Next
+	}
+}
+
+// mergeGroupsPage gathers a page of the groups of a namespace from every vault and merges them by group id
+//
+// Every vault returns its own page after the cursor. A vault that has more
+// groups only vouches for groups up to the last one it returned, so merging the
+// pages and keeping the first limit groups never skips a group. Replicas of a
+// group are counted once, with the counts of the replica holding the most
+// elements.
+func mergeGroupsPage(addresses []string, namespace, after string, limit int) internal.GroupsPage {
+	query := url.Values{"namespace": {namespace}, "after": {after}, "limit": {strconv.Itoa(limit)}}
+	results := FanOutRequest(http.MethodGet, "/groups", query, addresses)
+
+	merged := make(map[string]internal.GroupSummary)
+	truncated := false
+	page := internal.GroupsPage{}
+	for _, result := range results {
+		if result.Err != nil && result.StatusCode == 0 {
+			page.Unavailable = append(page.Unavailable, result.Address)
+			continue
+		}
+
+		var vaultPage internal.GroupsPage
+		if !result.OK() || json.Unmarshal(result.Body, &vaultPage) != nil {
+			page.Partial = append(page.Partial, result.Address)
+			continue
+		}
+
+		if vaultPage.Next != "" {
+			truncated = true
+		}
+		for _, summary := range vaultPage.Groups {
+			if summary.Elements > merged[summary.GroupId].Elements {
+				merged[summary.GroupId] = summary
+			}
+		}
+	}
+
+	page.Groups = make([]internal.GroupSummary, 0, min(len(merged), limit))
+	for _, summary := range merged {
+		page.Groups = append(page.Groups, summary)
+	}
+	sort.Slice(page.Groups, func(i, j int) bool {
+		return page.Groups[i].GroupId < page.Groups[j].GroupId
+	})
+
+	if len(page.Groups) > limit {
+		page.Groups = page.Groups[:limit]
+		truncated = true
+	}
+	if truncated && len(page.Groups) > 0 {
+		page.Next = page.Groups[len(page.Groups)-1].GroupId
+	}
+
+	return page
+}
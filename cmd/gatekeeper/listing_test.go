@@ -0,0 +1,104 @@
+package gatekeeper
+
+import (
+	"datavault/cmd/internal"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// testGroupsServer starts a vault listing by pages the given groups, with their element counts
+func testGroupsServer(t *testing.T, elements map[string]int) string {
+	t.Helper()
+	groups := make([]string, 0, len(elements))
+	for groupId := range elements {
+		groups = append(groups, groupId)
+	}
+	slices.Sort(groups)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		after := r.URL.Query().Get("after")
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		page := internal.GroupsPage{Groups: make([]internal.GroupSummary, 0)}
+		for _, groupId := range groups {
+			if groupId <= after {
+				continue
+			}
+			if len(page.Groups) == limit {
+				page.Next = page.Groups[limit-1].GroupId
+				break
+			}
+			page.Groups = append(page.Groups, internal.GroupSummary{GroupId: groupId, Elements: elements[groupId]})
+		}
+		json.NewEncoder(w).Encode(page)
+	}))
+	t.Cleanup(server.Close)
+	return server.Listener.Addr().String()
+}
+
+func TestListGroupsReadable(t *testing.T) {
+	var err error
+	vaultClient, err = NewVaultClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	addresses := []string{
+		testGroupsServer(t, map[string]int{"a1": 1, "a2": 1, "a3": 1, "r1": 1, "a4": 1, "a5": 1, "r3": 1}),
+		testGroupsServer(t, map[string]int{"a1": 1, "a6": 1, "r2": 1, "a7": 1, "a8": 1, "a9": 1, "r3": 1, "z1": 1}),
+	}
+	readable := func(groupId string) bool {
+		return strings.HasPrefix(groupId, "r")
+	}
+
+	listed := make([]string, 0)
+	after := ""
+	for pages := 0; ; pages++ {
+		if pages == 5 {
+			t.Fatalf("no last page after %v", listed)
+		}
+		page := ListGroups(addresses, internal.DefaultNamespace, after, 2, readable)
+		if len(page.Groups) == 0 {
+			t.Fatalf("empty page after %q", after)
+		}
+		for _, group := range page.Groups {
+			listed = append(listed, group.GroupId)
+		}
+		if page.Next == "" {
+			break
+		}
+		after = page.Next
+	}
+
+	if strings.Join(listed, ",") != "r1,r2,r3" {
+		t.Errorf("listed %v, want r1, r2 and r3", listed)
+	}
+}
+
+func TestListGroupsMerge(t *testing.T) {
+	var err error
+	vaultClient, err = NewVaultClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	addresses := []string{
+		testGroupsServer(t, map[string]int{"g1": 3, "g2": 1, "g4": 1}),
+		testGroupsServer(t, map[string]int{"g1": 2, "g3": 1}),
+	}
+
+	// The first vault vouches for groups up to g2 only, g3 waits for the next page
+	page := ListGroups(addresses, internal.DefaultNamespace, "", 2, nil)
+	if len(page.Groups) != 2 || page.Groups[0].GroupId != "g1" || page.Groups[1].GroupId != "g2" || page.Next != "g2" {
+		t.Fatalf("first page %+v, want g1 and g2 with a cursor", page)
+	}
+	if page.Groups[0].Elements != 3 {
+		t.Errorf("replicated group counted with %d elements, want the largest replica's 3", page.Groups[0].Elements)
+	}
+
+	page = ListGroups(addresses, internal.DefaultNamespace, page.Next, 2, nil)
+	if len(page.Groups) != 2 || page.Groups[0].GroupId != "g3" || page.Groups[1].GroupId != "g4" || page.Next != "" {
+		t.Errorf("last page %+v, want g3 and g4 without a cursor", page)
+	}
+}
@@ -0,0 +1,33 @@
+package gatekeeper
+
+import (
+	"datavault/cmd/internal"
+	"net/http"
+)
+
+// ResolveNamespace rejects requests naming an invalid namespace and moves the namespace header into the query
+//
+// Handlers and the requests they fan out to vaults read the namespace from the
+// query only, requests without one belong to the default namespace.
+func ResolveNamespace(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		namespace, err := internal.RequestNamespace(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		query := r.URL.Query()
+		if namespace != internal.DefaultNamespace && query.Get(internal.NamespaceParam) == "" {
+			query.Set(internal.NamespaceParam, namespace)
+			r.URL.RawQuery = query.Encode()
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequestNamespace returns the namespace of a request resolved by ResolveNamespace
+func RequestNamespace(r *http.Request) string {
+	return internal.QueryNamespace(r.URL.Query())
+}
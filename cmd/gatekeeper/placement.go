@@ -0,0 +1,264 @@
+package gatekeeper
+
+import (
+	"datavault/cmd/internal"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ErrNoWritableVault is returned when not enough vaults can accept uploads for a group
+var ErrNoWritableVault = errors.New("no writable vault available for group")
+
+// VaultState is the state of a vault with respect to a group
+type VaultState struct {
+	Address  string // Address of the vault (host:port)
+	Exists   bool   // Whether the vault holds the group
+	ReadOnly bool   // Whether the vault rejects uploads
+	Err      error  // Error reaching the vault
+}
+
+// RingKey returns the key a group is hashed on, groups of the default namespace keep their bare id
+func RingKey(namespace, groupId string) string {
+	if namespace == internal.DefaultNamespace || namespace == "" {
+		return groupId
+	}
+	return namespace + "/" + groupId
+}
+
+// RingCandidates returns every vault of the hash ring in placement order for a group, owner first
+func RingCandidates(namespace, groupId string) []string {
+	ring := CurrentCluster().Ring
+	nodes, ok := ring.GetNodes(RingKey(namespace, groupId), ring.Size())
+	if !ok {
+		return nil
+	}
+	return nodes
+}
+
+// probeWindow splits the ring candidates of a group into the vaults probed first and the rest of the ring
+//
+// The window holds the replica set and the first candidates up to the
+// replication factor plus the handoff window, both in ring order, so that
+// probing a group does not fan out to every vault of a large ring.
+func probeWindow(candidates []string) ([]string, []string) {
+	size := CurrentCluster().Replicas() + KeeperConfig.HandoffWindow
+	replicas := SelectReplicas(candidates, CurrentCluster().Replicas())
+	window := make([]string, 0, size+len(replicas))
+	rest := make([]string, 0, max(len(candidates)-size, 0))
+	for i, address := range candidates {
+		if i < size || slices.Contains(replicas, address) {
+			window = append(window, address)
+		} else {
+			rest = append(rest, address)
+		}
+	}
+	return window, rest
+}
+
+// ReplicaSet returns the vaults a new group is placed on when every vault is writable
+func ReplicaSet(namespace, groupId string) []string {
+	return SelectReplicas(RingCandidates(namespace, groupId), CurrentCluster().Replicas())
+}
+
+// forEachVault runs fn for every address in parallel and waits for all of them
+func forEachVault(addresses []string, fn func(i int, address string)) {
+	wg := sync.WaitGroup{}
+	for i, address := range addresses {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fn(i, address)
+		}()
+	}
+	wg.Wait()
+}
+
+// GroupExists checks whether a vault holds a group of a namespace
+func GroupExists(address, namespace, groupId string) (bool, error) {
+	query := url.Values{"namespace": {namespace}, "groupId": {groupId}}
+	req, err := http.NewRequest(http.MethodHead, VaultURL(address, "/group", query), nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := vaultClient.Do(req, time.Duration(KeeperConfig.BroadcastTimeout)*time.Second)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, fmt.Errorf("vault %s answered %s", address, resp.Status)
+	}
+}
+
+// IsReadOnly checks whether a vault reports itself read-only
+func IsReadOnly(address string) (bool, error) {
+	resp, err := vaultClient.Get(address, "/stats", url.Values{})
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	var stats VaultStats
+	err = json.NewDecoder(resp.Body).Decode(&stats)
+	if err != nil {
+		return false, err
+	}
+
+	return stats.ReadOnly, nil
+}
+
+// probeVaults returns the state of vaults with respect to a group, in the order of addresses
+func probeVaults(namespace, groupId string, addresses []string) []VaultState {
+	states := make([]VaultState, len(addresses))
+	forEachVault(addresses, func(i int, address string) {
+		states[i].Address = address
+		states[i].Exists, states[i].Err = GroupExists(address, namespace, groupId)
+		if states[i].Err != nil {
+			return
+		}
+		states[i].ReadOnly, states[i].Err = IsReadOnly(address)
+	})
+
+	return states
+}
+
+// ProbeGroup returns the state of the probed ring candidates of a group, in ring order
+//
+// The rest of the ring is only probed when no vault of the window holds the
+// group, or when the window has fewer writable vaults than replicas.
+func ProbeGroup(namespace, groupId string) []VaultState {
+	candidates := RingCandidates(namespace, groupId)
+	window, rest := probeWindow(candidates)
+	states := probeVaults(namespace, groupId, window)
+
+	holders, writable := 0, 0
+	for _, state := range states {
+		if state.Err != nil {
+			continue
+		}
+		if state.Exists {
+			holders++
+		}
+		if !state.ReadOnly {
+			writable++
+		}
+	}
+	if len(rest) > 0 && (holders == 0 || writable < CurrentCluster().Replicas()) {
+		states = append(states, probeVaults(namespace, groupId, rest)...)
+		slices.SortFunc(states, func(a, b VaultState) int {
+			return slices.Index(candidates, a.Address) - slices.Index(candidates, b.Address)
+		})
+	}
+
+	return states
+}
+
+// groupHolders returns the vaults holding a group among addresses, in their order
+func groupHolders(namespace, groupId string, addresses []string) []string {
+	exists := make([]bool, len(addresses))
+	forEachVault(addresses, func(i int, address string) {
+		exists[i], _ = GroupExists(address, namespace, groupId)
+	})
+
+	holders := make([]string, 0, CurrentCluster().Replicas())
+	for i, address := range addresses {
+		if exists[i] {
+			holders = append(holders, address)
+		}
+	}
+	return holders
+}
+
+// LocateGroup returns the replicas of a group, in ring order
+//
+// The vaults holding the group come first. They are completed up to the
+// replication factor with ring candidates, so that replicas that missed the
+// group are read and repaired with the others. The replica set is returned
+// when no vault holds the group. Holders are searched in the probe window
+// first, and in the rest of the ring only when the window misses the group.
+func LocateGroup(namespace, groupId string) []string {
+	candidates := RingCandidates(namespace, groupId)
+	window, rest := probeWindow(candidates)
+	holders := groupHolders(namespace, groupId, window)
+	if len(holders) == 0 && len(rest) > 0 {
+		holders = groupHolders(namespace, groupId, rest)
+	}
+
+	return ExtendReplicas(holders, candidates, CurrentCluster().Replicas())
+}
+
+// Placement is the vaults receiving an upload
+type Placement struct {
+	Vaults []string // Vaults written directly
+	Hinted []string // Unavailable vaults whose writes are stored as hints
+}
+
+// PlaceGroup returns the vaults that receive uploads for a group
+//
+// Existing groups keep their writable replicas, completed with writable ring
+// candidates. New groups are spread across the writable vaults, in ring order
+// and across failure domains. Unavailable vaults of the replica set receive
+// hints when hinted handoff is enabled. At least the write quorum of vaults,
+// hinted ones included, is required.
+func PlaceGroup(namespace, groupId string) (Placement, error) {
+	states := ProbeGroup(namespace, groupId)
+	if len(states) == 0 {
+		return Placement{}, ErrNoWritableVault
+	}
+
+	holders := make([]string, 0, CurrentCluster().Replicas())
+	writable := make([]string, 0, len(states))
+	unavailable := make(map[string]error)
+	for _, state := range states {
+		if state.Err != nil {
+			unavailable[state.Address] = state.Err
+			continue
+		}
+		if state.ReadOnly {
+			continue
+		}
+		writable = append(writable, state.Address)
+		if state.Exists {
+			holders = append(holders, state.Address)
+		}
+	}
+
+	// Unavailable vaults of the replica set may hold the group
+	hinted := make([]string, 0)
+	for _, address := range ReplicaSet(namespace, groupId) {
+		err, ok := unavailable[address]
+		if !ok {
+			continue
+		}
+		if HintsEnabled() {
+			hinted = append(hinted, address)
+			continue
+		}
+		// The replica set must be reachable to be sure the group is new
+		if len(holders) == 0 {
+			return Placement{}, err
+		}
+	}
+
+	placement := Placement{
+		Vaults: ExtendReplicas(holders, writable, CurrentCluster().Replicas()-len(hinted)),
+		Hinted: hinted,
+	}
+	if len(placement.Vaults)+len(placement.Hinted) < CurrentCluster().WriteQuorum() {
+		return Placement{}, ErrNoWritableVault
+	}
+
+	return placement, nil
+}
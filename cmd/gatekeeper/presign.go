@@ -0,0 +1,181 @@
+package gatekeeper
+
+import (
+	"datavault/cmd/internal"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Errors of one-off upload URLs
+var (
+	ErrURLUsed         = errors.New("pre-signed upload URL was already used")                 // The URL was used or is being used
+	ErrURLUnverifiable = errors.New("use of the pre-signed upload URL could not be recorded") // Too few vaults recorded the claim of the URL
+)
+
+// PresignedURL is a URL granting one method on a group or element until it expires
+type PresignedURL struct {
+	URL     string `json:"url"`     // Pre-signed URL
+	Method  string `json:"method"`  // Method the URL must be requested with
+	Expires int64  `json:"expires"` // Expiry of the URL in unix seconds
+}
+
+// PresignEnabled reports whether the gatekeeper can mint pre-signed URLs
+func PresignEnabled() bool {
+	return KeeperConfig.URLSecret != ""
+}
+
+// ClaimUpload claims the nonce of a one-off upload URL on the replicas of its group
+//
+// Every gatekeeper routes the URL to the same replicas, so a claim held by a
+// majority of them keeps the URL from being used twice through other
+// gatekeepers or after a restart. The returned function marks the nonce used
+// when the upload was committed, and releases it otherwise so that a failed
+// upload can be retried.
+func ClaimUpload(r *http.Request) (func(committed bool), error) {
+	query := r.URL.Query()
+	addresses := LocateGroup(RequestNamespace(r), query.Get("groupId"))
+	params := url.Values{internal.NonceParam: {query.Get(internal.NonceParam)}, internal.ExpiresParam: {query.Get(internal.ExpiresParam)}}
+	results := FanOutRequest(http.MethodPost, "/nonces", params, addresses)
+
+	release := func() {
+		for _, result := range results {
+			if !result.OK() {
+				continue
+			}
+			released := sendToVault(http.MethodDelete, result.Address, "/nonces", params, "", nil)
+			if !released.OK() {
+				log.Printf("Error releasing upload URL nonce: %s\n", released)
+			}
+		}
+	}
+	for _, result := range results {
+		if result.Err == nil && result.StatusCode == http.StatusConflict {
+			release()
+			return nil, ErrURLUsed
+		}
+	}
+	if Acknowledged(results)*2 <= len(addresses) {
+		release()
+		return nil, ErrURLUnverifiable
+	}
+
+	return func(committed bool) {
+		if !committed {
+			release()
+			return
+		}
+		// Every replica records the use, including those that missed the claim
+		for _, result := range FanOutRequest(http.MethodPut, "/nonces", params, addresses) {
+			if !result.OK() {
+				log.Printf("Error recording use of upload URL nonce: %s\n", result)
+			}
+		}
+	}, nil
+}
+
+// uploadRecorder captures the status of the response to an upload through a one-off URL
+type uploadRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+// WriteHeader records the status before writing it
+func (u *uploadRecorder) WriteHeader(status int) {
+	if u.status == 0 {
+		u.status = status
+	}
+	u.ResponseWriter.WriteHeader(status)
+}
+
+// Write records an implicit 200 status
+func (u *uploadRecorder) Write(b []byte) (int, error) {
+	if u.status == 0 {
+		u.status = http.StatusOK
+	}
+	return u.ResponseWriter.Write(b)
+}
+
+// HandlerPresign mints a pre-signed URL for the group or element of the request
+//
+// GET URLs download an element, PUT URLs upload into a group once. The caller
+// must hold the matching permission on the group in the namespace of the request.
+func HandlerPresign(w http.ResponseWriter, r *http.Request) {
+	if !PresignEnabled() {
+		http.Error(w, "pre-signed URLs are not configured", http.StatusNotImplemented)
+		return
+	}
+
+	query := r.URL.Query()
+	namespace := RequestNamespace(r)
+	groupId := query.Get("groupId")
+	elementId := query.Get("elementId")
+	method := strings.ToUpper(query.Get("method"))
+	if groupId == "" {
+		http.Error(w, "groupId is required", http.StatusBadRequest)
+		return
+	}
+
+	var path, permission, nonce string
+	switch method {
+	case http.MethodGet:
+		if elementId == "" {
+			http.Error(w, "elementId is required for download URLs", http.StatusBadRequest)
+			return
+		}
+		path, permission = "/group/element", PermissionRead
+	case http.MethodPut:
+		if elementId != "" {
+			http.Error(w, "upload URLs are scoped to a group, not an element", http.StatusBadRequest)
+			return
+		}
+		path, permission = "/group", PermissionWrite
+		nonce = strings.ReplaceAll(uuid.New().String(), "-", "")
+	default:
+		http.Error(w, "method must be GET or PUT", http.StatusBadRequest)
+		return
+	}
+	if !CanAccess(r, permission, namespace, groupId) {
+		http.Error(w, "permission denied: "+permission+" on group "+groupId+" of namespace "+namespace, http.StatusForbidden)
+		return
+	}
+
+	expiresIn := KeeperConfig.PresignExpiry
+	if value := query.Get("expires_in"); value != "" {
+		var err error
+		expiresIn, err = strconv.Atoi(value)
+		if err != nil || expiresIn <= 0 {
+			http.Error(w, "expires_in must be a positive number of seconds", http.StatusBadRequest)
+			return
+		}
+	}
+	expiresIn = min(expiresIn, KeeperConfig.PresignMaxExpiry)
+	expires := time.Now().Add(time.Duration(expiresIn) * time.Second)
+
+	baseURL := KeeperConfig.PublicURL
+	if baseURL == "" {
+		baseURL = "http://" + r.Host
+		if r.TLS != nil {
+			baseURL = "https://" + r.Host
+		}
+	}
+	signed := internal.PresignQuery(KeeperConfig.URLSecret, method, path, namespace, groupId, elementId, expires, nonce)
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(PresignedURL{
+		URL:     strings.TrimSuffix(baseURL, "/") + path + "?" + signed.Encode(),
+		Method:  method,
+		Expires: expires.Unix(),
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,182 @@
+package gatekeeper
+
+import (
+	"datavault/cmd/internal"
+	"net/http"
+	"strings"
+)
+
+// ElementRepair describes how to bring the replicas of an element back in agreement
+type ElementRepair struct {
+	Namespace string          // Namespace of the group
+	GroupId   string          // Group of the element
+	Record    internal.Record // Version of the element agreed by the majority
+	Source    string          // Replica holding the agreed version
+	Missing   []string        // Replicas lacking the element or holding another version
+	Stale     []string        // Replicas holding an element the majority does not have
+}
+
+// Acknowledged returns the number of replicas that acknowledged a request
+func Acknowledged(results []ReplicaResult) int {
+	acks := 0
+	for _, result := range results {
+		if result.OK() {
+			acks++
+		}
+	}
+	return acks
+}
+
+// AcknowledgeNotFound counts 404 answers as acknowledgements of an idempotent delete
+//
+// The results are left untouched when every replica answered 404.
+func AcknowledgeNotFound(results []ReplicaResult) {
+	found := false
+	for _, result := range results {
+		if result.Err == nil && result.StatusCode != http.StatusNotFound {
+			found = true
+		}
+	}
+	if !found {
+		return
+	}
+
+	for i := range results {
+		if results[i].Err == nil && results[i].StatusCode == http.StatusNotFound {
+			results[i].StatusCode = http.StatusOK
+		}
+	}
+}
+
+// WriteReplicaFailure reports the replicas that failed a request
+//
+// The status is the one shared by all failed replicas, 507 if one of them is
+// out of space, or 502.
+func WriteReplicaFailure(w http.ResponseWriter, message string, results []ReplicaResult) {
+	failures := make([]string, 0)
+	status := 0
+	for _, result := range results {
+		if result.OK() {
+			continue
+		}
+		failures = append(failures, result.String())
+
+		switch {
+		case status == 0:
+			status = result.StatusCode
+		case status != result.StatusCode && status != http.StatusInsufficientStorage:
+			status = http.StatusBadGateway
+		}
+		if result.StatusCode == http.StatusInsufficientStorage {
+			status = http.StatusInsufficientStorage
+		}
+	}
+
+	if status == 0 {
+		status = http.StatusBadGateway
+	}
+	http.Error(w, message+": "+strings.Join(failures, "; "), status)
+}
+
+// WriteReplicaResults answers with the response of the first acknowledging replica once the quorum is reached
+func WriteReplicaResults(w http.ResponseWriter, results []ReplicaResult, quorum int) {
+	if Acknowledged(results) < quorum {
+		WriteReplicaFailure(w, "quorum not reached", results)
+		return
+	}
+
+	for _, result := range results {
+		if !result.OK() {
+			continue
+		}
+		if len(result.Body) > 0 {
+			w.Header().Set("Content-Type", "application/json")
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write(result.Body)
+		return
+	}
+}
+
+// MergeListings merges the records of a group answered by its replicas
+//
+// An element is kept when at least half of the answering replicas hold it,
+// with the checksum held by most of them. Replicas that disagree with the
+// merged view are returned as repairs. With write quorums above half of the
+// replicas, the majority always holds the last acknowledged state.
+func MergeListings(namespace, groupId string, addresses []string, listings [][]internal.Record, answered []bool) ([]internal.Record, []ElementRepair) {
+	responding := 0
+	order := make([]string, 0)
+	holders := make(map[string]map[int]internal.Record)
+	for i, listing := range listings {
+		if !answered[i] {
+			continue
+		}
+		responding++
+		for _, record := range listing {
+			if _, ok := holders[record.Id]; !ok {
+				holders[record.Id] = make(map[int]internal.Record)
+				order = append(order, record.Id)
+			}
+			holders[record.Id][i] = record
+		}
+	}
+
+	merged := make([]internal.Record, 0, len(order))
+	repairs := make([]ElementRepair, 0)
+	for _, id := range order {
+		present := holders[id]
+
+		// The majority deleted the element, or never received it
+		if len(present)*2 < responding {
+			repair := ElementRepair{Namespace: namespace, GroupId: groupId}
+			for i := range addresses {
+				if record, ok := present[i]; ok {
+					repair.Record = record
+					repair.Stale = append(repair.Stale, addresses[i])
+				}
+			}
+			repairs = append(repairs, repair)
+			continue
+		}
+
+		// Elect the checksum held by most replicas, the first in ring order on ties
+		votes := make(map[string]int)
+		elected := -1
+		for i := range addresses {
+			record, ok := present[i]
+			if !ok {
+				continue
+			}
+			checksum := record.Attributes["checksum"]
+			votes[checksum]++
+			if elected < 0 || votes[checksum] > votes[present[elected].Attributes["checksum"]] {
+				elected = i
+			}
+		}
+
+		repair := ElementRepair{
+			Namespace: namespace,
+			GroupId:   groupId,
+			Record:    present[elected],
+			Source:    addresses[elected],
+		}
+		checksum := repair.Record.Attributes["checksum"]
+		for i := range addresses {
+			if !answered[i] {
+				continue
+			}
+			record, ok := present[i]
+			if !ok || record.Attributes["checksum"] != checksum {
+				repair.Missing = append(repair.Missing, addresses[i])
+			}
+		}
+
+		merged = append(merged, repair.Record)
+		if len(repair.Missing) > 0 {
+			repairs = append(repairs, repair)
+		}
+	}
+
+	return merged, repairs
+}
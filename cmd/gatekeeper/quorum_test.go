@@ -0,0 +1,89 @@
+package gatekeeper
+
+import (
+	"datavault/cmd/internal"
+	"reflect"
+	"testing"
+)
+
+// record returns a record of an element with a checksum
+func record(id, checksum string) internal.Record {
+	return internal.Record{Id: id, Attributes: map[string]string{"checksum": checksum}}
+}
+
+func TestMergeListings(t *testing.T) {
+	addresses := []string{"v1", "v2", "v3"}
+	tests := []struct {
+		name     string
+		listings [][]internal.Record
+		answered []bool
+		merged   []string
+		repairs  []ElementRepair
+	}{
+		{
+			name:     "replicas agree",
+			listings: [][]internal.Record{{record("a", "x")}, {record("a", "x")}, {record("a", "x")}},
+			answered: []bool{true, true, true},
+			merged:   []string{"a"},
+			repairs:  []ElementRepair{},
+		},
+		{
+			name:     "replica missing an element",
+			listings: [][]internal.Record{{record("a", "x")}, {record("a", "x")}, {}},
+			answered: []bool{true, true, true},
+			merged:   []string{"a"},
+			repairs:  []ElementRepair{{Namespace: "ns", GroupId: "g", Record: record("a", "x"), Source: "v1", Missing: []string{"v3"}}},
+		},
+		{
+			name:     "element held by a minority",
+			listings: [][]internal.Record{{}, {}, {record("b", "x")}},
+			answered: []bool{true, true, true},
+			merged:   []string{},
+			repairs:  []ElementRepair{{Namespace: "ns", GroupId: "g", Record: record("b", "x"), Stale: []string{"v3"}}},
+		},
+		{
+			name:     "diverging checksum",
+			listings: [][]internal.Record{{record("a", "x")}, {record("a", "y")}, {record("a", "y")}},
+			answered: []bool{true, true, true},
+			merged:   []string{"a"},
+			repairs:  []ElementRepair{{Namespace: "ns", GroupId: "g", Record: record("a", "y"), Source: "v3", Missing: []string{"v1"}}},
+		},
+		{
+			name:     "tie elects the first replica",
+			listings: [][]internal.Record{{record("a", "x")}, {record("a", "y")}, nil},
+			answered: []bool{true, true, false},
+			merged:   []string{"a"},
+			repairs:  []ElementRepair{{Namespace: "ns", GroupId: "g", Record: record("a", "x"), Source: "v1", Missing: []string{"v2"}}},
+		},
+		{
+			name:     "unanswered replica is not repaired",
+			listings: [][]internal.Record{{record("a", "x")}, {}, nil},
+			answered: []bool{true, true, false},
+			merged:   []string{"a"},
+			repairs:  []ElementRepair{{Namespace: "ns", GroupId: "g", Record: record("a", "x"), Source: "v1", Missing: []string{"v2"}}},
+		},
+		{
+			name:     "no replica answered",
+			listings: [][]internal.Record{nil, nil, nil},
+			answered: []bool{false, false, false},
+			merged:   []string{},
+			repairs:  []ElementRepair{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			merged, repairs := MergeListings("ns", "g", addresses, test.listings, test.answered)
+			ids := make([]string, 0, len(merged))
+			for _, record := range merged {
+				ids = append(ids, record.Id)
+			}
+			if !reflect.DeepEqual(ids, test.merged) {
+				t.Errorf("merged %v, want %v", ids, test.merged)
+			}
+			if !reflect.DeepEqual(repairs, test.repairs) {
+				t.Errorf("repairs %+v, want %+v", repairs, test.repairs)
+			}
+		})
+	}
+}
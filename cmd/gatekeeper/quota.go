@@ -0,0 +1,276 @@
+package gatekeeper
+
+import (
+	"datavault/cmd/internal"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"sync"
+)
+
+// ErrQuotaExceeded is returned when an upload would take a group or a namespace over its quota
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// ErrUsageUnavailable is returned when too few vaults reported their usage to check a quota
+var ErrUsageUnavailable = errors.New("usage unavailable")
+
+// Quota limits the storage of a group or a namespace, zero values are unlimited
+type Quota struct {
+	MaxBytes int64 `json:"max_bytes"` // Largest total size of the elements in bytes
+	MaxFiles int64 `json:"max_files"` // Largest number of elements
+}
+
+// Unlimited reports whether the quota sets no limit
+func (q Quota) Unlimited() bool {
+	return q.MaxBytes <= 0 && q.MaxFiles <= 0
+}
+
+// check returns an error naming the limit usage would exceed
+func (q Quota) check(what string, usage internal.Usage) error {
+	if q.MaxBytes > 0 && usage.Bytes > q.MaxBytes {
+		return fmt.Errorf("%w: %s would hold %d bytes, the limit is %d", ErrQuotaExceeded, what, usage.Bytes, q.MaxBytes)
+	}
+	if q.MaxFiles > 0 && usage.Files > q.MaxFiles {
+		return fmt.Errorf("%w: %s would hold %d files, the limit is %d", ErrQuotaExceeded, what, usage.Files, q.MaxFiles)
+	}
+	return nil
+}
+
+// QuotaConfig is the quotas of the groups and namespaces of the cluster
+type QuotaConfig struct {
+	Group      Quota            `json:"group"`      // Quota of every group without its own
+	Namespace  Quota            `json:"namespace"`  // Quota of every namespace without its own
+	Groups     map[string]Quota `json:"groups"`     // Quotas of specific groups, keyed by group id or "namespace/group" outside the default namespace
+	Namespaces map[string]Quota `json:"namespaces"` // Quotas of specific namespaces
+}
+
+// GroupQuota returns the quota of a group
+func (c QuotaConfig) GroupQuota(namespace, groupId string) Quota {
+	if quota, ok := c.Groups[RingKey(namespace, groupId)]; ok {
+		return quota
+	}
+	return c.Group
+}
+
+// NamespaceQuota returns the quota of a namespace
+func (c QuotaConfig) NamespaceQuota(namespace string) Quota {
+	if quota, ok := c.Namespaces[namespace]; ok {
+		return quota
+	}
+	return c.Namespace
+}
+
+// QuotaApplies reports whether uploads to a group are limited by a quota
+func QuotaApplies(namespace, groupId string) bool {
+	return !KeeperConfig.Quotas.GroupQuota(namespace, groupId).Unlimited() || !KeeperConfig.Quotas.NamespaceQuota(namespace).Unlimited()
+}
+
+var (
+	reservedMu sync.Mutex
+	reserved   = make(map[string]internal.Usage) // Usage of the uploads in progress, by group ring key and by namespace
+)
+
+// reservationKeys returns the keys an upload reserves usage under
+func reservationKeys(namespace, groupId string) (string, string) {
+	return "group:" + RingKey(namespace, groupId), "namespace:" + namespace
+}
+
+// QuotaStatus returns the HTTP status of a quota reservation error
+func QuotaStatus(err error) int {
+	if errors.Is(err, ErrUsageUnavailable) {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusRequestEntityTooLarge
+}
+
+// checkUsageAvailable checks that enough vaults reported their usage to check the quotas of a group and its namespace
+//
+// Any vault may hold elements of a namespace, a limited namespace needs every
+// vault to answer. A limited group needs a read quorum of its replicas.
+func checkUsageAvailable(namespace, groupId string, unavailable []string) error {
+	if len(unavailable) == 0 {
+		return nil
+	}
+	if !KeeperConfig.Quotas.NamespaceQuota(namespace).Unlimited() {
+		return fmt.Errorf("%w: vaults %v did not report the usage of namespace %s", ErrUsageUnavailable, unavailable, namespace)
+	}
+	if KeeperConfig.Quotas.GroupQuota(namespace, groupId).Unlimited() {
+		return nil
+	}
+
+	answered := 0
+	for _, address := range LocateGroup(namespace, groupId) {
+		if !slices.Contains(unavailable, address) {
+			answered++
+		}
+	}
+	if answered < CurrentCluster().ReadQuorum() {
+		return fmt.Errorf("%w: %d replicas of group %s reported their usage, the read quorum is %d", ErrUsageUnavailable, answered, groupId, CurrentCluster().ReadQuorum())
+	}
+	return nil
+}
+
+// UploadUsage returns the usage of the files of an upload
+func UploadUsage(files []*multipart.FileHeader) internal.Usage {
+	upload := internal.Usage{Files: int64(len(files))}
+	for _, fileHeader := range files {
+		upload.Bytes += fileHeader.Size
+	}
+	return upload
+}
+
+// ReserveQuota checks that an upload fits the quotas of its group and namespace and reserves its usage
+//
+// The usage of the cluster is read from the vaults, replicas of a group are
+// counted once, and uploads in progress through this gatekeeper are added to
+// it. Quotas are not checked against a partial usage, ErrUsageUnavailable is
+// returned when too few vaults answered. The returned function releases the
+// reservation once the upload is over.
+func ReserveQuota(namespace, groupId string, upload internal.Usage) (func(), error) {
+	usage, unavailable := ClusterUsage(CurrentCluster().Addresses, namespace)
+	err := checkUsageAvailable(namespace, groupId, unavailable)
+	if err != nil {
+		return nil, err
+	}
+	namespaceUsage := usage[namespace]
+
+	groupKey, namespaceKey := reservationKeys(namespace, groupId)
+	reservedMu.Lock()
+	defer reservedMu.Unlock()
+
+	groupTotal := namespaceUsage.Groups[groupId].Add(reserved[groupKey]).Add(upload)
+	err = KeeperConfig.Quotas.GroupQuota(namespace, groupId).check("group "+groupId, groupTotal)
+	if err != nil {
+		return nil, err
+	}
+	namespaceTotal := namespaceUsage.Usage.Add(reserved[namespaceKey]).Add(upload)
+	err = KeeperConfig.Quotas.NamespaceQuota(namespace).check("namespace "+namespace, namespaceTotal)
+	if err != nil {
+		return nil, err
+	}
+
+	reserved[groupKey] = reserved[groupKey].Add(upload)
+	reserved[namespaceKey] = reserved[namespaceKey].Add(upload)
+
+	return func() {
+		reservedMu.Lock()
+		defer reservedMu.Unlock()
+		release := internal.Usage{Bytes: -upload.Bytes, Files: -upload.Files}
+		for _, key := range []string{groupKey, namespaceKey} {
+			reserved[key] = reserved[key].Add(release)
+			if reserved[key].Files <= 0 {
+				delete(reserved, key)
+			}
+		}
+	}, nil
+}
+
+// ClusterUsage gathers the usage of one namespace, or of every namespace when namespace is empty, from every vault
+//
+// Replicas of a group are counted once, with the usage of the replica holding
+// the most bytes. The vaults that did not answer are returned.
+func ClusterUsage(addresses []string, namespace string) (map[string]internal.NamespaceUsage, []string) {
+	query := url.Values{}
+	if namespace != "" {
+		query.Set(internal.NamespaceParam, namespace)
+	}
+	results := FanOutRequest(http.MethodGet, "/usage", query, addresses)
+
+	merged := make(map[string]internal.NamespaceUsage)
+	unavailable := make([]string, 0)
+	for _, result := range results {
+		var vaultUsage map[string]internal.NamespaceUsage
+		if !result.OK() || json.Unmarshal(result.Body, &vaultUsage) != nil {
+			unavailable = append(unavailable, result.Address)
+			continue
+		}
+
+		for name, vaultNamespace := range vaultUsage {
+			namespaceUsage, ok := merged[name]
+			if !ok {
+				namespaceUsage = internal.NamespaceUsage{Groups: make(map[string]internal.Usage)}
+				merged[name] = namespaceUsage
+			}
+			for groupId, usage := range vaultNamespace.Groups {
+				if current, ok := namespaceUsage.Groups[groupId]; !ok || usage.Bytes > current.Bytes {
+					namespaceUsage.Groups[groupId] = usage
+				}
+			}
+		}
+	}
+
+	for name, namespaceUsage := range merged {
+		namespaceUsage.Usage = internal.Usage{}
+		for _, usage := range namespaceUsage.Groups {
+			namespaceUsage.Usage = namespaceUsage.Usage.Add(usage)
+		}
+		merged[name] = namespaceUsage
+	}
+
+	return merged, unavailable
+}
+
+// GroupUsageReport is the usage of a group against its quota
+type GroupUsageReport struct {
+	GroupId string         `json:"groupId"` // Group identifier
+	Usage   internal.Usage `json:"usage"`   // Usage of the group
+	Quota   Quota          `json:"quota"`   // Quota of the group
+}
+
+// NamespaceUsageReport is the usage of a namespace and of its groups against their quotas
+type NamespaceUsageReport struct {
+	Namespace string             `json:"namespace"` // Namespace name
+	Usage     internal.Usage     `json:"usage"`     // Usage of the namespace
+	Quota     Quota              `json:"quota"`     // Quota of the namespace
+	Groups    []GroupUsageReport `json:"groups"`    // Groups of the namespace, sorted by id
+}
+
+// UsageReport is the usage of the cluster against its quotas
+type UsageReport struct {
+	Namespaces  []NamespaceUsageReport `json:"namespaces"`            // Namespaces holding elements, sorted by name
+	Unavailable []string               `json:"unavailable,omitempty"` // Vaults that did not report their usage
+}
+
+// HandlerUsage returns the usage of every namespace and group of the cluster against their quotas
+func HandlerUsage(w http.ResponseWriter, r *http.Request) {
+	usage, unavailable := ClusterUsage(CurrentCluster().Addresses, r.URL.Query().Get(internal.NamespaceParam))
+
+	report := UsageReport{Namespaces: make([]NamespaceUsageReport, 0, len(usage))}
+	if len(unavailable) > 0 {
+		report.Unavailable = unavailable
+	}
+	for name, namespaceUsage := range usage {
+		namespaceReport := NamespaceUsageReport{
+			Namespace: name,
+			Usage:     namespaceUsage.Usage,
+			Quota:     KeeperConfig.Quotas.NamespaceQuota(name),
+			Groups:    make([]GroupUsageReport, 0, len(namespaceUsage.Groups)),
+		}
+		for groupId, groupUsage := range namespaceUsage.Groups {
+			namespaceReport.Groups = append(namespaceReport.Groups, GroupUsageReport{
+				GroupId: groupId,
+				Usage:   groupUsage,
+				Quota:   KeeperConfig.Quotas.GroupQuota(name, groupId),
+			})
+		}
+		sort.Slice(namespaceReport.Groups, func(i, j int) bool {
+			return namespaceReport.Groups[i].GroupId < namespaceReport.Groups[j].GroupId
+		})
+		report.Namespaces = append(report.Namespaces, namespaceReport)
+	}
+	sort.Slice(report.Namespaces, func(i, j int) bool {
+		return report.Namespaces[i].Namespace < report.Namespaces[j].Namespace
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(report)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,72 @@
+package gatekeeper
+
+import (
+	"datavault/cmd/internal"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// testUsageCluster routes requests to vaults holding no element, the first down vaults do not answer
+func testUsageCluster(t *testing.T, vaults, down int) {
+	t.Helper()
+	entries := make([]VaultEntry, vaults)
+	for i := range entries {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodHead {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Write([]byte("{}"))
+		}))
+		t.Cleanup(server.Close)
+		if i < down {
+			server.Close()
+		}
+		entries[i].Address = server.Listener.Addr().String()
+	}
+
+	var err error
+	vaultClient, err = NewVaultClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous, config := CurrentCluster(), KeeperConfig
+	KeeperConfig.Replicas, KeeperConfig.ReadQuorum, KeeperConfig.BroadcastTimeout = vaults, vaults/2+1, 1
+	currentCluster.Store(BuildCluster(entries))
+	t.Cleanup(func() {
+		currentCluster.Store(previous)
+		KeeperConfig = config
+	})
+}
+
+func TestReserveQuotaUnavailableUsage(t *testing.T) {
+	tests := []struct {
+		name   string
+		quotas QuotaConfig
+		down   int
+		err    error
+	}{
+		{name: "group quota, read quorum answered", quotas: QuotaConfig{Group: Quota{MaxFiles: 10}}, down: 1},
+		{name: "group quota, read quorum missed", quotas: QuotaConfig{Group: Quota{MaxFiles: 10}}, down: 2, err: ErrUsageUnavailable},
+		{name: "namespace quota, vault missing", quotas: QuotaConfig{Namespace: Quota{MaxFiles: 10}}, down: 1, err: ErrUsageUnavailable},
+		{name: "namespace quota, every vault answered", quotas: QuotaConfig{Namespace: Quota{MaxFiles: 10}}},
+		{name: "over quota", quotas: QuotaConfig{Group: Quota{MaxFiles: 1}}, err: ErrQuotaExceeded},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			testUsageCluster(t, 3, test.down)
+			KeeperConfig.Quotas = test.quotas
+
+			release, err := ReserveQuota(internal.DefaultNamespace, "g", internal.Usage{Bytes: 10, Files: 2})
+			if !errors.Is(err, test.err) {
+				t.Fatalf("error %v, want %v", err, test.err)
+			}
+			if err == nil {
+				release()
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package gatekeeper
+
+import (
+	"datavault/cmd/internal"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// repairsInFlight holds the elements being repaired, to avoid concurrent repairs of the same element
+var repairsInFlight sync.Map
+
+// quoteEscaper escapes file names in Content-Disposition headers
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+// RepairElements brings diverging replicas back in agreement in the background
+func RepairElements(repairs []ElementRepair) {
+	for _, repair := range repairs {
+		key := RingKey(repair.Namespace, repair.GroupId) + "/" + repair.Record.Id
+		if _, running := repairsInFlight.LoadOrStore(key, struct{}{}); running {
+			continue
+		}
+
+		go func() {
+			defer repairsInFlight.Delete(key)
+
+			err := RepairElement(repair)
+			if err != nil {
+				log.Printf("Error repairing element %s: %v\n", key, err)
+				return
+			}
+			log.Printf("Repaired element %s\n", key)
+		}()
+	}
+}
+
+// RepairElement removes stale copies of an element and copies the agreed version to the replicas missing it
+func RepairElement(repair ElementRepair) error {
+	// Stale and diverging copies are deleted for good, not moved to the trash
+	query := url.Values{"namespace": {repair.Namespace}, "groupId": {repair.GroupId}, "elementId": {repair.Record.Id}, internal.PurgeParam: {"true"}}
+
+	for _, address := range repair.Stale {
+		result := sendToVault(http.MethodDelete, address, "/group/element", query, "", nil)
+		if result.Err != nil || (result.StatusCode != http.StatusOK && result.StatusCode != http.StatusNotFound) {
+			return fmt.Errorf("deleting stale copy: %s", result)
+		}
+	}
+
+	for _, address := range repair.Missing {
+		// Remove a diverging version before copying the agreed one
+		result := sendToVault(http.MethodDelete, address, "/group/element", query, "", nil)
+		if result.Err != nil || (result.StatusCode != http.StatusOK && result.StatusCode != http.StatusNotFound) {
+			return fmt.Errorf("deleting diverging copy: %s", result)
+		}
+
+		err := CopyElement(repair.Source, address, repair.Namespace, repair.GroupId, repair.Record)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// CopyElement streams an element from one vault to another, keeping its id, received time, expiry and key
+func CopyElement(source, target, namespace, groupId string, record internal.Record) error {
+	query := url.Values{"namespace": {namespace}, "groupId": {groupId}, "elementId": {record.Id}}
+
+	// Copies are bounded by the element size, not by the broadcast timeout
+	req, err := http.NewRequest(http.MethodGet, VaultURL(source, "/group/element", query), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := vaultClient.Do(req, time.Duration(KeeperConfig.TransferTimeout)*time.Second)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("reading element from %s: %s", source, resp.Status)
+	}
+
+	return SendElement(target, namespace, groupId, record, resp.Body)
+}
+
+// SendElement uploads the content of an element to a vault, keeping its id, received time, expiry, key, path and retention
+//
+// An element the vault already holds counts as sent.
+func SendElement(target, namespace, groupId string, record internal.Record, content io.Reader) error {
+	metaBytes, err := json.Marshal([]internal.Meta{recordMeta(record)})
+	if err != nil {
+		return err
+	}
+
+	reader, pipeWriter := io.Pipe()
+	writer := multipart.NewWriter(pipeWriter)
+	go func() {
+		pipeWriter.CloseWithError(writeElementBody(writer, record, content, metaBytes))
+	}()
+	defer reader.Close()
+
+	query := url.Values{"namespace": {namespace}, "groupId": {groupId}, internal.PresetParam: {"true"}}
+	result := sendToVault(http.MethodPut, target, "/group", query, writer.FormDataContentType(), reader)
+	if result.Err == nil && result.StatusCode == http.StatusConflict {
+		return nil
+	}
+	if !result.OK() {
+		return fmt.Errorf("writing element to %s", result)
+	}
+
+	return nil
+}
+
+// writeElementBody writes the multipart body uploading a single element
+func writeElementBody(writer *multipart.Writer, record internal.Record, content io.Reader, metaBytes []byte) error {
+	err := writer.WriteField("meta", string(metaBytes))
+	if err != nil {
+		return err
+	}
+
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="files"; filename="%s"`, quoteEscaper.Replace(record.Attributes["fileName"])))
+	header.Set("Content-Type", record.Attributes["fileType"])
+	part, err := writer.CreatePart(header)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(part, content)
+	if err != nil {
+		return err
+	}
+
+	return writer.Close()
+}
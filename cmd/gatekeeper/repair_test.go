@@ -0,0 +1,62 @@
+package gatekeeper
+
+import (
+	"datavault/cmd/internal"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// testVaultServer starts a vault accepting uploads, the preset metadata of each upload is sent on the returned channel
+func testVaultServer(t *testing.T) (string, chan []internal.Meta) {
+	t.Helper()
+	uploads := make(chan []internal.Meta, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut || r.URL.Path != "/group" || r.URL.Query().Get(internal.PresetParam) != "true" {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		var preset []internal.Meta
+		err := json.Unmarshal([]byte(r.FormValue("meta")), &preset)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		uploads <- preset
+	}))
+	t.Cleanup(server.Close)
+
+	var err error
+	vaultClient, err = NewVaultClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return server.Listener.Addr().String(), uploads
+}
+
+func TestSendElement(t *testing.T) {
+	address, uploads := testVaultServer(t)
+	record := internal.Record{Id: "a1", Attributes: map[string]string{
+		"fileName":     "report.txt",
+		"receivedTime": "1700000000000",
+		"key":          "docs/report.txt",
+		"path":         "docs/report.txt",
+		"legalHold":    "true",
+	}}
+
+	err := SendElement(address, "ns", "g", record, strings.NewReader("content"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	preset := <-uploads
+	if len(preset) != 1 {
+		t.Fatalf("%d elements sent, want 1", len(preset))
+	}
+	meta := preset[0]
+	if meta.FileId != "a1" || meta.ReceivedTime != "1700000000000" || meta.Key != "docs/report.txt" || meta.Path != "docs/report.txt" || meta.LegalHold != "true" {
+		t.Errorf("sent metadata %+v does not match the record", meta)
+	}
+}
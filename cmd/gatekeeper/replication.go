@@ -0,0 +1,247 @@
+package gatekeeper
+
+import (
+	"datavault/cmd/internal"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ReplicaResult is the outcome of a request sent to one vault of a replica set
+type ReplicaResult struct {
+	Address    string // Address of the vault (host:port)
+	StatusCode int    // Status code answered by the vault, 0 if unreachable
+	Body       []byte // Body answered by the vault
+	Err        error  // Error reaching the vault
+	Hint       string // Hint stored in place of the vault, if it was unavailable
+}
+
+// OK reports whether the vault acknowledged the request
+func (r ReplicaResult) OK() bool {
+	return r.Err == nil && r.StatusCode == http.StatusOK
+}
+
+// String describes the outcome for error messages
+func (r ReplicaResult) String() string {
+	if r.Err != nil {
+		return fmt.Sprintf("%s: %v", r.Address, r.Err)
+	}
+	return fmt.Sprintf("%s: %d %s", r.Address, r.StatusCode, strings.TrimSpace(string(r.Body)))
+}
+
+// sendToVault sends a request to a vault and reads the full response
+func sendToVault(method, address, path string, query url.Values, contentType string, body io.Reader) ReplicaResult {
+	result := ReplicaResult{Address: address}
+
+	req, err := http.NewRequest(method, VaultURL(address, path, query), body)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	// Uploads are bounded by the transfer timeout, not by the broadcast timeout
+	timeout := time.Duration(KeeperConfig.BroadcastTimeout) * time.Second
+	if body != nil {
+		timeout = time.Duration(KeeperConfig.TransferTimeout) * time.Second
+	}
+
+	resp, err := vaultClient.Do(req, timeout)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	defer resp.Body.Close()
+
+	result.StatusCode = resp.StatusCode
+	result.Body, result.Err = io.ReadAll(resp.Body)
+	return result
+}
+
+// FanOutRequest sends a body-less request to every vault in parallel
+func FanOutRequest(method, path string, query url.Values, addresses []string) []ReplicaResult {
+	results := make([]ReplicaResult, len(addresses))
+	forEachVault(addresses, func(i int, address string) {
+		results[i] = sendToVault(method, address, path, query, "", nil)
+	})
+
+	return results
+}
+
+// writeReplicaBody writes the multipart body of a replicated upload
+func writeReplicaBody(writer *multipart.Writer, files []*multipart.FileHeader, metaBytes []byte) error {
+	err := writer.WriteField("meta", string(metaBytes))
+	if err != nil {
+		return err
+	}
+
+	for _, fileHeader := range files {
+		part, err := writer.CreatePart(fileHeader.Header)
+		if err != nil {
+			return err
+		}
+
+		file, err := fileHeader.Open()
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(part, file)
+		file.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return writer.Close()
+}
+
+// NewUploadMeta assigns the element ids and received time shared by every replica of an upload
+//
+// template carries the namespace, group, expiry and key common to the files.
+func NewUploadMeta(files []*multipart.FileHeader, template internal.Meta) []internal.Meta {
+	receivedTime := fmt.Sprintf("%d", time.Now().UnixMilli())
+	preset := make([]internal.Meta, len(files))
+	for i, fileHeader := range files {
+		preset[i] = template
+		preset[i].FileId = strings.ReplaceAll(uuid.New().String(), "-", "")
+		preset[i].FileType = fileHeader.Header.Get("Content-Type")
+		preset[i].FileName = fileHeader.Filename
+		preset[i].FileExtension = filepath.Ext(fileHeader.Filename)
+		preset[i].FileSize = fmt.Sprintf("%d", fileHeader.Size)
+		preset[i].ReceivedTime = receivedTime
+	}
+
+	return preset
+}
+
+// ReplicateUpload writes the files of a multipart upload to every vault with the ids of preset
+//
+// The query names the namespace and group and carries the pre-signed URL of the upload, if any.
+func ReplicateUpload(query url.Values, files []*multipart.FileHeader, preset []internal.Meta, addresses []string) []ReplicaResult {
+	query = maps.Clone(query)
+	query.Set(internal.PresetParam, "true")
+	results := make([]ReplicaResult, len(addresses))
+	metaBytes, err := json.Marshal(preset)
+	if err != nil {
+		for i, address := range addresses {
+			results[i] = ReplicaResult{Address: address, Err: err}
+		}
+		return results
+	}
+
+	forEachVault(addresses, func(i int, address string) {
+		reader, pipeWriter := io.Pipe()
+		writer := multipart.NewWriter(pipeWriter)
+		go func() {
+			pipeWriter.CloseWithError(writeReplicaBody(writer, files, metaBytes))
+		}()
+
+		results[i] = sendToVault(http.MethodPut, address, "/group", query, writer.FormDataContentType(), reader)
+		reader.Close()
+	})
+
+	return results
+}
+
+// NewRollbackToken returns the token sent along a write, so that the elements it stores can be rolled back despite their retention
+func NewRollbackToken() string {
+	return strings.ReplaceAll(uuid.New().String(), "-", "")
+}
+
+// DropGatekeeperParams removes the rollback token and the preset marker from client requests, only the gatekeeper sets them on the requests to vaults
+func DropGatekeeperParams(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if query.Has(internal.RollbackParam) || query.Has(internal.PresetParam) {
+			query.Del(internal.RollbackParam)
+			query.Del(internal.PresetParam)
+			r.URL.RawQuery = query.Encode()
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RollbackUpload removes the elements stored by the replicas that acknowledged a failed upload
+//
+// token is the rollback token the upload was sent with, so that elements
+// locked by retention are removed as well.
+func RollbackUpload(namespace, groupId, token string, files []*multipart.FileHeader, results []ReplicaResult) {
+	for _, result := range results {
+		if !result.OK() {
+			continue
+		}
+
+		if result.Hint != "" {
+			var size int64
+			for _, fileHeader := range files {
+				size += fileHeader.Size
+			}
+			err := DiscardHint(result.Address, result.Hint, size)
+			if err != nil {
+				log.Printf("Error rolling back hint %s: %v\n", result.Hint, err)
+			}
+			continue
+		}
+
+		var metadata []internal.Meta
+		err := json.Unmarshal(result.Body, &metadata)
+		if err != nil {
+			log.Printf("Error rolling back upload on vault %s: %v\n", result.Address, err)
+			continue
+		}
+
+		for _, meta := range metadata {
+			query := url.Values{"namespace": {namespace}, "groupId": {groupId}, "elementId": {meta.FileId}, internal.PurgeParam: {"true"}, internal.RollbackParam: {token}}
+			rollback := sendToVault(http.MethodDelete, result.Address, "/group/element", query, "", nil)
+			if !rollback.OK() {
+				log.Printf("Error rolling back element %s: %s\n", meta.FileId, rollback)
+			}
+		}
+	}
+}
+
+// ExtractUpload extracts the entries of uploaded archives within the extraction limits of the gatekeeper
+//
+// The extracted files and their relative paths are returned in the same order,
+// the returned form holds them and must be removed by the caller.
+func ExtractUpload(archives []*multipart.FileHeader) ([]*multipart.FileHeader, []string, *multipart.Form, error) {
+	limits := internal.ArchiveLimits{
+		MaxEntries: KeeperConfig.ArchiveMaxEntries,
+		MaxSize:    KeeperConfig.ArchiveMaxSize,
+		MaxRatio:   max(KeeperConfig.ArchiveMaxRatio, 0),
+	}
+	files, paths, form, err := internal.ExtractArchives(archives, limits, KeeperConfig.IN_MEMORY_UPLOAD_SIZE)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if len(files) == 0 {
+		form.RemoveAll()
+		return nil, nil, nil, fmt.Errorf("%w: no regular file", internal.ErrUnknownArchive)
+	}
+	return files, paths, form, nil
+}
+
+// WriteExtractError writes the status of a failed archive extraction
+func WriteExtractError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, internal.ErrArchiveTooLarge):
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+	case errors.Is(err, internal.ErrUnknownArchive), errors.Is(err, internal.ErrUnsafeArchive):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
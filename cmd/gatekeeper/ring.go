@@ -0,0 +1,397 @@
+package gatekeeper
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RingRecord is the version-stamped ring shared by the gatekeepers through the vaults
+//
+// A record is committed once a majority of its vaults and a majority of the
+// vaults of the record it replaces store it. Vaults only accept records newer
+// than the one they hold, so concurrent proposals for the same version, made
+// from the same committed record, cannot both be committed.
+type RingRecord struct {
+	Version   uint64         `json:"version"`            // Version of the record, increased by every change
+	Vaults    []VaultEntry   `json:"vaults"`             // Vaults of the ring, sorted by address
+	Weights   map[string]int `json:"weights"`            // Ring weight of every vault
+	Previous  []string       `json:"previous,omitempty"` // Vaults of the committed record it replaces, sorted
+	UpdatedBy string         `json:"updatedBy"`          // Gatekeeper that proposed the record
+	UpdatedAt int64          `json:"updatedAt"`          // Time of the proposal in milliseconds
+}
+
+// ringSync is the state of the shared ring of this gatekeeper
+var ringSync struct {
+	sync.Mutex
+	record   RingRecord // Committed record the cluster was built from
+	newest   uint64     // Newest version observed on any vault
+	lastSync time.Time  // Last time a majority of vaults was read
+}
+
+// ringSyncing is set while a triggered ring sync runs
+var ringSyncing atomic.Bool
+
+// SharedRingEnabled reports whether the ring is shared with other gatekeepers through the vaults
+func SharedRingEnabled() bool {
+	return KeeperConfig.SharedRing
+}
+
+// RingVersion returns the version of the ring used to route requests
+func RingVersion() uint64 {
+	ringSync.Lock()
+	defer ringSync.Unlock()
+	return ringSync.record.Version
+}
+
+// RingStale reports whether the gatekeeper must not route requests with its ring
+//
+// The ring is stale when a vault holds a newer record, or when no majority of
+// vaults could be read for longer than the maximum staleness.
+func RingStale() bool {
+	if !SharedRingEnabled() {
+		return false
+	}
+
+	ringSync.Lock()
+	defer ringSync.Unlock()
+	maxStaleness := time.Duration(KeeperConfig.RingMaxStaleness) * time.Second
+	return ringSync.newest > ringSync.record.Version || time.Since(ringSync.lastSync) > maxStaleness
+}
+
+// ObserveRingVersion records a ring version reported by a vault, a newer one triggers a sync
+func ObserveRingVersion(header string) {
+	if !SharedRingEnabled() || header == "" {
+		return
+	}
+	version, err := strconv.ParseUint(header, 10, 64)
+	if err != nil {
+		return
+	}
+
+	ringSync.Lock()
+	newer := version > ringSync.newest
+	if newer {
+		ringSync.newest = version
+	}
+	ringSync.Unlock()
+
+	if newer {
+		TriggerRingSync()
+	}
+}
+
+// TriggerRingSync synchronizes the shared ring in the background unless a triggered sync is running
+func TriggerRingSync() {
+	if !ringSyncing.CompareAndSwap(false, true) {
+		return
+	}
+
+	go func() {
+		defer ringSyncing.Store(false)
+		SyncRing()
+	}()
+}
+
+// SetRingVersion stamps a request to a vault with the version of the ring it was routed with
+func SetRingVersion(header http.Header) {
+	if SharedRingEnabled() {
+		header.Set("X-Ring-Version", strconv.FormatUint(RingVersion(), 10))
+	}
+}
+
+// CheckRingVersion looks for a vault rejecting a request routed with a stale ring
+func CheckRingVersion(resp *http.Response) {
+	if resp.StatusCode == http.StatusServiceUnavailable {
+		ObserveRingVersion(resp.Header.Get("X-Ring-Version"))
+	}
+}
+
+// recordKey identifies the content of a ring record
+func recordKey(record RingRecord) string {
+	data, _ := json.Marshal([]any{record.Version, record.Vaults, record.Weights, record.Previous})
+	return string(data)
+}
+
+// readRingRecords reads the ring record of every vault, unreachable vaults are left out
+func readRingRecords(addresses []string) map[string]RingRecord {
+	records := make([]*RingRecord, len(addresses))
+	forEachVault(addresses, func(i int, address string) {
+		result := sendToVault(http.MethodGet, address, "/ring", url.Values{}, "", nil)
+		if !result.OK() {
+			return
+		}
+		var record RingRecord
+		if json.Unmarshal(result.Body, &record) == nil {
+			records[i] = &record
+		}
+	})
+
+	byAddress := make(map[string]RingRecord)
+	for i, record := range records {
+		if record != nil {
+			byAddress[addresses[i]] = *record
+		}
+	}
+	return byAddress
+}
+
+// writeRingRecord proposes a ring record to vaults and returns the vaults that stored it
+func writeRingRecord(record RingRecord, addresses []string) map[string]bool {
+	stored := make(map[string]bool)
+	data, err := json.Marshal(record)
+	if err != nil {
+		return stored
+	}
+
+	ok := make([]bool, len(addresses))
+	forEachVault(addresses, func(i int, address string) {
+		result := sendToVault(http.MethodPut, address, "/ring", url.Values{}, "application/json", bytes.NewReader(data))
+		ok[i] = result.OK()
+	})
+
+	for i, address := range addresses {
+		if ok[i] {
+			stored[address] = true
+		}
+	}
+	return stored
+}
+
+// majorityOf reports whether more than half of addresses are among the holders
+func majorityOf(addresses []string, holders map[string]bool) bool {
+	count := 0
+	for _, address := range addresses {
+		if holders[address] {
+			count++
+		}
+	}
+	return count*2 > len(addresses)
+}
+
+// recordCommitted reports whether the holders of a record are a majority of its vaults and of the vaults it replaces
+func recordCommitted(record RingRecord, holders map[string]bool) bool {
+	if !majorityOf(addressesOf(record.Vaults), holders) {
+		return false
+	}
+	return len(record.Previous) == 0 || majorityOf(record.Previous, holders)
+}
+
+// committedRecord returns the newest committed record among the records read from the vaults
+func committedRecord(records map[string]RingRecord) (RingRecord, bool) {
+	holders := make(map[string]map[string]bool)
+	candidates := make(map[string]RingRecord)
+	for address, record := range records {
+		key := recordKey(record)
+		candidates[key] = record
+		if holders[key] == nil {
+			holders[key] = make(map[string]bool)
+		}
+		holders[key][address] = true
+	}
+
+	var best RingRecord
+	found := false
+	for key, record := range candidates {
+		if !recordCommitted(record, holders[key]) {
+			continue
+		}
+		if !found || record.Version > best.Version {
+			best = record
+			found = true
+		}
+	}
+
+	return best, found
+}
+
+// addressesOf returns the addresses of vault entries
+func addressesOf(vaults []VaultEntry) []string {
+	addresses := make([]string, 0, len(vaults))
+	for _, vault := range vaults {
+		addresses = append(addresses, vault.Address)
+	}
+	return addresses
+}
+
+// unionAddresses returns the distinct addresses of several lists, sorted
+func unionAddresses(lists ...[]string) []string {
+	seen := make(map[string]bool)
+	union := make([]string, 0)
+	for _, list := range lists {
+		for _, address := range list {
+			if !seen[address] {
+				seen[address] = true
+				union = append(union, address)
+			}
+		}
+	}
+	sort.Strings(union)
+	return union
+}
+
+// proposedVaults merges the vaults known by this gatekeeper into the committed ones
+//
+// Vaults are only added, or removed when gossip reports that they left, so
+// that gatekeepers with different configurations converge on their union.
+func proposedVaults(committed []VaultEntry, known []VaultEntry, left map[string]bool) []VaultEntry {
+	vaults := make([]VaultEntry, 0, len(committed)+len(known))
+	seen := make(map[string]bool)
+	for _, list := range [][]VaultEntry{committed, known} {
+		for _, vault := range list {
+			if seen[vault.Address] || left[vault.Address] {
+				continue
+			}
+			seen[vault.Address] = true
+			vaults = append(vaults, vault)
+		}
+	}
+
+	sort.Slice(vaults, func(i, j int) bool {
+		return vaults[i].Address < vaults[j].Address
+	})
+	return vaults
+}
+
+// sameVaults reports whether two sorted vault lists are identical
+func sameVaults(a, b []VaultEntry) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// SyncRing adopts the newest committed ring record and proposes the vaults it is missing
+func SyncRing() {
+	clusterMu.Lock()
+	defer clusterMu.Unlock()
+
+	ringSync.Lock()
+	current := ringSync.record
+	ringSync.Unlock()
+
+	known, left := knownVaults()
+	addresses := unionAddresses(addressesOf(current.Vaults), addressesOf(known))
+	records := readRingRecords(addresses)
+	if len(addresses) > 0 && len(records)*2 <= len(addresses) {
+		log.Printf("Ring sync reached %d of %d vaults, keeping ring version %d\n", len(records), len(addresses), current.Version)
+		return
+	}
+
+	newest := current.Version
+	for _, record := range records {
+		newest = max(newest, record.Version)
+	}
+
+	committed, ok := committedRecord(records)
+	if ok && committed.Version > current.Version {
+		log.Printf("Adopted ring version %d from %s\n", committed.Version, committed.UpdatedBy)
+		current = committed
+	}
+
+	// Propose the vaults this gatekeeper knows and the ring is missing
+	vaults := proposedVaults(current.Vaults, known, left)
+	if !sameVaults(vaults, current.Vaults) {
+		proposal := RingRecord{
+			Version:   newest + 1,
+			Vaults:    vaults,
+			Weights:   ComputeRingWeights(addressesOf(vaults)),
+			Previous:  addressesOf(current.Vaults),
+			UpdatedBy: KeeperConfig.Advertise,
+			UpdatedAt: time.Now().UnixMilli(),
+		}
+		targets := unionAddresses(addressesOf(current.Vaults), addressesOf(vaults))
+		if recordCommitted(proposal, writeRingRecord(proposal, targets)) {
+			log.Printf("Committed ring version %d with %d vaults\n", proposal.Version, len(vaults))
+			current = proposal
+			newest = proposal.Version
+		}
+	}
+
+	ringSync.Lock()
+	previous := ringSync.record.Version
+	ringSync.record = current
+	ringSync.newest = max(ringSync.newest, newest)
+	ringSync.lastSync = time.Now()
+	ringSync.Unlock()
+
+	if current.Version != previous || CurrentCluster().Version != current.Version {
+		installCluster(BuildClusterWithWeights(current.Vaults, current.Weights, current.Version))
+	}
+}
+
+// RingSyncer periodically synchronizes the shared ring
+func RingSyncer() {
+	ticker := time.NewTicker(time.Duration(KeeperConfig.RingSyncInterval) * time.Second)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		SyncRing()
+	}
+}
+
+// RequireFreshRing rejects requests while the shared ring is stale
+func RequireFreshRing(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if RingStale() {
+			TriggerRingSync()
+			http.Error(w, "gatekeeper ring is stale", http.StatusServiceUnavailable)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// HandlerRing returns the shared ring state of the gatekeeper
+func HandlerRing(w http.ResponseWriter, r *http.Request) {
+	ringSync.Lock()
+	response := map[string]any{
+		"shared":   SharedRingEnabled(),
+		"version":  ringSync.record.Version,
+		"newest":   ringSync.newest,
+		"lastSync": ringSync.lastSync.UnixMilli(),
+		"record":   ringSync.record,
+	}
+	ringSync.Unlock()
+	response["stale"] = RingStale()
+	response["vaults"] = CurrentCluster().Addresses
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(response)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// InitSharedRing routes with the known vaults until the shared ring record is read
+//
+// The initial ring is stale, so requests are rejected until a sync succeeds.
+func InitSharedRing() {
+	if KeeperConfig.RingSyncInterval <= 0 {
+		KeeperConfig.RingSyncInterval = 5
+	}
+	if KeeperConfig.RingMaxStaleness <= 0 {
+		KeeperConfig.RingMaxStaleness = 3 * KeeperConfig.RingSyncInterval
+	}
+
+	clusterMu.Lock()
+	vaults, _ := knownVaults()
+	installCluster(BuildCluster(vaults))
+	clusterMu.Unlock()
+
+	SyncRing()
+}
@@ -0,0 +1,67 @@
+package gatekeeper
+
+import "testing"
+
+// testRingRecord returns a ring record of the given vaults
+func testRingRecord(version uint64, vaults []string, previous []string) RingRecord {
+	record := RingRecord{Version: version, Previous: previous}
+	for _, address := range vaults {
+		record.Vaults = append(record.Vaults, VaultEntry{Address: address})
+	}
+	return record
+}
+
+func TestCommittedRecord(t *testing.T) {
+	five := []string{"a", "b", "c", "d", "e"}
+	shrunk := testRingRecord(2, []string{"a", "b", "c"}, five)
+	grown := testRingRecord(2, []string{"a", "b", "c", "d", "e", "f"}, five)
+
+	tests := []struct {
+		name    string
+		records map[string]RingRecord
+		version uint64
+		vaults  int
+		found   bool
+	}{
+		{
+			name:    "majority of its vaults",
+			records: map[string]RingRecord{"a": testRingRecord(1, five, nil), "b": testRingRecord(1, five, nil), "c": testRingRecord(1, five, nil)},
+			version: 1,
+			found:   true,
+		},
+		{
+			name:    "minority of its vaults",
+			records: map[string]RingRecord{"a": testRingRecord(1, five, nil), "b": testRingRecord(1, five, nil)},
+		},
+		{
+			name:    "majority of its vaults but not of the ring it replaces",
+			records: map[string]RingRecord{"a": shrunk, "b": shrunk},
+		},
+		{
+			name:    "majority of both rings",
+			records: map[string]RingRecord{"a": shrunk, "b": shrunk, "c": shrunk},
+			version: 2,
+			found:   true,
+		},
+		{
+			// Both proposals hold a majority of their own vaults, only one holds a majority of the ring they replace
+			name:    "concurrent proposals for a version",
+			records: map[string]RingRecord{"a": shrunk, "b": shrunk, "c": grown, "d": grown, "e": grown, "f": grown},
+			version: 2,
+			vaults:  len(grown.Vaults),
+			found:   true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			record, found := committedRecord(test.records)
+			if found != test.found || record.Version != test.version {
+				t.Fatalf("committed version %d (%v), want %d (%v)", record.Version, found, test.version, test.found)
+			}
+			if test.vaults > 0 && len(record.Vaults) != test.vaults {
+				t.Errorf("committed the proposal with %d vaults, want %d", len(record.Vaults), test.vaults)
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package gatekeeper
 
 import (
+	"datavault/cmd/internal"
 	"log"
 	"net/http"
 	"os"
@@ -10,25 +11,68 @@ import (
 func Server() {
 	mux := http.NewServeMux()
 
-	mux.HandleFunc("GET /ping", HandlerPing) // Ping the vault server
+	mux.HandleFunc("GET /ping", HandlerPing)                                   // Ping the vault server
+	mux.HandleFunc("GET /stats", Authorize(PermissionAdmin, HandlerStats))     // Get capacity and ring weight of every vault
+	mux.HandleFunc("GET /hints", Authorize(PermissionAdmin, HandlerHints))     // Get pending hints of every vault
+	mux.HandleFunc("GET /members", Authorize(PermissionAdmin, HandlerMembers)) // Get gossip members
+	mux.HandleFunc("GET /ring", Authorize(PermissionAdmin, HandlerRing))       // Get the shared ring state
+	mux.HandleFunc("GET /usage", Authorize(PermissionAdmin, HandlerUsage))     // Get the usage of every namespace and group against their quotas
+	mux.HandleFunc("GET /audit", Authorize(PermissionAdmin, HandlerAudit))     // Get entries of the audit log of the gatekeeper or of a vault
 
-	mux.HandleFunc("GET /groups", HandlerGroups)        // Get all groups
-	mux.HandleFunc("GET /group", HandlerGroup)          // Get all records in a group
-	mux.HandleFunc("PUT /group", HandlerGroupUpload)    // Upload files into a group
-	mux.HandleFunc("DELETE /group", HandlerGroupDelete) // Delete a group
+	mux.HandleFunc("GET /webhooks", Authorize(PermissionAdmin, HandlerWebhooks))         // Get the registered webhooks
+	mux.HandleFunc("POST /webhooks", Authorize(PermissionAdmin, HandlerWebhookRegister)) // Register a webhook
+	mux.HandleFunc("DELETE /webhooks", Authorize(PermissionAdmin, HandlerWebhookDelete)) // Remove a webhook
 
-	mux.HandleFunc("GET /group/element", HandlerElementGet)      // Get an element
-	mux.HandleFunc("DELETE /group/element", HandleElementDelete) // Delete an element
+	mux.HandleFunc("POST /presign", Authenticate(HandlerPresign)) // Mint a pre-signed URL for a group or element
+	mux.HandleFunc("GET /events", Authenticate(HandlerEvents))    // Stream the change events of the groups the client can read
+
+	mux.HandleFunc("GET /groups", Authenticate(RequireFreshRing(HandlerGroups)))                       // Get all groups the client can read
+	mux.HandleFunc("GET /group", Authorize(PermissionRead, RequireFreshRing(HandlerGroup)))            // Get all records in a group
+	mux.HandleFunc("PUT /group", Authorize(PermissionWrite, RequireFreshRing(HandlerGroupUpload)))     // Upload files into a group
+	mux.HandleFunc("DELETE /group", Authorize(PermissionDelete, RequireFreshRing(HandlerGroupDelete))) // Delete a group
+
+	mux.HandleFunc("GET /group/archive", Authorize(PermissionRead, RequireFreshRing(HandlerGroupArchive)))  // Download the elements of a group as a ZIP or tar archive
+	mux.HandleFunc("POST /group/copy", Authorize(PermissionRead, RequireFreshRing(HandlerGroupCopy)))       // Copy the elements of a group into another group
+	mux.HandleFunc("POST /group/move", Authorize(PermissionDelete, RequireFreshRing(HandlerGroupMove)))     // Move the elements of a group into another group
+	mux.HandleFunc("POST /group/rename", Authorize(PermissionDelete, RequireFreshRing(HandlerGroupRename))) // Rename a group
+
+	mux.HandleFunc("GET /group/meta", Authorize(PermissionRead, RequireFreshRing(HandlerGroupMeta)))     // Get the metadata of a group
+	mux.HandleFunc("PUT /group/meta", Authorize(PermissionWrite, RequireFreshRing(HandlerGroupMetaPut))) // Change the description, owner and labels of a group
+
+	mux.HandleFunc("POST /group/element/copy", Authorize(PermissionRead, RequireFreshRing(HandlerElementCopy)))   // Copy an element into another group
+	mux.HandleFunc("POST /group/element/move", Authorize(PermissionDelete, RequireFreshRing(HandlerElementMove))) // Move an element into another group
+
+	mux.HandleFunc("GET /group/element", Authorize(PermissionRead, RequireFreshRing(HandlerElementGet)))        // Get an element
+	mux.HandleFunc("DELETE /group/element", Authorize(PermissionDelete, RequireFreshRing(HandleElementDelete))) // Delete an element
+
+	mux.HandleFunc("GET /group/element/versions", Authorize(PermissionRead, RequireFreshRing(HandlerElementVersions))) // Get the versions of a key
+	mux.HandleFunc("POST /group/element/restore", Authorize(PermissionWrite, RequireFreshRing(HandlerElementRestore))) // Restore a version of a key
+
+	mux.HandleFunc("GET /group/retention", Authorize(PermissionRead, RequireFreshRing(HandlerGroupRetention)))                   // Get the retention of a group
+	mux.HandleFunc("PUT /group/retention", Authorize(PermissionRetention, RequireFreshRing(HandlerGroupRetentionPut)))           // Change the retention or legal hold of a group
+	mux.HandleFunc("PUT /group/element/retention", Authorize(PermissionRetention, RequireFreshRing(HandlerElementRetentionPut))) // Change the retention or legal hold of an element
+
+	mux.HandleFunc("GET /trash", Authenticate(HandlerTrash))                               // Get the deleted groups and elements the client can read
+	mux.HandleFunc("POST /trash/restore", Authorize(PermissionWrite, HandlerTrashRestore)) // Restore a trash item into its group
+	mux.HandleFunc("DELETE /trash", Authorize(PermissionDelete, HandlerTrashPurge))        // Delete a trash item for good
 
 	// setup server
 	server := &http.Server{
 		Addr:     ":" + KeeperConfig.Port,
-		Handler:  mux,
+		Handler:  ResolveNamespace(DropGatekeeperParams(internal.AuditRequests(auditLog, mux))),
 		ErrorLog: log.New(os.Stderr, "http: ", log.LstdFlags),
 	}
 
 	//Start the vault server
 	log.Println("Starting vault server...")
 	log.Println("Listening on port", KeeperConfig.Port)
+	if KeeperConfig.TLS != nil {
+		tlsConfig, err := KeeperConfig.TLS.ServerConfig()
+		if err != nil {
+			log.Fatalf("Error configuring TLS: %v\n", err)
+		}
+		server.TLSConfig = tlsConfig
+		log.Fatal(server.ListenAndServeTLS("", ""))
+	}
 	log.Fatal(server.ListenAndServe())
 }
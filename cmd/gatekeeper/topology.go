@@ -0,0 +1,113 @@
+package gatekeeper
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// VaultEntry is a vault in the gatekeeper configuration with its topology labels
+//
+// An entry is either a plain "host:port" string or an object with an address
+// and optional zone, rack and host labels.
+type VaultEntry struct {
+	Address string `json:"address"` // Address of the vault (host:port)
+	Zone    string `json:"zone"`    // Availability zone of the vault
+	Rack    string `json:"rack"`    // Rack of the vault, unique within its zone
+	Host    string `json:"host"`    // Physical host of the vault
+}
+
+// UnmarshalJSON accepts a vault entry as an address string or as an object
+func (v *VaultEntry) UnmarshalJSON(data []byte) error {
+	var address string
+	if err := json.Unmarshal(data, &address); err == nil {
+		*v = VaultEntry{Address: address}
+		return nil
+	}
+
+	type entry VaultEntry // avoid recursion into UnmarshalJSON
+	var e entry
+	if err := json.Unmarshal(data, &e); err != nil {
+		return err
+	}
+	if e.Address == "" {
+		return fmt.Errorf("vault entry without address: %s", string(data))
+	}
+
+	*v = VaultEntry(e)
+	return nil
+}
+
+// failureDomains returns the zone, rack and host domains of a vault
+//
+// Missing labels make the vault its own domain at that level.
+func (v VaultEntry) failureDomains() [3]string {
+	zone := v.Zone
+	if zone == "" {
+		zone = v.Address
+	}
+	rack := v.Rack
+	if rack == "" {
+		rack = v.Address
+	}
+	host := v.Host
+	if host == "" {
+		host = v.Address
+	}
+
+	return [3]string{zone, zone + "/" + rack, host}
+}
+
+// SelectReplicas picks n vaults from candidates in ring order, spreading them across failure domains
+//
+// Vaults in a new zone are preferred, then in a new rack, then on a new host.
+// When there are fewer domains than replicas the remaining replicas are taken
+// in ring order.
+func SelectReplicas(candidates []string, n int) []string {
+	return ExtendReplicas(nil, candidates, n)
+}
+
+// ExtendReplicas completes the selected vaults up to n with candidates, spreading them across failure domains
+func ExtendReplicas(selected, candidates []string, n int) []string {
+	topology := CurrentCluster().Topology
+	selected = append(make([]string, 0, n), selected...)
+	picked := make(map[string]bool)
+	used := [3]map[string]bool{{}, {}, {}}
+	for _, address := range selected {
+		picked[address] = true
+		for level, domain := range topology[address].failureDomains() {
+			used[level][domain] = true
+		}
+	}
+
+	// Each pass relaxes the outermost failure domain constraint
+	for pass := 0; pass <= len(used) && len(selected) < n; pass++ {
+		for _, address := range candidates {
+			if len(selected) == n {
+				break
+			}
+			if picked[address] {
+				continue
+			}
+
+			domains := topology[address].failureDomains()
+			distinct := true
+			for level := pass; level < len(used); level++ {
+				if used[level][domains[level]] {
+					distinct = false
+					break
+				}
+			}
+			if !distinct {
+				continue
+			}
+
+			selected = append(selected, address)
+			picked[address] = true
+			for level, domain := range domains {
+				used[level][domain] = true
+			}
+		}
+	}
+
+	return selected
+}
@@ -0,0 +1,142 @@
+package gatekeeper
+
+import (
+	"datavault/cmd/internal"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// withTrashId names the trash item a delete creates, so that every replica moves the deleted data under the same id
+//
+// Only admins may skip the trash with a purge, the deletes of other clients stay recoverable.
+func withTrashId(r *http.Request) {
+	query := r.URL.Query()
+	query.Set(internal.TrashParam, strings.ReplaceAll(uuid.New().String(), "-", ""))
+	if query.Has(internal.PurgeParam) && !CanAccess(r, PermissionAdmin, RequestNamespace(r), query.Get("groupId")) {
+		query.Del(internal.PurgeParam)
+	}
+	r.URL.RawQuery = query.Encode()
+}
+
+// ClusterTrash gathers the trash items of a namespace, of one group when groupId is not empty, from every vault
+//
+// Replicas of an item are listed once. Items are sorted newest first and the
+// vaults that did not answer are returned.
+func ClusterTrash(namespace, groupId string) ([]internal.TrashItem, []string) {
+	query := url.Values{internal.NamespaceParam: {namespace}}
+	if groupId != "" {
+		query.Set("groupId", groupId)
+	}
+	results := FanOutRequest(http.MethodGet, "/trash", query, CurrentCluster().Addresses)
+
+	seen := make(map[string]bool)
+	items := make([]internal.TrashItem, 0)
+	unavailable := make([]string, 0)
+	for _, result := range results {
+		var vaultItems []internal.TrashItem
+		if !result.OK() || json.Unmarshal(result.Body, &vaultItems) != nil {
+			unavailable = append(unavailable, result.Address)
+			continue
+		}
+		for _, item := range vaultItems {
+			if !seen[item.Id] {
+				seen[item.Id] = true
+				items = append(items, item)
+			}
+		}
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].DeletedAt > items[j].DeletedAt
+	})
+	return items, unavailable
+}
+
+// TrashReport is the trash of a namespace
+type TrashReport struct {
+	Items       []internal.TrashItem `json:"items"`                 // Trash items the client can read, newest first
+	Unavailable []string             `json:"unavailable,omitempty"` // Vaults that did not list their trash
+}
+
+// HandlerTrash returns the trash items of a namespace the client can read, of one group when groupId is set
+func HandlerTrash(w http.ResponseWriter, r *http.Request) {
+	namespace := RequestNamespace(r)
+	items, unavailable := ClusterTrash(namespace, r.URL.Query().Get("groupId"))
+
+	report := TrashReport{Items: make([]internal.TrashItem, 0, len(items))}
+	if len(unavailable) > 0 {
+		report.Unavailable = unavailable
+	}
+	for _, item := range items {
+		if CanAccess(r, PermissionRead, namespace, item.GroupId) {
+			report.Items = append(report.Items, item)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(report)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// trashItemUsage returns the usage a restored trash item adds back
+func trashItemUsage(namespace, groupId, trashId string) (internal.Usage, bool) {
+	items, _ := ClusterTrash(namespace, groupId)
+	for _, item := range items {
+		if item.Id == trashId {
+			return internal.Usage{Bytes: item.Size, Files: int64(len(item.Elements))}, true
+		}
+	}
+	return internal.Usage{}, false
+}
+
+// HandlerTrashRestore restores a trash item into its group on every vault holding it
+//
+// Trash items stay on the vaults that held the group when it was deleted, so
+// every vault of the cluster is asked.
+func HandlerTrashRestore(w http.ResponseWriter, r *http.Request) {
+	namespace := RequestNamespace(r)
+	groupId := r.URL.Query().Get("groupId")
+	trashId := r.URL.Query().Get(internal.TrashParam)
+	if trashId == "" {
+		http.Error(w, "trashId is required", http.StatusBadRequest)
+		return
+	}
+
+	if QuotaApplies(namespace, groupId) {
+		restored, ok := trashItemUsage(namespace, groupId, trashId)
+		if !ok {
+			http.Error(w, "trash item not found", http.StatusNotFound)
+			return
+		}
+		release, err := ReserveQuota(namespace, groupId, restored)
+		if err != nil {
+			http.Error(w, err.Error(), QuotaStatus(err))
+			return
+		}
+		defer release()
+	}
+
+	results := FanOutRequest(http.MethodPost, "/trash/restore", r.URL.Query(), CurrentCluster().Addresses)
+	AcknowledgeNotFound(results)
+	WriteReplicaResults(w, results, CurrentCluster().WriteQuorum())
+}
+
+// HandlerTrashPurge deletes a trash item for good on every vault holding it
+func HandlerTrashPurge(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get(internal.TrashParam) == "" {
+		http.Error(w, "trashId is required", http.StatusBadRequest)
+		return
+	}
+
+	results := FanOutRequest(http.MethodDelete, "/trash", r.URL.Query(), CurrentCluster().Addresses)
+	AcknowledgeNotFound(results)
+	WriteReplicaResults(w, results, CurrentCluster().WriteQuorum())
+}
@@ -0,0 +1,43 @@
+package gatekeeper
+
+import (
+	"context"
+	"datavault/cmd/internal"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWithTrashId(t *testing.T) {
+	writer := &APIKey{Name: "writer", Key: "k-writer", Rules: []AccessRule{{Groups: "*", Permissions: []string{PermissionRead, PermissionWrite, PermissionDelete}}}}
+	admin := &APIKey{Name: "admin", Key: "k-admin", Rules: []AccessRule{{Groups: "*", Permissions: []string{PermissionDelete, PermissionAdmin}}}}
+	KeeperConfig.APIKeys = []APIKey{*writer, *admin}
+	t.Cleanup(func() { KeeperConfig.APIKeys = nil })
+
+	tests := []struct {
+		name  string
+		key   *APIKey
+		query string
+		purge bool
+	}{
+		{name: "delete", key: writer, query: "groupId=g"},
+		{name: "purge without admin", key: writer, query: "groupId=g&purge=true"},
+		{name: "purge by an admin", key: admin, query: "groupId=g&purge=true", purge: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodDelete, "/group?"+test.query, nil)
+			r = r.WithContext(context.WithValue(r.Context(), apiKeyContextKey{}, test.key))
+			withTrashId(r)
+
+			query := r.URL.Query()
+			if query.Get(internal.TrashParam) == "" {
+				t.Errorf("no trash id in %s", r.URL.RawQuery)
+			}
+			if query.Has(internal.PurgeParam) != test.purge {
+				t.Errorf("purge %v, want %v", query.Has(internal.PurgeParam), test.purge)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package gatekeeper
+
+import (
+	"datavault/cmd/internal"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// HandlerElementVersions returns the versions of a key, newest first, merged from a read quorum of the replicas
+func HandlerElementVersions(w http.ResponseWriter, r *http.Request) {
+	namespace := RequestNamespace(r)
+	groupId := r.URL.Query().Get("groupId")
+	if internal.ValidateKey(r.URL.Query().Get(internal.KeyParam)) != nil {
+		http.Error(w, internal.ErrInvalidKey.Error(), http.StatusBadRequest)
+		return
+	}
+
+	versions, ok := QuorumListing(w, r, namespace, groupId)
+	if !ok {
+		return
+	}
+	internal.SortVersions(versions)
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(versions)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// versionUsage returns the usage a restored version adds, read from the first replica answering
+func versionUsage(addresses []string, query url.Values) (internal.Usage, error) {
+	for _, address := range addresses {
+		resp, err := vaultClient.Get(address, "/group/element/versions", query)
+		if err != nil {
+			continue
+		}
+		var versions []internal.Record
+		err = json.NewDecoder(resp.Body).Decode(&versions)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || err != nil {
+			continue
+		}
+
+		for _, version := range versions {
+			if version.Id == query.Get(internal.VersionParam) {
+				size, _ := strconv.ParseInt(version.Attributes["fileSize"], 10, 64)
+				return internal.Usage{Bytes: size, Files: 1}, nil
+			}
+		}
+		return internal.Usage{}, errors.New("version not found")
+	}
+
+	return internal.Usage{}, errors.New("no replica answered")
+}
+
+// HandlerElementRestore restores a version of a key as its newest version on every replica
+//
+// The restored version gets a new element id and received time, shared by the
+// replicas. It is acknowledged once the write quorum stored it.
+func HandlerElementRestore(w http.ResponseWriter, r *http.Request) {
+	namespace := RequestNamespace(r)
+	query := r.URL.Query()
+	groupId := query.Get("groupId")
+	key := query.Get(internal.KeyParam)
+	if internal.ValidateKey(key) != nil {
+		http.Error(w, internal.ErrInvalidKey.Error(), http.StatusBadRequest)
+		return
+	}
+	if query.Get(internal.VersionParam) == "" {
+		http.Error(w, "versionId is required", http.StatusBadRequest)
+		return
+	}
+
+	addresses := LocateGroup(namespace, groupId)
+	if len(addresses) == 0 {
+		http.Error(w, "group cannot be assigned to a vault", http.StatusBadRequest)
+		return
+	}
+
+	vaultQuery := url.Values{
+		internal.NamespaceParam: {namespace},
+		"groupId":               {groupId},
+		internal.KeyParam:       {key},
+		internal.VersionParam:   {query.Get(internal.VersionParam)},
+	}
+
+	if QuotaApplies(namespace, groupId) {
+		upload, err := versionUsage(addresses, vaultQuery)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("reading version: %v", err), http.StatusNotFound)
+			return
+		}
+		release, err := ReserveQuota(namespace, groupId, upload)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		defer release()
+	}
+
+	vaultQuery.Set("elementId", strings.ReplaceAll(uuid.New().String(), "-", ""))
+	internal.AuditElements(r, vaultQuery.Get("elementId"))
+	vaultQuery.Set("receivedTime", strconv.FormatInt(time.Now().UnixMilli(), 10))
+	vaultQuery.Set(internal.RollbackParam, NewRollbackToken())
+	results := make([]ReplicaResult, len(addresses))
+	forEachVault(addresses, func(i int, address string) {
+		results[i] = sendToVault(http.MethodPost, address, "/group/element/restore", vaultQuery, "", nil)
+	})
+	if Acknowledged(results) < CurrentCluster().WriteQuorum() {
+		RollbackUpload(namespace, groupId, vaultQuery.Get(internal.RollbackParam), nil, results)
+		WriteReplicaFailure(w, "write quorum not reached", results)
+		return
+	}
+
+	WriteReplicaResults(w, results, CurrentCluster().WriteQuorum())
+}
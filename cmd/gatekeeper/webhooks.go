@@ -0,0 +1,356 @@
+package gatekeeper
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"datavault/cmd/internal"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// webhookQueueSize is the number of events waiting for delivery to a webhook before new ones are dropped
+const webhookQueueSize = 1000
+
+// webhookTimeout is the deadline of a delivery attempt
+const webhookTimeout = 10 * time.Second
+
+// ErrInvalidWebhook is returned when a webhook registration is malformed
+var ErrInvalidWebhook = errors.New("invalid webhook")
+
+// Webhook is an endpoint receiving the change events of a set of groups
+type Webhook struct {
+	Id         string   `json:"id"`               // Id assigned at registration
+	URL        string   `json:"url"`              // http or https endpoint the events are posted to
+	Secret     string   `json:"secret,omitempty"` // Key of the HMAC-SHA256 signature of the deliveries, empty leaves them unsigned
+	Namespace  string   `json:"namespace"`        // Namespace or pattern, defaults to the default namespace
+	Groups     string   `json:"groups"`           // Group id or pattern, defaults to every group
+	Events     []string `json:"events,omitempty"` // Types of the delivered events, empty delivers every type
+	MaxRetries int      `json:"max_retries"`      // Retries of a failed delivery, doubling the delay from one second, defaults to 5
+	CreatedAt  int64    `json:"createdAt"`        // Registration time in unix milliseconds
+}
+
+// Matches reports whether an event is delivered to the webhook
+func (h Webhook) Matches(event internal.Event) bool {
+	if matched, _ := path.Match(h.Namespace, event.Namespace); !matched {
+		return false
+	}
+	if matched, _ := path.Match(h.Groups, event.GroupId); !matched {
+		return false
+	}
+	return len(h.Events) == 0 || slices.Contains(h.Events, event.Type)
+}
+
+// validate fills the defaults of a webhook registration and checks it
+func (h *Webhook) validate() error {
+	target, err := url.Parse(h.URL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return fmt.Errorf("%w: url must be an http or https URL", ErrInvalidWebhook)
+	}
+	if h.Namespace == "" {
+		h.Namespace = internal.DefaultNamespace
+	}
+	if h.Groups == "" {
+		h.Groups = "*"
+	}
+	for _, pattern := range []string{h.Namespace, h.Groups} {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("%w: malformed pattern %s", ErrInvalidWebhook, pattern)
+		}
+	}
+	for _, eventType := range h.Events {
+		if eventType != internal.EventElementCreated && eventType != internal.EventElementDeleted && eventType != internal.EventGroupDeleted {
+			return fmt.Errorf("%w: unknown event type %s", ErrInvalidWebhook, eventType)
+		}
+	}
+	if h.MaxRetries < 0 {
+		return fmt.Errorf("%w: max_retries cannot be negative", ErrInvalidWebhook)
+	}
+	if h.MaxRetries == 0 {
+		h.MaxRetries = 5
+	}
+	return nil
+}
+
+// webhookWorker delivers the events queued for a webhook one at a time
+type webhookWorker struct {
+	hook  Webhook
+	queue chan internal.Event
+	stop  chan struct{}
+}
+
+var (
+	webhooksMu    sync.Mutex
+	webhooks      = make(map[string]*webhookWorker)       // Registered webhooks by id
+	webhookClient = &http.Client{Timeout: webhookTimeout} // Client of the deliveries, separate from the vault client
+)
+
+// startWebhook starts the delivery worker of a webhook, webhooksMu must be held
+func startWebhook(hook Webhook) {
+	worker := &webhookWorker{
+		hook:  hook,
+		queue: make(chan internal.Event, webhookQueueSize),
+		stop:  make(chan struct{}),
+	}
+	webhooks[hook.Id] = worker
+	go worker.run()
+}
+
+// run delivers the queued events until the webhook is removed
+func (w *webhookWorker) run() {
+	for {
+		select {
+		case <-w.stop:
+			return
+		case event := <-w.queue:
+			w.deliver(event)
+		}
+	}
+}
+
+// deliver posts an event to the webhook, retrying with exponential backoff until it is acknowledged with a 2xx status
+func (w *webhookWorker) deliver(event internal.Event) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Error encoding event %s for webhook %s: %v\n", event.Id, w.hook.Id, err)
+		return
+	}
+
+	delay := time.Second
+	for attempt := 0; ; attempt++ {
+		err = w.post(event, body)
+		if err == nil {
+			return
+		}
+		if attempt >= w.hook.MaxRetries {
+			log.Printf("Dropping event %s for webhook %s after %d attempts: %v\n", event.Id, w.hook.Id, attempt+1, err)
+			return
+		}
+
+		select {
+		case <-w.stop:
+			return
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// post sends one delivery attempt
+//
+// The signature covers the timestamp and the body, receivers recompute
+// hex(HMAC-SHA256(secret, timestamp + "." + body)) to authenticate a delivery
+// and reject old timestamps to prevent replays.
+func (w *webhookWorker) post(event internal.Event, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, w.hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Datavault-Event", event.Type)
+	req.Header.Set("X-Datavault-Delivery", event.Id)
+	req.Header.Set("X-Datavault-Timestamp", timestamp)
+	if w.hook.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(w.hook.Secret))
+		mac.Write([]byte(timestamp + "."))
+		mac.Write(body)
+		req.Header.Set("X-Datavault-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return nil
+}
+
+// DispatchWebhooks queues an event for the webhooks it matches, dropping it for webhooks whose queue is full
+func DispatchWebhooks(event internal.Event) {
+	webhooksMu.Lock()
+	defer webhooksMu.Unlock()
+
+	for _, worker := range webhooks {
+		if !worker.hook.Matches(event) {
+			continue
+		}
+		select {
+		case worker.queue <- event:
+		default:
+			log.Printf("Delivery queue of webhook %s is full, dropping event %s\n", worker.hook.Id, event.Id)
+		}
+	}
+}
+
+// listWebhooks returns the registered webhooks, oldest first, webhooksMu must be held
+func listWebhooks() []Webhook {
+	hooks := make([]Webhook, 0, len(webhooks))
+	for _, worker := range webhooks {
+		hooks = append(hooks, worker.hook)
+	}
+	sort.Slice(hooks, func(i, j int) bool {
+		return hooks[i].CreatedAt < hooks[j].CreatedAt
+	})
+	return hooks
+}
+
+// saveWebhooks writes the registered webhooks to the webhooks file, webhooksMu must be held
+func saveWebhooks() error {
+	file := KeeperConfig.Events.WebhooksFile
+	if file == "" {
+		return nil
+	}
+	content, err := json.MarshalIndent(listWebhooks(), "", "  ")
+	if err != nil {
+		return err
+	}
+	return internal.ReplaceFile(filepath.Dir(file), "", filepath.Base(file), content)
+}
+
+// LoadWebhooks registers the webhooks stored in the webhooks file and starts their workers
+func LoadWebhooks() error {
+	file := KeeperConfig.Events.WebhooksFile
+	if file == "" {
+		return nil
+	}
+	content, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var hooks []Webhook
+	err = json.Unmarshal(content, &hooks)
+	if err != nil {
+		return err
+	}
+
+	webhooksMu.Lock()
+	defer webhooksMu.Unlock()
+	for _, hook := range hooks {
+		startWebhook(hook)
+	}
+	return nil
+}
+
+// redactWebhooks hides the secrets of webhooks
+func redactWebhooks(hooks []Webhook) []Webhook {
+	for i := range hooks {
+		if hooks[i].Secret != "" {
+			hooks[i].Secret = "********"
+		}
+	}
+	return hooks
+}
+
+// HandlerWebhooks returns the registered webhooks, without their secrets
+func HandlerWebhooks(w http.ResponseWriter, r *http.Request) {
+	if !EventsEnabled() {
+		http.Error(w, "events are disabled", http.StatusNotFound)
+		return
+	}
+
+	webhooksMu.Lock()
+	hooks := listWebhooks()
+	webhooksMu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(redactWebhooks(hooks))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// HandlerWebhookRegister registers a webhook described by the JSON body of the request
+func HandlerWebhookRegister(w http.ResponseWriter, r *http.Request) {
+	if !EventsEnabled() {
+		http.Error(w, "events are disabled", http.StatusNotFound)
+		return
+	}
+
+	var hook Webhook
+	err := json.NewDecoder(r.Body).Decode(&hook)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = hook.validate()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	hook.Id = strings.ReplaceAll(uuid.New().String(), "-", "")
+	hook.CreatedAt = time.Now().UnixMilli()
+
+	webhooksMu.Lock()
+	startWebhook(hook)
+	err = saveWebhooks()
+	if err != nil {
+		close(webhooks[hook.Id].stop)
+		delete(webhooks, hook.Id)
+	}
+	webhooksMu.Unlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	err = json.NewEncoder(w).Encode(redactWebhooks([]Webhook{hook})[0])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// HandlerWebhookDelete removes a webhook, events still queued for it are not delivered
+func HandlerWebhookDelete(w http.ResponseWriter, r *http.Request) {
+	if !EventsEnabled() {
+		http.Error(w, "events are disabled", http.StatusNotFound)
+		return
+	}
+
+	id := r.URL.Query().Get("id")
+	webhooksMu.Lock()
+	defer webhooksMu.Unlock()
+
+	worker, ok := webhooks[id]
+	if !ok {
+		http.Error(w, "webhook not found", http.StatusNotFound)
+		return
+	}
+	delete(webhooks, id)
+	err := saveWebhooks()
+	if err != nil {
+		webhooks[id] = worker
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	close(worker.stop)
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,117 @@
+package gatekeeper
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+)
+
+// capacityWeightScale is the ring weight given to the largest vault in capacity mode
+const capacityWeightScale = 100
+
+var (
+	capacitiesMu sync.Mutex
+	capacities   = make(map[string]uint64) // Last capacity reported by each vault, used while it cannot be reached
+)
+
+// VaultStats is the capacity and usage report of a vault
+type VaultStats struct {
+	Address  string `json:"address"`  // Address of the vault (host:port)
+	Id       string `json:"id"`       // Vault identifier
+	Capacity uint64 `json:"capacity"` // Total size of the vault filesystem in bytes
+	Free     uint64 `json:"free"`     // Free bytes on the vault filesystem
+	Used     uint64 `json:"used"`     // Used bytes on the vault filesystem
+	Files    int    `json:"files"`    // Number of elements stored in the vault
+	Groups   int    `json:"groups"`   // Number of groups stored in the vault
+	ReadOnly bool   `json:"readOnly"` // Whether the vault rejects uploads
+	Weight   int    `json:"weight"`   // Weight of the vault in the hash ring
+}
+
+// GetVaultStats collects the stats of every reachable vault, keyed by address
+func GetVaultStats(addresses []string) map[string]VaultStats {
+	stats := make(map[string]VaultStats)
+
+	results := FanOutRequest(http.MethodGet, "/stats", url.Values{}, addresses)
+	for _, result := range results {
+		if !result.OK() { // Skip failed requests
+			continue
+		}
+
+		var vaultStats VaultStats
+		err := json.Unmarshal(result.Body, &vaultStats)
+		if err != nil {
+			log.Printf("Error decoding stats of vault %s: %v\n", result.Address, err)
+			continue
+		}
+		vaultStats.Address = result.Address
+		stats[vaultStats.Address] = vaultStats
+	}
+
+	return stats
+}
+
+// ComputeRingWeights returns the hash ring weight of every vault in the configuration
+//
+// In "static" mode (the default) weights are read from the configuration and
+// default to 1. In "capacity" mode weights are proportional to the capacity
+// reported by each vault. Vaults that cannot be reached keep the last capacity
+// they reported, and those that never reported get the median weight of the
+// others, so that a vault down for a moment keeps its share of the ring.
+func ComputeRingWeights(addresses []string) map[string]int {
+	weights := make(map[string]int)
+	for _, vault := range addresses {
+		weights[vault] = 1
+		if weight, ok := KeeperConfig.Weights[vault]; ok && weight > 0 {
+			weights[vault] = weight
+		}
+	}
+
+	if KeeperConfig.WeightMode != "capacity" {
+		return weights
+	}
+
+	stats := GetVaultStats(addresses)
+	known := make(map[string]uint64)
+	capacitiesMu.Lock()
+	for _, vault := range addresses {
+		if vaultStats, ok := stats[vault]; ok && vaultStats.Capacity > 0 {
+			capacities[vault] = vaultStats.Capacity
+		} else if _, ok := capacities[vault]; ok {
+			log.Printf("Vault %s did not report its capacity, using the last capacity it reported\n", vault)
+		}
+		if capacity, ok := capacities[vault]; ok {
+			known[vault] = capacity
+		}
+	}
+	capacitiesMu.Unlock()
+
+	var maxCapacity uint64
+	for _, capacity := range known {
+		maxCapacity = max(maxCapacity, capacity)
+	}
+	if maxCapacity == 0 {
+		log.Println("No vault reported its capacity, using static ring weights")
+		return weights
+	}
+
+	capacityWeights := make([]int, 0, len(known))
+	for vault, capacity := range known {
+		ratio := float64(capacity) / float64(maxCapacity)
+		weights[vault] = max(1, int(math.Round(ratio*capacityWeightScale)))
+		capacityWeights = append(capacityWeights, weights[vault])
+	}
+	sort.Ints(capacityWeights)
+	median := capacityWeights[len(capacityWeights)/2]
+	for _, vault := range addresses {
+		if _, ok := known[vault]; !ok {
+			log.Printf("Vault %s never reported its capacity, using the median ring weight %d\n", vault, median)
+			weights[vault] = median
+		}
+	}
+
+	return weights
+}
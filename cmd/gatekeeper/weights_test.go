@@ -0,0 +1,60 @@
+package gatekeeper
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// testStatsServer starts a vault reporting the given capacity on /stats
+func testStatsServer(t *testing.T, capacity uint64) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(VaultStats{Capacity: capacity})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestComputeRingWeightsSilentVaults(t *testing.T) {
+	var err error
+	vaultClient, err = NewVaultClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	KeeperConfig.WeightMode = "capacity"
+	t.Cleanup(func() {
+		KeeperConfig.WeightMode = ""
+		capacitiesMu.Lock()
+		clear(capacities)
+		capacitiesMu.Unlock()
+	})
+
+	small := testStatsServer(t, 100)
+	large := testStatsServer(t, 200)
+	larger := testStatsServer(t, 200)
+	silent := testStatsServer(t, 0)
+	silent.Close() // Never reachable
+	addresses := []string{
+		small.Listener.Addr().String(),
+		large.Listener.Addr().String(),
+		larger.Listener.Addr().String(),
+	}
+
+	weights := ComputeRingWeights(addresses)
+	if weights[addresses[0]] != 50 || weights[addresses[1]] != 100 {
+		t.Fatalf("weights %v, want 50 and 100", weights)
+	}
+
+	// A vault that stops answering keeps its weight, one that never answered gets the median weight
+	small.Close()
+	addresses = append(addresses, silent.Listener.Addr().String())
+	weights = ComputeRingWeights(addresses)
+	if weights[addresses[0]] != 50 {
+		t.Errorf("unreachable vault weight %d, want its last weight 50", weights[addresses[0]])
+	}
+	if weights[addresses[3]] != 100 {
+		t.Errorf("silent vault weight %d, want the median weight 100", weights[addresses[3]])
+	}
+}
@@ -0,0 +1,76 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Archive formats of group downloads
+const (
+	ArchiveZip = "zip" // ZIP archive, entries are deflated
+	ArchiveTar = "tar" // Uncompressed tar archive
+)
+
+// Query parameters of group downloads
+const (
+	ArchiveFormatParam   = "format"   // zip (default) or tar
+	ArchiveFilterParam   = "filter"   // attribute:value an element must match, repeatable
+	ArchiveVersionsParam = "versions" // "all" includes every version of the keys instead of the newest one
+)
+
+// ErrInvalidArchive is returned when the parameters of a group download are malformed
+var ErrInvalidArchive = errors.New("format must be zip or tar and filters attribute:value")
+
+// ArchiveContentType returns the media type of an archive format
+func ArchiveContentType(format string) string {
+	if format == ArchiveTar {
+		return "application/x-tar"
+	}
+	return "application/zip"
+}
+
+// ParseArchiveFilters returns the attributes selected by filter parameters
+func ParseArchiveFilters(filters []string) (map[string]string, error) {
+	query := make(map[string]string, len(filters))
+	for _, filter := range filters {
+		attribute, value, ok := strings.Cut(filter, ":")
+		if !ok || attribute == "" {
+			return nil, ErrInvalidArchive
+		}
+		query[attribute] = value
+	}
+	return query, nil
+}
+
+// ArchiveNames assigns unique entry names to files named by clients
+//
+// Names are relative paths checked by ArchiveEntryPath, so that entries cannot
+// escape the folder they are extracted to. Conflicting names get a " (n)"
+// suffix before their extension.
+type ArchiveNames struct {
+	used map[string]bool
+}
+
+// NewArchiveNames creates an empty set of entry names
+func NewArchiveNames() *ArchiveNames {
+	return &ArchiveNames{used: make(map[string]bool)}
+}
+
+// Name returns a unique entry name for a file name or path, fallback is used when the name is unsafe
+func (n *ArchiveNames) Name(fileName, fallback string) string {
+	name, err := ArchiveEntryPath(fileName)
+	if err != nil {
+		name = fallback
+	}
+
+	extension := path.Ext(name)
+	stem := strings.TrimSuffix(name, extension)
+	candidate := name
+	for i := 1; n.used[candidate]; i++ {
+		candidate = fmt.Sprintf("%s (%d)%s", stem, i, extension)
+	}
+	n.used[candidate] = true
+	return candidate
+}
@@ -0,0 +1,511 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// AuditConfig is the configuration of the audit log of a gatekeeper or a vault
+type AuditConfig struct {
+	Dir      string `json:"dir"`       // Folder of the audit log segments
+	MaxSize  int64  `json:"max_size"`  // Size in bytes a segment is rotated at, defaults to 10 MiB
+	MaxFiles int    `json:"max_files"` // Rotated segments kept besides the current one, 0 keeps every segment
+}
+
+// ErrAuditChainBroken is returned when the audit log was modified after being written
+var ErrAuditChainBroken = errors.New("audit chain broken")
+
+// Outcomes of audited requests
+const (
+	AuditSuccess = "success"
+	AuditFailure = "failure"
+)
+
+// AuditEntry is a mutating request recorded in the audit log
+//
+// Each entry holds the hash of the entry before it, and its own hash covers
+// every other field, so that editing, removing or reordering entries breaks
+// the chain.
+type AuditEntry struct {
+	Seq       int64    `json:"seq"`                 // Position of the entry in the log, starting at 1
+	Time      int64    `json:"time"`                // Time the request completed in unix milliseconds
+	Component string   `json:"component"`           // Gatekeeper or vault id that served the request
+	Principal string   `json:"principal"`           // API key name, "gatekeeper", "presigned" or "anonymous"
+	ClientIP  string   `json:"clientIp"`            // Address of the client
+	Method    string   `json:"method"`              // HTTP method
+	Action    string   `json:"action"`              // Path of the request
+	Query     string   `json:"query,omitempty"`     // Query of the request, without signatures
+	Namespace string   `json:"namespace,omitempty"` // Namespace of the group
+	GroupId   string   `json:"groupId,omitempty"`   // Group of the request
+	Elements  []string `json:"elements,omitempty"`  // Elements named by or created by the request
+	Status    int      `json:"status"`              // HTTP status of the response
+	Outcome   string   `json:"outcome"`             // success or failure
+	PrevHash  string   `json:"prevHash"`            // Hash of the previous entry, empty for the first entry
+	Hash      string   `json:"hash"`                // SHA-256 of the entry with an empty hash
+}
+
+// computeHash returns the hash of an entry, computed without its own hash
+func (e AuditEntry) computeHash() string {
+	e.Hash = ""
+	content, _ := json.Marshal(e)
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// AuditLog is an append-only, hash-chained log split into numbered segments
+type AuditLog struct {
+	config    AuditConfig
+	component string
+
+	mu       sync.Mutex
+	file     *os.File // Current segment
+	segment  int      // Number of the current segment
+	size     int64    // Size of the current segment in bytes
+	seq      int64    // Sequence number of the last entry
+	lastHash string   // Hash of the last entry
+}
+
+// auditSegmentName returns the file name of a segment
+func auditSegmentName(segment int) string {
+	return fmt.Sprintf("audit-%06d.log", segment)
+}
+
+// auditSegments returns the numbers of the segments of an audit log folder, oldest first
+func auditSegments(dir string) ([]int, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	segments := make([]int, 0, len(entries))
+	for _, entry := range entries {
+		var segment int
+		if _, err := fmt.Sscanf(entry.Name(), "audit-%06d.log", &segment); err == nil && entry.Name() == auditSegmentName(segment) {
+			segments = append(segments, segment)
+		}
+	}
+	sort.Ints(segments)
+	return segments, nil
+}
+
+// readAuditSegment calls fn with every entry of a segment, in order
+func readAuditSegment(path string, fn func(AuditEntry) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var entry AuditEntry
+		err := json.Unmarshal(scanner.Bytes(), &entry)
+		if err != nil {
+			return fmt.Errorf("%w: %s: unreadable entry: %v", ErrAuditChainBroken, filepath.Base(path), err)
+		}
+		err = fn(entry)
+		if err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// OpenAuditLog opens the audit log of a component, continuing the chain of its last segment
+//
+// A last line without its newline was cut by a crash while being written and is dropped.
+func OpenAuditLog(config AuditConfig, component string) (*AuditLog, error) {
+	if config.MaxSize <= 0 {
+		config.MaxSize = 10 * 1024 * 1024
+	}
+	err := os.MkdirAll(config.Dir, 0750)
+	if err != nil {
+		return nil, err
+	}
+
+	auditLog := &AuditLog{config: config, component: component, segment: 1}
+	segments, err := auditSegments(config.Dir)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(segments) - 1; i >= 0; i-- {
+		path := filepath.Join(config.Dir, auditSegmentName(segments[i]))
+		if i == len(segments)-1 {
+			auditLog.segment = segments[i]
+			err = dropPartialLine(path)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		// The chain continues from the last entry of the newest segment holding one
+		err = readAuditSegment(path, func(entry AuditEntry) error {
+			auditLog.seq = entry.Seq
+			auditLog.lastHash = entry.Hash
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		if auditLog.seq > 0 {
+			break
+		}
+	}
+
+	err = auditLog.openSegment()
+	if err != nil {
+		return nil, err
+	}
+	return auditLog, nil
+}
+
+// dropPartialLine truncates a segment after its last complete line
+func dropPartialLine(path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil || len(content) == 0 || content[len(content)-1] == '\n' {
+		return err
+	}
+	return os.Truncate(path, int64(bytes.LastIndexByte(content, '\n')+1))
+}
+
+// openSegment opens the current segment for appending
+func (l *AuditLog) openSegment() error {
+	file, err := os.OpenFile(filepath.Join(l.config.Dir, auditSegmentName(l.segment)), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0640)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	l.file = file
+	l.size = info.Size()
+	return nil
+}
+
+// rotate starts a new segment and removes the rotated segments beyond the configured count
+func (l *AuditLog) rotate() error {
+	err := l.file.Close()
+	if err != nil {
+		return err
+	}
+	l.segment++
+	err = l.openSegment()
+	if err != nil {
+		return err
+	}
+
+	if l.config.MaxFiles <= 0 {
+		return nil
+	}
+	segments, err := auditSegments(l.config.Dir)
+	if err != nil {
+		return err
+	}
+	for len(segments) > l.config.MaxFiles+1 {
+		err = os.Remove(filepath.Join(l.config.Dir, auditSegmentName(segments[0])))
+		if err != nil {
+			return err
+		}
+		segments = segments[1:]
+	}
+	return nil
+}
+
+// Append chains an entry to the log and writes it
+func (l *AuditLog) Append(entry AuditEntry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entry.Seq = l.seq + 1
+	entry.Component = l.component
+	entry.PrevHash = l.lastHash
+	entry.Hash = entry.computeHash()
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	if l.size > 0 && l.size+int64(len(line)) > l.config.MaxSize {
+		err = l.rotate()
+		if err != nil {
+			return err
+		}
+	}
+
+	// A single write keeps entries whole when the process dies while appending
+	n, err := l.file.Write(line)
+	if err != nil {
+		// A partly written entry would break the chain, it is cut off
+		l.file.Truncate(l.size)
+		return storageError(err)
+	}
+	l.size += int64(n)
+
+	l.seq = entry.Seq
+	l.lastHash = entry.Hash
+	return nil
+}
+
+// AuditQuery selects entries of the audit log
+type AuditQuery struct {
+	From      time.Time // Entries at or after this time, zero for no bound
+	To        time.Time // Entries before this time, zero for no bound
+	Namespace string    // Namespace of the entries, empty for every namespace
+	GroupId   string    // Group of the entries, empty for every group
+	Limit     int       // Largest number of entries returned, the oldest matching ones are returned first
+}
+
+// ParseAuditQuery reads an audit query from the from, to (RFC 3339), namespace, groupId and limit parameters
+//
+// The limit defaults to 1000 entries.
+func ParseAuditQuery(values url.Values) (AuditQuery, error) {
+	query := AuditQuery{
+		Namespace: values.Get(NamespaceParam),
+		GroupId:   values.Get("groupId"),
+		Limit:     1000,
+	}
+
+	var err error
+	if from := values.Get("from"); from != "" {
+		query.From, err = time.Parse(time.RFC3339, from)
+		if err != nil {
+			return AuditQuery{}, errors.New("from must be an RFC 3339 time")
+		}
+	}
+	if to := values.Get("to"); to != "" {
+		query.To, err = time.Parse(time.RFC3339, to)
+		if err != nil {
+			return AuditQuery{}, errors.New("to must be an RFC 3339 time")
+		}
+	}
+	if limit := values.Get("limit"); limit != "" {
+		query.Limit, err = strconv.Atoi(limit)
+		if err != nil || query.Limit <= 0 {
+			return AuditQuery{}, errors.New("limit must be a positive number")
+		}
+	}
+
+	return query, nil
+}
+
+// Matches reports whether an entry is selected by the query
+func (q AuditQuery) Matches(entry AuditEntry) bool {
+	if !q.From.IsZero() && entry.Time < q.From.UnixMilli() {
+		return false
+	}
+	if !q.To.IsZero() && entry.Time >= q.To.UnixMilli() {
+		return false
+	}
+	if q.Namespace != "" && entry.Namespace != q.Namespace {
+		return false
+	}
+	return q.GroupId == "" || entry.GroupId == q.GroupId
+}
+
+// errQueryFull stops reading segments once a query has its entries
+var errQueryFull = errors.New("query full")
+
+// Query returns the entries selected by a query, oldest first
+func (l *AuditLog) Query(query AuditQuery) ([]AuditEntry, error) {
+	// Appends wait for the query, so that no entry is read half written
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	segments, err := auditSegments(l.config.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]AuditEntry, 0)
+	for _, segment := range segments {
+		err = readAuditSegment(filepath.Join(l.config.Dir, auditSegmentName(segment)), func(entry AuditEntry) error {
+			if !query.Matches(entry) {
+				return nil
+			}
+			entries = append(entries, entry)
+			if query.Limit > 0 && len(entries) >= query.Limit {
+				return errQueryFull
+			}
+			return nil
+		})
+		if errors.Is(err, errQueryFull) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return entries, nil
+}
+
+// AuditVerification is the result of the verification of an audit log folder
+type AuditVerification struct {
+	Segments int   // Segments checked
+	Entries  int64 // Entries checked
+	FirstSeq int64 // Sequence number of the oldest entry kept, older segments were removed by rotation
+	LastSeq  int64 // Sequence number of the newest entry
+}
+
+// VerifyAuditLog checks the hash chain of every segment of an audit log folder
+//
+// The oldest kept entry anchors the chain, segments removed by rotation
+// cannot be checked.
+func VerifyAuditLog(dir string) (AuditVerification, error) {
+	var verification AuditVerification
+	segments, err := auditSegments(dir)
+	if err != nil {
+		return verification, err
+	}
+
+	lastHash := ""
+	for _, segment := range segments {
+		name := auditSegmentName(segment)
+		err = readAuditSegment(filepath.Join(dir, name), func(entry AuditEntry) error {
+			if entry.Hash != entry.computeHash() {
+				return fmt.Errorf("%w: %s: entry %d was modified", ErrAuditChainBroken, name, entry.Seq)
+			}
+			if verification.Entries == 0 {
+				verification.FirstSeq = entry.Seq
+			} else if entry.Seq != verification.LastSeq+1 || entry.PrevHash != lastHash {
+				return fmt.Errorf("%w: %s: entry %d does not follow entry %d", ErrAuditChainBroken, name, entry.Seq, verification.LastSeq)
+			}
+
+			verification.Entries++
+			verification.LastSeq = entry.Seq
+			lastHash = entry.Hash
+			return nil
+		})
+		if err != nil {
+			return verification, err
+		}
+		verification.Segments++
+	}
+
+	return verification, nil
+}
+
+// auditContextKey is the context key of the entry of an audited request
+type auditContextKey struct{}
+
+// auditRecorder captures the status of an audited response
+type auditRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+// WriteHeader records the status before writing it
+func (a *auditRecorder) WriteHeader(status int) {
+	if a.status == 0 {
+		a.status = status
+	}
+	a.ResponseWriter.WriteHeader(status)
+}
+
+// Write records an implicit 200 status
+func (a *auditRecorder) Write(b []byte) (int, error) {
+	if a.status == 0 {
+		a.status = http.StatusOK
+	}
+	return a.ResponseWriter.Write(b)
+}
+
+// Flush lets proxied responses stream through the recorder
+func (a *auditRecorder) Flush() {
+	if flusher, ok := a.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap exposes the response writer to http.ResponseController
+func (a *auditRecorder) Unwrap() http.ResponseWriter {
+	return a.ResponseWriter
+}
+
+// AuditRequests records every mutating request served by next in the audit log, a nil log records nothing
+//
+// Handlers add the principal and the elements they create with AuditPrincipal
+// and AuditElements.
+func AuditRequests(auditLog *AuditLog, next http.Handler) http.Handler {
+	if auditLog == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		query := r.URL.Query()
+		clientIP, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			clientIP = r.RemoteAddr
+		}
+		entry := &AuditEntry{
+			Principal: "anonymous",
+			ClientIP:  clientIP,
+			Method:    r.Method,
+			Action:    r.URL.Path,
+			Namespace: QueryNamespace(query),
+			GroupId:   query.Get("groupId"),
+		}
+		if elementId := query.Get("elementId"); elementId != "" {
+			entry.Elements = []string{elementId}
+		}
+		query.Del(SignatureParam)
+		entry.Query = query.Encode()
+
+		recorder := &auditRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, r.WithContext(context.WithValue(r.Context(), auditContextKey{}, entry)))
+
+		entry.Time = time.Now().UnixMilli()
+		entry.Status = recorder.status
+		if entry.Status == 0 {
+			entry.Status = http.StatusOK
+		}
+		entry.Outcome = AuditSuccess
+		if entry.Status >= http.StatusBadRequest {
+			entry.Outcome = AuditFailure
+		}
+		err = auditLog.Append(*entry)
+		if err != nil {
+			log.Printf("Error writing audit entry for %s %s: %v\n", r.Method, r.URL.Path, err)
+		}
+	})
+}
+
+// AuditPrincipal names the principal of an audited request
+func AuditPrincipal(r *http.Request, principal string) {
+	if entry, ok := r.Context().Value(auditContextKey{}).(*AuditEntry); ok {
+		entry.Principal = principal
+	}
+}
+
+// AuditElements adds the elements created by an audited request
+func AuditElements(r *http.Request, elementIds ...string) {
+	if entry, ok := r.Context().Value(auditContextKey{}).(*AuditEntry); ok {
+		entry.Elements = append(entry.Elements, elementIds...)
+	}
+}
@@ -0,0 +1,27 @@
+package internal
+
+import "errors"
+
+// Query parameters naming the destination of a copy or a move
+const (
+	TargetNamespaceParam = "targetNamespace" // Namespace of the destination group, defaults to the namespace of the source
+	TargetGroupParam     = "targetGroupId"   // Destination group
+)
+
+// ErrChecksumMismatch is returned when a copied file does not match the checksum of its source
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// CopyElement is an element a vault copies into a group
+type CopyElement struct {
+	SourceId string `json:"sourceId"`         // Id of the copied element
+	Source   string `json:"source,omitempty"` // Address of the vault the element is fetched from, empty when the vault holds it
+	Meta     Meta   `json:"meta"`             // Metadata of the copy, with the id and received time assigned by the gatekeeper
+}
+
+// CopyRequest is the body of a copy of elements sent to the vaults of the destination group
+type CopyRequest struct {
+	SourceNamespace string        `json:"sourceNamespace"` // Namespace of the source group
+	SourceGroupId   string        `json:"sourceGroupId"`   // Source group
+	Elements        []CopyElement `json:"elements"`        // Elements to copy
+	Group           *GroupMeta    `json:"group,omitempty"` // Metadata written to the destination group, when a rename relocates it
+}
@@ -0,0 +1,8 @@
+package internal
+
+// DiskUsage is the space usage of the filesystem holding a path
+type DiskUsage struct {
+	Capacity uint64 `json:"capacity"` // Total size of the filesystem in bytes
+	Free     uint64 `json:"free"`     // Bytes available to the vault process
+	Used     uint64 `json:"used"`     // Bytes in use on the filesystem
+}
@@ -0,0 +1,23 @@
+//go:build !windows
+
+package internal
+
+import "syscall"
+
+// GetDiskUsage returns the space usage of the filesystem holding path
+func GetDiskUsage(path string) (DiskUsage, error) {
+	var stat syscall.Statfs_t
+	err := syscall.Statfs(path, &stat)
+	if err != nil {
+		return DiskUsage{}, err
+	}
+
+	blockSize := uint64(stat.Bsize)
+	usage := DiskUsage{
+		Capacity: stat.Blocks * blockSize,
+		Free:     stat.Bavail * blockSize,
+	}
+	usage.Used = usage.Capacity - stat.Bfree*blockSize
+
+	return usage, nil
+}
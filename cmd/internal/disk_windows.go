@@ -0,0 +1,35 @@
+//go:build windows
+
+package internal
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+var procGetDiskFreeSpaceEx = syscall.NewLazyDLL("kernel32.dll").NewProc("GetDiskFreeSpaceExW")
+
+// GetDiskUsage returns the space usage of the volume holding path
+func GetDiskUsage(path string) (DiskUsage, error) {
+	pathPtr, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return DiskUsage{}, err
+	}
+
+	var free, total, totalFree uint64
+	ret, _, err := procGetDiskFreeSpaceEx.Call(
+		uintptr(unsafe.Pointer(pathPtr)),
+		uintptr(unsafe.Pointer(&free)),
+		uintptr(unsafe.Pointer(&total)),
+		uintptr(unsafe.Pointer(&totalFree)),
+	)
+	if ret == 0 {
+		return DiskUsage{}, err
+	}
+
+	return DiskUsage{
+		Capacity: total,
+		Free:     free,
+		Used:     total - totalFree,
+	}, nil
+}
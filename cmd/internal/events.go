@@ -0,0 +1,138 @@
+package internal
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Types of change events
+const (
+	EventElementCreated = "element.created" // An element was stored or restored
+	EventElementDeleted = "element.deleted" // An element was deleted, expired, pruned or trashed
+	EventGroupDeleted   = "group.deleted"   // A group was deleted or trashed
+)
+
+// ErrInvalidCursor is returned when an event cursor is malformed
+var ErrInvalidCursor = errors.New("invalid event cursor")
+
+// Event is a change of the elements of a group
+type Event struct {
+	Id         string            `json:"id"`                   // Cursor of the event, resuming after it
+	Type       string            `json:"type"`                 // element.created, element.deleted or group.deleted
+	Time       int64             `json:"time"`                 // Time of the change in unix milliseconds
+	Namespace  string            `json:"namespace"`            // Namespace of the group
+	GroupId    string            `json:"groupId"`              // Group of the change
+	ElementId  string            `json:"elementId,omitempty"`  // Element of the change, empty for group events
+	Attributes map[string]string `json:"attributes,omitempty"` // Attributes of a created element
+}
+
+// EventBatch is the events following a cursor
+type EventBatch struct {
+	Cursor string  `json:"cursor"` // Cursor of the last event, to resume after the batch
+	Reset  bool    `json:"reset"`  // The cursor was not found, events may have been missed
+	Events []Event `json:"events"` // Events after the cursor, oldest first
+}
+
+// EventBuffer keeps the latest events of a process in memory
+//
+// Cursors are made of an epoch, drawn when the process starts, and a sequence
+// number. A cursor of another epoch or older than the buffer cannot be resumed.
+type EventBuffer struct {
+	epoch string
+
+	mu      sync.Mutex
+	events  []Event       // Ring of the latest events, the event of sequence number n is at n-1 modulo its capacity
+	seq     int64         // Sequence number of the last event
+	changed chan struct{} // Closed and replaced when an event is appended
+}
+
+// NewEventBuffer creates a buffer keeping the latest size events, at least one
+func NewEventBuffer(size int) *EventBuffer {
+	size = max(size, 1)
+	epoch := make([]byte, 4)
+	rand.Read(epoch)
+	return &EventBuffer{
+		epoch:   hex.EncodeToString(epoch),
+		events:  make([]Event, 0, size),
+		changed: make(chan struct{}),
+	}
+}
+
+// cursor returns the cursor of a sequence number
+func (b *EventBuffer) cursor(seq int64) string {
+	return fmt.Sprintf("%s-%d", b.epoch, seq)
+}
+
+// parseCursor returns the sequence number of a cursor, and whether it belongs to this buffer
+func (b *EventBuffer) parseCursor(cursor string) (int64, bool, error) {
+	epoch, seq, ok := strings.Cut(cursor, "-")
+	if !ok {
+		return 0, false, ErrInvalidCursor
+	}
+	number, err := strconv.ParseInt(seq, 10, 64)
+	if err != nil || number < 0 {
+		return 0, false, ErrInvalidCursor
+	}
+	return number, epoch == b.epoch, nil
+}
+
+// Append stamps an event with the next cursor and keeps it, dropping the oldest event when the buffer is full
+func (b *EventBuffer) Append(event Event) Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.seq++
+	event.Id = b.cursor(b.seq)
+	if len(b.events) < cap(b.events) {
+		b.events = append(b.events, event)
+	} else {
+		b.events[(b.seq-1)%int64(cap(b.events))] = event
+	}
+
+	close(b.changed)
+	b.changed = make(chan struct{})
+	return event
+}
+
+// Cursor returns the cursor of the last event
+func (b *EventBuffer) Cursor() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.cursor(b.seq)
+}
+
+// Since returns up to limit events after a cursor, and a channel closed when newer events arrive
+//
+// An empty cursor starts after the last event. A cursor that cannot be
+// resumed starts at the oldest kept event and the batch is marked as reset.
+func (b *EventBuffer) Since(cursor string, limit int) (EventBatch, <-chan struct{}, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	after := b.seq
+	batch := EventBatch{Events: make([]Event, 0)}
+	if cursor != "" {
+		seq, ok, err := b.parseCursor(cursor)
+		if err != nil {
+			return EventBatch{}, nil, err
+		}
+		oldest := b.seq - int64(len(b.events))
+		if !ok || seq > b.seq || seq < oldest {
+			batch.Reset = true
+			seq = oldest
+		}
+		after = seq
+	}
+
+	for seq := after + 1; seq <= b.seq && (limit <= 0 || len(batch.Events) < limit); seq++ {
+		batch.Events = append(batch.Events, b.events[(seq-1)%int64(cap(b.events))])
+		after = seq
+	}
+	batch.Cursor = b.cursor(after)
+	return batch, b.changed, nil
+}
@@ -0,0 +1,33 @@
+package internal
+
+import (
+	"errors"
+	"strconv"
+	"time"
+)
+
+// TTLParam is the query parameter setting the time to live of uploaded elements in seconds
+const TTLParam = "ttl"
+
+// ErrInvalidTTL is returned when a time to live is not a positive number of seconds
+var ErrInvalidTTL = errors.New("ttl must be a positive number of seconds")
+
+// ExpiresAt returns the expiry in unix milliseconds of elements uploaded at now with a TTL, empty without TTL
+func ExpiresAt(ttl string, now time.Time) (string, error) {
+	if ttl == "" {
+		return "", nil
+	}
+
+	seconds, err := strconv.ParseInt(ttl, 10, 64)
+	if err != nil || seconds <= 0 {
+		return "", ErrInvalidTTL
+	}
+
+	return strconv.FormatInt(now.Add(time.Duration(seconds)*time.Second).UnixMilli(), 10), nil
+}
+
+// IsExpired reports whether the attributes of an element carry an expiry that is past at now
+func IsExpired(attributes map[string]string, now time.Time) bool {
+	expiresAt, err := strconv.ParseInt(attributes["expiresAt"], 10, 64)
+	return err == nil && expiresAt <= now.UnixMilli()
+}
@@ -0,0 +1,244 @@
+package internal
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"path"
+	"strings"
+	"unicode"
+)
+
+// ExtractParam is the query parameter of uploads whose files are archives to extract, "true" extracts them
+const ExtractParam = "extract"
+
+// MaxArchiveEntries is the most entries extracted from the archives of an upload, the parts a multipart form is parsed with
+const MaxArchiveEntries = 1000
+
+// Errors of archive extraction
+var (
+	ErrUnknownArchive  = errors.New("file is not a zip, tar or tar.gz archive")
+	ErrUnsafeArchive   = errors.New("unsafe archive entry")
+	ErrArchiveTooLarge = errors.New("archive expands beyond the extraction limits")
+)
+
+// ArchiveLimits bounds what an upload may extract, to guard against decompression bombs
+//
+// Sizes are counted on the extracted bytes, the sizes declared by the archive
+// are not trusted.
+type ArchiveLimits struct {
+	MaxEntries int   // Most regular files extracted, at most MaxArchiveEntries
+	MaxSize    int64 // Most bytes extracted
+	MaxRatio   int64 // Most bytes extracted per byte of archive, 0 for no limit
+}
+
+// ArchiveEntryPath returns the cleaned relative path of an archive entry
+//
+// Absolute paths, drive letters and paths climbing out of the archive with ".."
+// are refused, so that extracting the elements back to disk cannot write
+// outside of the target folder (zip-slip).
+func ArchiveEntryPath(name string) (string, error) {
+	name = strings.ReplaceAll(name, "\\", "/")
+	if strings.HasPrefix(name, "/") || (len(name) >= 2 && name[1] == ':') {
+		return "", fmt.Errorf("%w: %s", ErrUnsafeArchive, name)
+	}
+	for _, segment := range strings.Split(name, "/") {
+		if segment == ".." {
+			return "", fmt.Errorf("%w: %s", ErrUnsafeArchive, name)
+		}
+	}
+	for _, r := range name {
+		if unicode.IsControl(r) {
+			return "", fmt.Errorf("%w: %q", ErrUnsafeArchive, name)
+		}
+	}
+
+	cleaned := path.Clean(name)
+	if cleaned == "." || len(cleaned) > MaxKeyLength {
+		return "", fmt.Errorf("%w: %q", ErrUnsafeArchive, name)
+	}
+	return cleaned, nil
+}
+
+// archiveEntry is a regular file read from an archive
+type archiveEntry struct {
+	path    string
+	content io.Reader
+}
+
+// archiveExtractor writes the entries of archives as the file parts of a multipart form
+type archiveExtractor struct {
+	writer    *multipart.Writer
+	limits    ArchiveLimits
+	entries   int
+	extracted int64
+	paths     []string
+}
+
+// add writes an entry as a file part, counting its bytes against the limits
+func (e *archiveExtractor) add(entry archiveEntry, budget int64) (int64, error) {
+	e.entries++
+	if e.entries > e.limits.MaxEntries {
+		return 0, fmt.Errorf("%w: more than %d entries", ErrArchiveTooLarge, e.limits.MaxEntries)
+	}
+
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", mime.FormatMediaType("form-data", map[string]string{"name": "files", "filename": path.Base(entry.path)}))
+	contentType := mime.TypeByExtension(path.Ext(entry.path))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	header.Set("Content-Type", contentType)
+	part, err := e.writer.CreatePart(header)
+	if err != nil {
+		return 0, err
+	}
+
+	// One byte past the budget tells an entry that fits from one that does not
+	written, err := io.Copy(part, io.LimitReader(entry.content, budget+1))
+	if err != nil {
+		return 0, err
+	}
+	if written > budget {
+		return 0, fmt.Errorf("%w: more than %d bytes", ErrArchiveTooLarge, e.extracted+budget)
+	}
+	e.extracted += written
+	e.paths = append(e.paths, entry.path)
+	return written, nil
+}
+
+// extract writes the regular files of an archive, other entries such as folders and links are skipped
+func (e *archiveExtractor) extract(archive *multipart.FileHeader) error {
+	file, err := archive.Open()
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	budget := e.limits.MaxSize - e.extracted
+	if e.limits.MaxRatio > 0 {
+		budget = min(budget, archive.Size*e.limits.MaxRatio)
+	}
+
+	reader := bufio.NewReader(file)
+	magic, _ := reader.Peek(512)
+	switch {
+	case bytes.HasPrefix(magic, []byte("PK\x03\x04")) || bytes.HasPrefix(magic, []byte("PK\x05\x06")):
+		zipReader, err := zip.NewReader(file, archive.Size)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrUnknownArchive, err)
+		}
+		for _, zipFile := range zipReader.File {
+			if !zipFile.Mode().IsRegular() {
+				continue
+			}
+			entryPath, err := ArchiveEntryPath(zipFile.Name)
+			if err != nil {
+				return err
+			}
+			content, err := zipFile.Open()
+			if err != nil {
+				return fmt.Errorf("%w: %v", ErrUnknownArchive, err)
+			}
+			written, err := e.add(archiveEntry{path: entryPath, content: content}, budget)
+			content.Close()
+			if err != nil {
+				return err
+			}
+			budget -= written
+		}
+		return nil
+
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		gzipReader, err := gzip.NewReader(reader)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrUnknownArchive, err)
+		}
+		defer gzipReader.Close()
+		return e.extractTar(tar.NewReader(gzipReader), budget)
+
+	case len(magic) >= 262 && string(magic[257:262]) == "ustar":
+		return e.extractTar(tar.NewReader(reader), budget)
+	}
+
+	return ErrUnknownArchive
+}
+
+// extractTar writes the regular files of a tar stream
+func (e *archiveExtractor) extractTar(reader *tar.Reader, budget int64) error {
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrUnknownArchive, err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		entryPath, err := ArchiveEntryPath(header.Name)
+		if err != nil {
+			return err
+		}
+		written, err := e.add(archiveEntry{path: entryPath, content: reader}, budget)
+		if err != nil {
+			return err
+		}
+		budget -= written
+	}
+}
+
+// ExtractArchives extracts the regular files of zip, tar and tar.gz archives as the files of a multipart form
+//
+// Files are returned in the order of the archives and of their entries, with
+// their relative paths in the same order. The form keeps up to maxMemory bytes
+// in memory and must be removed by the caller.
+func ExtractArchives(archives []*multipart.FileHeader, limits ArchiveLimits, maxMemory int64) ([]*multipart.FileHeader, []string, *multipart.Form, error) {
+	limits.MaxEntries = min(max(limits.MaxEntries, 1), MaxArchiveEntries)
+
+	pipeReader, pipeWriter := io.Pipe()
+	extractor := &archiveExtractor{writer: multipart.NewWriter(pipeWriter), limits: limits}
+	done := make(chan error, 1)
+	go func() {
+		var err error
+		for _, archive := range archives {
+			err = extractor.extract(archive)
+			if err != nil {
+				break
+			}
+		}
+		if err == nil {
+			err = extractor.writer.Close()
+		}
+		pipeWriter.CloseWithError(err)
+		done <- err
+	}()
+
+	form, err := multipart.NewReader(pipeReader, extractor.writer.Boundary()).ReadForm(maxMemory)
+	pipeReader.CloseWithError(err)
+	if extractErr := <-done; extractErr != nil {
+		err = extractErr
+	}
+	if err != nil {
+		if form != nil {
+			form.RemoveAll()
+		}
+		return nil, nil, nil, err
+	}
+
+	files := form.File["files"]
+	if len(files) != len(extractor.paths) {
+		form.RemoveAll()
+		return nil, nil, nil, fmt.Errorf("extracted %d entries, parsed %d", len(extractor.paths), len(files))
+	}
+	return files, extractor.paths, form, nil
+}
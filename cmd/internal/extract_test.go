@@ -0,0 +1,44 @@
+package internal
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestArchiveEntryPath(t *testing.T) {
+	tests := []struct {
+		name  string
+		entry string
+		want  string
+		err   error
+	}{
+		{name: "relative path", entry: "docs/report.txt", want: "docs/report.txt"},
+		{name: "redundant separators", entry: "./docs//report.txt", want: "docs/report.txt"},
+		{name: "backslashes", entry: `docs\report.txt`, want: "docs/report.txt"},
+		{name: "trailing slash", entry: "docs/", want: "docs"},
+		{name: "absolute path", entry: "/etc/passwd", err: ErrUnsafeArchive},
+		{name: "absolute windows path", entry: `\windows\system32`, err: ErrUnsafeArchive},
+		{name: "drive letter", entry: "C:/windows", err: ErrUnsafeArchive},
+		{name: "parent folder", entry: "../secret", err: ErrUnsafeArchive},
+		{name: "nested parent folder", entry: "docs/../../secret", err: ErrUnsafeArchive},
+		{name: "parent folder inside the archive", entry: "docs/../report.txt", err: ErrUnsafeArchive},
+		{name: "windows parent folder", entry: `docs\..\..\secret`, err: ErrUnsafeArchive},
+		{name: "control character", entry: "docs/re\x00port.txt", err: ErrUnsafeArchive},
+		{name: "current folder", entry: ".", err: ErrUnsafeArchive},
+		{name: "empty name", entry: "", err: ErrUnsafeArchive},
+		{name: "too long", entry: strings.Repeat("a", MaxKeyLength+1), err: ErrUnsafeArchive},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := ArchiveEntryPath(test.entry)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("error %v, want %v", err, test.err)
+			}
+			if got != test.want {
+				t.Errorf("path %q, want %q", got, test.want)
+			}
+		})
+	}
+}
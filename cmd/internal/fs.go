@@ -2,14 +2,28 @@ package internal
 
 import (
 	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"io"
 	"mime"
 	"mime/multipart"
 	"os"
 	"path/filepath"
+	"syscall"
 )
 
+// ErrInsufficientStorage is returned when a file cannot be written because the disk is full
+var ErrInsufficientStorage = errors.New("insufficient storage")
+
+// storageError maps out of space errors to ErrInsufficientStorage
+func storageError(err error) error {
+	if errors.Is(err, syscall.ENOSPC) {
+		return ErrInsufficientStorage
+	}
+	return err
+}
+
 // CreateDirectoryIfNotExists creates a directory if it does not exist
 func CreateDirectoryIfNotExists(root, name string) error {
 	// Create directory if it does not exist
@@ -53,7 +67,8 @@ func SaveBytesToFile(root, dir, name string, data []byte) error {
 	if errors.Is(err, os.ErrNotExist) {
 		err := os.WriteFile(filepath.Join(root, dir, name), data, 0644)
 		if err != nil {
-			return err
+			os.Remove(filepath.Join(root, dir, name))
+			return storageError(err)
 		}
 	}
 
@@ -76,27 +91,91 @@ func ReadBytesFromFile(root, dir, name string) ([]byte, string, error) {
 	return data, contentType, nil
 }
 
-// SaveMultipartToFile saves a multipart file to disk
-func SaveMultipartToFile(root, dir, name string, fileHeader *multipart.FileHeader) error {
+// SaveMultipartToFile saves a multipart file to disk and returns its SHA-256 checksum, a partially written file is removed
+func SaveMultipartToFile(root, dir, name string, fileHeader *multipart.FileHeader) (string, error) {
 	file, err := fileHeader.Open()
 	if err != nil {
-		return err
+		return "", err
 	}
 	defer file.Close()
 
-	outfile, err := os.Create(filepath.Join(root, dir, name))
+	return SaveReaderToFile(root, dir, name, file)
+}
+
+// SaveReaderToFile saves the content of a reader to a new file and returns its SHA-256 checksum, a partially written file is removed
+func SaveReaderToFile(root, dir, name string, content io.Reader) (string, error) {
+	path := filepath.Join(root, dir, name)
+	outfile, err := os.Create(path)
 	if err != nil {
-		return err
+		return "", storageError(err)
 	}
-	defer outfile.Close()
 
+	hash := sha256.New()
 	bufferedWriter := bufio.NewWriter(outfile)
-	defer bufferedWriter.Flush()
+	_, err = io.Copy(io.MultiWriter(bufferedWriter, hash), content)
+	if err == nil {
+		err = bufferedWriter.Flush()
+	}
+	if closeErr := outfile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return "", storageError(err)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// LinkFile makes target share the content of source, copying the file when the filesystem cannot hard link it
+//
+// Elements are never modified once written, so a hard link is a safe copy.
+func LinkFile(root, sourceDir, source, targetDir, target string) error {
+	sourcePath := filepath.Join(root, sourceDir, source)
+	targetPath := filepath.Join(root, targetDir, target)
+	if os.Link(sourcePath, targetPath) == nil {
+		return nil
+	}
 
-	_, err = io.Copy(bufferedWriter, file)
+	infile, err := os.Open(sourcePath)
 	if err != nil {
 		return err
 	}
+	defer infile.Close()
+
+	outfile, err := os.OpenFile(targetPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return storageError(err)
+	}
+	_, err = io.Copy(outfile, infile)
+	if closeErr := outfile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(targetPath)
+		return storageError(err)
+	}
+
+	return nil
+}
+
+// ReplaceFile writes a file through a temporary file renamed over it, so that readers see the old or the new content only
+func ReplaceFile(root, dir, name string, data []byte) error {
+	temp, err := os.CreateTemp(filepath.Join(root, dir), "."+name+".*.tmp")
+	if err != nil {
+		return storageError(err)
+	}
+	_, err = temp.Write(data)
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(temp.Name(), filepath.Join(root, dir, name))
+	}
+	if err != nil {
+		os.Remove(temp.Name())
+		return storageError(err)
+	}
 
 	return nil
 }
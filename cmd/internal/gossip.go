@@ -0,0 +1,735 @@
+package internal
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"math/rand"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemberState is the liveness of a cluster member as seen by the gossip protocol
+type MemberState string
+
+const (
+	MemberAlive   MemberState = "alive"   // Member answers probes
+	MemberSuspect MemberState = "suspect" // Member missed a probe and may be down
+	MemberDead    MemberState = "dead"    // Member was suspected for longer than the suspicion timeout
+	MemberLeft    MemberState = "left"    // Member left the cluster on purpose
+)
+
+// precedence orders states carrying the same incarnation
+var precedence = map[MemberState]int{MemberAlive: 0, MemberSuspect: 1, MemberDead: 2, MemberLeft: 3}
+
+// maxGossipPacket is the largest gossip message sent over UDP
+const maxGossipPacket = 65507
+
+// maxGossipStream is the largest membership exchanged over TCP
+const maxGossipStream = 32 << 20
+
+// ErrGossipSignature is returned when a gossip message is not signed with the cluster secret
+var ErrGossipSignature = errors.New("gossip message signature is invalid")
+
+// Member is a vault or gatekeeper taking part in the gossip protocol
+type Member struct {
+	Name        string            `json:"name"`        // Unique name of the member
+	Gossip      string            `json:"gossip"`      // Gossip address of the member (host:port)
+	Role        string            `json:"role"`        // Role of the member: "vault" or "gatekeeper"
+	Service     string            `json:"service"`     // HTTP address of the member (host:port)
+	Labels      map[string]string `json:"labels"`      // Labels of the member, such as its topology
+	State       MemberState       `json:"state"`       // Liveness of the member
+	Incarnation uint64            `json:"incarnation"` // Incarnation number, only the member itself increases it
+}
+
+// GossipConfig is the configuration of a gossip node
+type GossipConfig struct {
+	Name      string   `json:"name"`      // Unique name of the node, defaults to its advertised address
+	Bind      string   `json:"bind"`      // UDP and TCP address to listen on (host:port)
+	Advertise string   `json:"advertise"` // UDP address announced to other members, defaults to the bind address
+	Seeds     []string `json:"seeds"`     // Gossip addresses of members to join through
+	Secret    string   `json:"secret"`    // Secret shared by the cluster, messages are HMAC-signed with it when set
+
+	Interval       int `json:"interval"`        // Protocol period in milliseconds, defaults to 1000
+	SuspectTimeout int `json:"suspect_timeout"` // Time before a suspect member is declared dead in milliseconds, defaults to 5 periods
+	ReapTimeout    int `json:"reap_timeout"`    // Time before a dead or left member is forgotten in milliseconds, defaults to 24 hours
+	IndirectChecks int `json:"indirect_checks"` // Members asked to probe a member that missed a direct probe, defaults to 3
+}
+
+// gossipMessage is a message exchanged between gossip nodes
+type gossipMessage struct {
+	Type    string   `json:"type"`    // ping, ack or ping-req over UDP, sync or sync-ack over TCP
+	Seq     uint64   `json:"seq"`     // Sequence number matching acks with pings
+	From    Member   `json:"from"`    // Sender of the message
+	Target  string   `json:"target"`  // Gossip address to probe for ping-req
+	Members []Member `json:"members"` // Piggybacked updates, or the full membership for sync
+}
+
+// gossipUpdate is a membership update waiting to be piggybacked
+type gossipUpdate struct {
+	member    Member
+	transmits int
+}
+
+// memberEntry is the local view of a member
+type memberEntry struct {
+	Member
+	changed time.Time // Time of the last state change
+}
+
+// GossipNode is a member of a SWIM-style gossip cluster
+//
+// Nodes probe a random member every protocol period, ask other members to
+// probe it when it does not answer, and suspect it when they do not either.
+// Membership updates are piggybacked on probes over UDP, and the full
+// membership is periodically exchanged with a random member over TCP on the
+// same port. Several nodes can run in the same process.
+type GossipNode struct {
+	config   GossipConfig
+	conn     *net.UDPConn
+	listener net.Listener
+
+	mu       sync.Mutex
+	self     Member
+	members  map[string]*memberEntry
+	updates  []*gossipUpdate
+	acks     map[uint64]chan struct{}
+	seq      uint64
+	probes   []string
+	onChange func([]Member)
+
+	stop chan struct{}
+	done sync.WaitGroup
+}
+
+// NewGossipNode starts a gossip node announcing self, its name and gossip address are taken from config
+func NewGossipNode(config GossipConfig, self Member) (*GossipNode, error) {
+	if config.Bind == "" {
+		return nil, errors.New("gossip bind address is not set")
+	}
+	if config.Interval <= 0 {
+		config.Interval = 1000
+	}
+	if config.SuspectTimeout <= 0 {
+		config.SuspectTimeout = 5 * config.Interval
+	}
+	if config.ReapTimeout <= 0 {
+		config.ReapTimeout = int((24 * time.Hour).Milliseconds())
+	}
+	if config.IndirectChecks <= 0 {
+		config.IndirectChecks = 3
+	}
+
+	udpAddr, err := net.ResolveUDPAddr("udp", config.Bind)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("tcp", conn.LocalAddr().String())
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if config.Secret == "" {
+		log.Printf("Gossip has no secret, any host reaching %s can join the cluster\n", conn.LocalAddr())
+	}
+
+	if config.Advertise == "" {
+		config.Advertise = conn.LocalAddr().String()
+	}
+	if config.Name == "" {
+		config.Name = config.Advertise
+	}
+	self.Name = config.Name
+	self.Gossip = config.Advertise
+	self.State = MemberAlive
+
+	node := &GossipNode{
+		config:   config,
+		conn:     conn,
+		listener: listener,
+		self:     self,
+		members:  make(map[string]*memberEntry),
+		acks:     make(map[uint64]chan struct{}),
+		stop:     make(chan struct{}),
+	}
+
+	node.done.Add(3)
+	go node.receiveLoop()
+	go node.acceptLoop()
+	go node.probeLoop()
+
+	return node, nil
+}
+
+// Self returns the member announced by the node
+func (n *GossipNode) Self() Member {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.self
+}
+
+// Members returns the known members, the node itself included, sorted by name
+func (n *GossipNode) Members() []Member {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.membersLocked()
+}
+
+// membersLocked returns the known members, the caller holds the lock
+func (n *GossipNode) membersLocked() []Member {
+	members := make([]Member, 0, len(n.members)+1)
+	members = append(members, n.self)
+	for _, entry := range n.members {
+		members = append(members, entry.Member)
+	}
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].Name < members[j].Name
+	})
+
+	return members
+}
+
+// OnChange registers a function called with the membership whenever it changes
+func (n *GossipNode) OnChange(fn func([]Member)) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.onChange = fn
+}
+
+// Join exchanges the membership with the seeds, it succeeds when one seed answers
+func (n *GossipNode) Join(seeds []string) error {
+	if len(seeds) == 0 {
+		return nil
+	}
+
+	var err error
+	for attempt := 0; attempt < 3; attempt++ {
+		for _, seed := range seeds {
+			err = n.sync(seed)
+			if err == nil {
+				return nil
+			}
+		}
+
+		select {
+		case <-time.After(time.Duration(n.config.Interval) * time.Millisecond):
+		case <-n.stop:
+			return errors.New("gossip node stopped")
+		}
+	}
+
+	return fmt.Errorf("no seed answered: %v: %w", seeds, err)
+}
+
+// Leave announces that the node leaves the cluster and stops it
+func (n *GossipNode) Leave() {
+	n.mu.Lock()
+	n.self.Incarnation++
+	n.self.State = MemberLeft
+	message := gossipMessage{Type: "ping", From: n.self}
+	targets := make([]string, 0, len(n.members))
+	for _, entry := range n.members {
+		if entry.State == MemberAlive || entry.State == MemberSuspect {
+			targets = append(targets, entry.Gossip)
+		}
+	}
+	n.mu.Unlock()
+
+	for _, target := range targets {
+		n.send(target, message)
+	}
+	n.Close()
+}
+
+// Close stops the node without announcing it
+func (n *GossipNode) Close() {
+	select {
+	case <-n.stop:
+		return
+	default:
+	}
+	close(n.stop)
+	n.conn.Close()
+	n.listener.Close()
+	n.done.Wait()
+}
+
+// nextSeq returns a new sequence number, ack is signaled when it is acknowledged
+func (n *GossipNode) nextSeq(ack chan struct{}) uint64 {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.seq++
+	if ack != nil {
+		n.acks[n.seq] = ack
+	}
+	return n.seq
+}
+
+// forgetSeq stops waiting for the acknowledgement of a sequence number
+func (n *GossipNode) forgetSeq(seq uint64) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	delete(n.acks, seq)
+}
+
+// send sends a message with piggybacked updates
+func (n *GossipNode) send(address string, message gossipMessage) {
+	n.mu.Lock()
+	if message.From.Name == "" {
+		message.From = n.self
+	}
+	message.Members = n.piggybackLocked()
+	n.mu.Unlock()
+
+	data, err := n.seal(message)
+	if err != nil {
+		log.Printf("Error encoding gossip message: %v\n", err)
+		return
+	}
+	if len(data) > maxGossipPacket {
+		log.Printf("Gossip message of %d bytes is too large for UDP\n", len(data))
+		return
+	}
+
+	udpAddr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return
+	}
+	n.conn.WriteToUDP(data, udpAddr)
+}
+
+// seal encodes a message, prefixed with its HMAC when the cluster has a secret
+func (n *GossipNode) seal(message gossipMessage) ([]byte, error) {
+	data, err := json.Marshal(message)
+	if err != nil || n.config.Secret == "" {
+		return data, err
+	}
+	mac := hmac.New(sha256.New, []byte(n.config.Secret))
+	mac.Write(data)
+	return append(mac.Sum(nil), data...), nil
+}
+
+// open verifies the HMAC of an encoded message when the cluster has a secret and decodes it
+func (n *GossipNode) open(data []byte) (gossipMessage, error) {
+	var message gossipMessage
+	if n.config.Secret != "" {
+		if len(data) < sha256.Size {
+			return message, ErrGossipSignature
+		}
+		mac := hmac.New(sha256.New, []byte(n.config.Secret))
+		mac.Write(data[sha256.Size:])
+		if !hmac.Equal(mac.Sum(nil), data[:sha256.Size]) {
+			return message, ErrGossipSignature
+		}
+		data = data[sha256.Size:]
+	}
+	err := json.Unmarshal(data, &message)
+	return message, err
+}
+
+// writeStream writes a message to a TCP connection, prefixed with its length
+func (n *GossipNode) writeStream(conn net.Conn, message gossipMessage) error {
+	data, err := n.seal(message)
+	if err != nil {
+		return err
+	}
+	if len(data) > maxGossipStream {
+		return fmt.Errorf("gossip message of %d bytes is too large", len(data))
+	}
+	frame := binary.BigEndian.AppendUint32(make([]byte, 0, 4+len(data)), uint32(len(data)))
+	_, err = conn.Write(append(frame, data...))
+	return err
+}
+
+// readStream reads a length-prefixed message from a TCP connection
+func (n *GossipNode) readStream(reader io.Reader) (gossipMessage, error) {
+	var size uint32
+	err := binary.Read(reader, binary.BigEndian, &size)
+	if err != nil {
+		return gossipMessage{}, err
+	}
+	if size > maxGossipStream {
+		return gossipMessage{}, fmt.Errorf("gossip message of %d bytes is too large", size)
+	}
+	data := make([]byte, size)
+	_, err = io.ReadFull(reader, data)
+	if err != nil {
+		return gossipMessage{}, err
+	}
+	return n.open(data)
+}
+
+// sync exchanges the full membership with a member over TCP
+func (n *GossipNode) sync(address string) error {
+	timeout := time.Duration(n.config.Interval) * time.Millisecond
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	err = n.writeStream(conn, gossipMessage{Type: "sync", From: n.Self(), Members: n.Members()})
+	if err != nil {
+		return err
+	}
+	reply, err := n.readStream(bufio.NewReader(conn))
+	if err != nil {
+		return err
+	}
+	if reply.Type != "sync-ack" {
+		return fmt.Errorf("unexpected gossip reply %s", reply.Type)
+	}
+	n.merge(append(reply.Members, reply.From))
+	return nil
+}
+
+// piggybackLocked returns the updates to attach to a message, the caller holds the lock
+func (n *GossipNode) piggybackLocked() []Member {
+	// Updates are retransmitted a logarithmic number of times in the cluster size
+	limit := 3 * int(math.Ceil(math.Log2(float64(len(n.members)+2))))
+
+	sort.SliceStable(n.updates, func(i, j int) bool {
+		return n.updates[i].transmits < n.updates[j].transmits
+	})
+
+	members := make([]Member, 0, 8)
+	kept := n.updates[:0]
+	for _, update := range n.updates {
+		if len(members) < cap(members) {
+			members = append(members, update.member)
+			update.transmits++
+		}
+		if update.transmits < limit {
+			kept = append(kept, update)
+		}
+	}
+	n.updates = kept
+
+	return members
+}
+
+// queueLocked queues a membership update for dissemination, the caller holds the lock
+func (n *GossipNode) queueLocked(member Member) {
+	for _, update := range n.updates {
+		if update.member.Name == member.Name {
+			update.member = member
+			update.transmits = 0
+			return
+		}
+	}
+	n.updates = append(n.updates, &gossipUpdate{member: member})
+}
+
+// merge applies membership updates and notifies changes
+func (n *GossipNode) merge(members []Member) {
+	n.mu.Lock()
+	changed := false
+	for _, member := range members {
+		if n.mergeLocked(member) {
+			changed = true
+		}
+	}
+	n.notifyLocked(changed)
+}
+
+// notifyLocked releases the lock and calls the change function if the membership changed
+func (n *GossipNode) notifyLocked(changed bool) {
+	onChange := n.onChange
+	var members []Member
+	if changed && onChange != nil {
+		members = n.membersLocked()
+	}
+	n.mu.Unlock()
+
+	if members != nil {
+		onChange(members)
+	}
+}
+
+// mergeLocked applies a membership update and reports whether it changed the membership
+func (n *GossipNode) mergeLocked(member Member) bool {
+	if member.Name == "" {
+		return false
+	}
+
+	// Refute suspicions about ourselves with a higher incarnation
+	if member.Name == n.self.Name {
+		if member.State != MemberAlive && n.self.State == MemberAlive && member.Incarnation >= n.self.Incarnation {
+			n.self.Incarnation = member.Incarnation + 1
+			n.queueLocked(n.self)
+		}
+		return false
+	}
+
+	entry, ok := n.members[member.Name]
+	if !ok {
+		log.Printf("Gossip member %s (%s) joined as %s\n", member.Name, member.Role, member.State)
+		n.members[member.Name] = &memberEntry{Member: member, changed: time.Now()}
+		n.queueLocked(member)
+		return true
+	}
+
+	newer := member.Incarnation > entry.Incarnation ||
+		(member.Incarnation == entry.Incarnation && precedence[member.State] > precedence[entry.State])
+	if !newer {
+		return false
+	}
+
+	if member.State != entry.State {
+		log.Printf("Gossip member %s (%s) is %s\n", member.Name, member.Role, member.State)
+		entry.changed = time.Now()
+	}
+	entry.Member = member
+	n.queueLocked(member)
+	return true
+}
+
+// receiveLoop handles incoming messages until the node stops
+func (n *GossipNode) receiveLoop() {
+	defer n.done.Done()
+
+	buffer := make([]byte, maxGossipPacket)
+	for {
+		size, _, err := n.conn.ReadFromUDP(buffer)
+		if err != nil {
+			select {
+			case <-n.stop:
+				return
+			default:
+				continue
+			}
+		}
+
+		message, err := n.open(buffer[:size])
+		if err != nil {
+			continue
+		}
+		n.handle(message)
+	}
+}
+
+// acceptLoop answers the membership exchanges over TCP until the node stops
+func (n *GossipNode) acceptLoop() {
+	defer n.done.Done()
+
+	for {
+		conn, err := n.listener.Accept()
+		if err != nil {
+			select {
+			case <-n.stop:
+				return
+			default:
+				continue
+			}
+		}
+		go n.serveSync(conn)
+	}
+}
+
+// serveSync merges the membership sent over a TCP connection and replies with the local one
+func (n *GossipNode) serveSync(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Duration(n.config.Interval) * time.Millisecond))
+
+	message, err := n.readStream(bufio.NewReader(conn))
+	if err != nil || message.Type != "sync" {
+		return
+	}
+	n.merge(append(message.Members, message.From))
+	n.writeStream(conn, gossipMessage{Type: "sync-ack", From: n.Self(), Members: n.Members()})
+}
+
+// handle processes a message
+func (n *GossipNode) handle(message gossipMessage) {
+	n.merge(append(message.Members, message.From))
+
+	switch message.Type {
+	case "ping":
+		if message.From.State != MemberLeft {
+			n.send(message.From.Gossip, gossipMessage{Type: "ack", Seq: message.Seq})
+		}
+
+	case "ping-req":
+		// Probe the target on behalf of the sender and forward its ack
+		ack := make(chan struct{}, 1)
+		seq := n.nextSeq(ack)
+		n.send(message.Target, gossipMessage{Type: "ping", Seq: seq})
+		go func() {
+			defer n.forgetSeq(seq)
+			select {
+			case <-ack:
+				n.send(message.From.Gossip, gossipMessage{Type: "ack", Seq: message.Seq})
+			case <-time.After(time.Duration(n.config.Interval) * time.Millisecond):
+			case <-n.stop:
+			}
+		}()
+
+	case "ack":
+		n.mu.Lock()
+		ack, ok := n.acks[message.Seq]
+		n.mu.Unlock()
+		if ok {
+			select {
+			case ack <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// probeLoop probes a member every protocol period until the node stops
+func (n *GossipNode) probeLoop() {
+	defer n.done.Done()
+
+	interval := time.Duration(n.config.Interval) * time.Millisecond
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for period := 1; ; period++ {
+		select {
+		case <-ticker.C:
+		case <-n.stop:
+			return
+		}
+
+		n.expire()
+		if target, ok := n.nextProbe(); ok {
+			n.probe(target, interval)
+		}
+
+		// Periodically exchange the full membership to repair missed updates
+		if period%10 == 0 {
+			if target, ok := n.randomMembers(1, ""); ok {
+				go n.sync(target[0].Gossip)
+			}
+		}
+	}
+}
+
+// nextProbe returns the next member to probe, visiting members in a shuffled round-robin
+func (n *GossipNode) nextProbe() (Member, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for len(n.probes) > 0 {
+		name := n.probes[0]
+		n.probes = n.probes[1:]
+		entry, ok := n.members[name]
+		if ok && (entry.State == MemberAlive || entry.State == MemberSuspect) {
+			return entry.Member, true
+		}
+	}
+
+	for name, entry := range n.members {
+		if entry.State == MemberAlive || entry.State == MemberSuspect {
+			n.probes = append(n.probes, name)
+		}
+	}
+	rand.Shuffle(len(n.probes), func(i, j int) {
+		n.probes[i], n.probes[j] = n.probes[j], n.probes[i]
+	})
+	if len(n.probes) == 0 {
+		return Member{}, false
+	}
+
+	name := n.probes[0]
+	n.probes = n.probes[1:]
+	return n.members[name].Member, true
+}
+
+// randomMembers returns up to k random live members other than exclude
+func (n *GossipNode) randomMembers(k int, exclude string) ([]Member, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	candidates := make([]Member, 0, len(n.members))
+	for name, entry := range n.members {
+		if name != exclude && entry.State == MemberAlive {
+			candidates = append(candidates, entry.Member)
+		}
+	}
+	rand.Shuffle(len(candidates), func(i, j int) {
+		candidates[i], candidates[j] = candidates[j], candidates[i]
+	})
+	if len(candidates) > k {
+		candidates = candidates[:k]
+	}
+
+	return candidates, len(candidates) > 0
+}
+
+// probe pings a member directly, then through other members, and suspects it if nobody reaches it
+func (n *GossipNode) probe(target Member, interval time.Duration) {
+	ack := make(chan struct{}, 1)
+	seq := n.nextSeq(ack)
+	defer n.forgetSeq(seq)
+
+	n.send(target.Gossip, gossipMessage{Type: "ping", Seq: seq})
+	select {
+	case <-ack:
+		return
+	case <-time.After(interval / 2):
+	case <-n.stop:
+		return
+	}
+
+	helpers, _ := n.randomMembers(n.config.IndirectChecks, target.Name)
+	for _, helper := range helpers {
+		n.send(helper.Gossip, gossipMessage{Type: "ping-req", Seq: seq, Target: target.Gossip})
+	}
+	select {
+	case <-ack:
+		return
+	case <-time.After(interval / 2):
+	case <-n.stop:
+		return
+	}
+
+	n.mu.Lock()
+	entry, ok := n.members[target.Name]
+	changed := false
+	if ok && entry.State == MemberAlive && entry.Incarnation == target.Incarnation {
+		suspect := entry.Member
+		suspect.State = MemberSuspect
+		changed = n.mergeLocked(suspect)
+	}
+	n.notifyLocked(changed)
+}
+
+// expire declares long suspected members dead and forgets long dead or left members
+func (n *GossipNode) expire() {
+	now := time.Now()
+	suspectTimeout := time.Duration(n.config.SuspectTimeout) * time.Millisecond
+	reapTimeout := time.Duration(n.config.ReapTimeout) * time.Millisecond
+
+	n.mu.Lock()
+	changed := false
+	for name, entry := range n.members {
+		switch {
+		case entry.State == MemberSuspect && now.Sub(entry.changed) > suspectTimeout:
+			dead := entry.Member
+			dead.State = MemberDead
+			if n.mergeLocked(dead) {
+				changed = true
+			}
+		case (entry.State == MemberDead || entry.State == MemberLeft) && now.Sub(entry.changed) > reapTimeout:
+			delete(n.members, name)
+			changed = true
+		}
+	}
+	n.notifyLocked(changed)
+}
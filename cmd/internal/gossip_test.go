@@ -0,0 +1,221 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testGossipConfig returns the configuration of a fast gossip node on a free loopback port
+func testGossipConfig(name string) GossipConfig {
+	return GossipConfig{
+		Name:           name,
+		Bind:           "127.0.0.1:0",
+		Interval:       50,
+		SuspectTimeout: 250,
+		ReapTimeout:    500,
+		IndirectChecks: 1,
+	}
+}
+
+// startGossipNode starts a gossip node stopped at the end of the test
+func startGossipNode(t *testing.T, config GossipConfig) *GossipNode {
+	t.Helper()
+	node, err := NewGossipNode(config, Member{Role: "vault", Service: config.Name + ":8080"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(node.Close)
+	return node
+}
+
+// joinGossipNode joins a node to a seed node
+func joinGossipNode(t *testing.T, node, seed *GossipNode) {
+	t.Helper()
+	err := node.Join([]string{seed.Self().Gossip})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// memberState returns the state of a member as seen by a node, empty when the node does not know it
+func memberState(node *GossipNode, name string) MemberState {
+	for _, member := range node.Members() {
+		if member.Name == name {
+			return member.State
+		}
+	}
+	return ""
+}
+
+// waitFor polls a condition until it holds or the timeout passes
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestGossipJoin(t *testing.T) {
+	a := startGossipNode(t, testGossipConfig("a"))
+	b := startGossipNode(t, testGossipConfig("b"))
+	c := startGossipNode(t, testGossipConfig("c"))
+	joinGossipNode(t, b, a)
+	joinGossipNode(t, c, a)
+
+	// b learns about c through a, by piggybacked updates or a periodic sync
+	for _, node := range []*GossipNode{a, b, c} {
+		waitFor(t, node.Self().Name+" to see every member", func() bool {
+			return memberState(node, "a") == MemberAlive && memberState(node, "b") == MemberAlive && memberState(node, "c") == MemberAlive
+		})
+	}
+}
+
+func TestGossipSuspectDeadReap(t *testing.T) {
+	a := startGossipNode(t, testGossipConfig("a"))
+	b := startGossipNode(t, testGossipConfig("b"))
+	c := startGossipNode(t, testGossipConfig("c"))
+	joinGossipNode(t, b, a)
+	joinGossipNode(t, c, a)
+	waitFor(t, "a to see c", func() bool { return memberState(a, "c") == MemberAlive })
+
+	var mu sync.Mutex
+	seen := make(map[MemberState]bool)
+	a.OnChange(func(members []Member) {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, member := range members {
+			if member.Name == "c" {
+				seen[member.State] = true
+			}
+		}
+	})
+
+	c.Close()
+	waitFor(t, "c to be reaped", func() bool { return memberState(a, "c") == "" })
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, state := range []MemberState{MemberSuspect, MemberDead} {
+		if !seen[state] {
+			t.Errorf("c was never %s", state)
+		}
+	}
+	if state := memberState(a, "b"); state != MemberAlive {
+		t.Errorf("b is %s, want alive", state)
+	}
+}
+
+func TestGossipRefuteAfterRestart(t *testing.T) {
+	a := startGossipNode(t, testGossipConfig("a"))
+	config := testGossipConfig("b")
+	config.ReapTimeout = int(time.Minute.Milliseconds())
+	b := startGossipNode(t, config)
+	joinGossipNode(t, b, a)
+	waitFor(t, "a to see b", func() bool { return memberState(a, "b") == MemberAlive })
+
+	b.Close()
+	waitFor(t, "b to be dead", func() bool { return memberState(a, "b") == MemberDead })
+
+	// The restarted node starts over at incarnation 0 and must refute its death
+	restarted := startGossipNode(t, config)
+	joinGossipNode(t, restarted, a)
+	waitFor(t, "b to be alive again", func() bool { return memberState(a, "b") == MemberAlive })
+	if restarted.Self().Incarnation == 0 {
+		t.Errorf("restarted node did not raise its incarnation")
+	}
+}
+
+func TestGossipLeave(t *testing.T) {
+	a := startGossipNode(t, testGossipConfig("a"))
+	config := testGossipConfig("b")
+	b, err := NewGossipNode(config, Member{Role: "vault"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	joinGossipNode(t, b, a)
+	waitFor(t, "a to see b", func() bool { return memberState(a, "b") == MemberAlive })
+
+	b.Leave()
+	waitFor(t, "b to leave", func() bool { return memberState(a, "b") == MemberLeft })
+}
+
+func TestGossipSecret(t *testing.T) {
+	config := testGossipConfig("a")
+	config.Secret = "cluster"
+	a := startGossipNode(t, config)
+
+	config = testGossipConfig("b")
+	config.Secret = "cluster"
+	b := startGossipNode(t, config)
+	joinGossipNode(t, b, a)
+	waitFor(t, "a to see b", func() bool { return memberState(a, "b") == MemberAlive })
+
+	config = testGossipConfig("c")
+	config.Secret = "other"
+	c := startGossipNode(t, config)
+	if err := c.Join([]string{a.Self().Gossip}); err == nil {
+		t.Errorf("node with another secret joined")
+	}
+	config = testGossipConfig("d")
+	d := startGossipNode(t, config)
+	if err := d.Join([]string{a.Self().Gossip}); err == nil {
+		t.Errorf("node without secret joined")
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	for _, name := range []string{"c", "d"} {
+		if state := memberState(a, name); state != "" {
+			t.Errorf("%s is %s, want unknown", name, state)
+		}
+	}
+}
+
+func TestGossipStream(t *testing.T) {
+	// The full membership of a large cluster does not fit a UDP packet
+	members := make([]Member, 2000)
+	for i := range members {
+		members[i] = Member{Name: fmt.Sprintf("vault-%04d", i), Gossip: "10.0.0.1:7000", Role: "vault", State: MemberAlive}
+	}
+	message := gossipMessage{Type: "sync", Members: members}
+
+	tests := []struct {
+		name   string
+		sender string
+		reader string
+		err    error
+	}{
+		{name: "without secret"},
+		{name: "same secret", sender: "cluster", reader: "cluster"},
+		{name: "other secret", sender: "cluster", reader: "other", err: ErrGossipSignature},
+		{name: "unsigned message", reader: "cluster", err: ErrGossipSignature},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sender := &GossipNode{config: GossipConfig{Secret: test.sender}}
+			reader := &GossipNode{config: GossipConfig{Secret: test.reader}}
+			client, server := net.Pipe()
+			defer server.Close()
+			go func() {
+				defer client.Close()
+				sender.writeStream(client, message)
+			}()
+
+			received, err := reader.readStream(server)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("error %v, want %v", err, test.err)
+			}
+			if err == nil && len(received.Members) != len(members) {
+				t.Errorf("%d members received, want %d", len(received.Members), len(members))
+			}
+		})
+	}
+}
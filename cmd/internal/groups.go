@@ -0,0 +1,101 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"unicode"
+)
+
+const (
+	DefaultGroupsLimit = 1000  // Groups per page when no limit is requested
+	MaxGroupsLimit     = 10000 // Largest page of groups that can be requested
+)
+
+// ErrInvalidLimit is returned when a page limit is not a positive number
+var ErrInvalidLimit = errors.New("limit must be a positive number")
+
+// GroupMetaFile is the file of a group folder holding the metadata of the group
+const GroupMetaFile = "._group"
+
+// MetaParam is the query parameter of group listings answering the metadata of the group with its elements, "true" answers it
+const MetaParam = "meta"
+
+// Limits of the metadata of a group
+const (
+	MaxGroupDescription = 4096 // Longest description in bytes
+	MaxGroupOwner       = 256  // Longest owner in bytes
+	MaxGroupLabels      = 64   // Most labels
+	MaxGroupLabel       = 256  // Longest label name or value in bytes
+)
+
+// ErrInvalidGroupMeta is returned when the metadata of a group is malformed
+var ErrInvalidGroupMeta = errors.New("invalid group metadata")
+
+// GroupMeta is the metadata of a group, stored in its folder by every replica
+type GroupMeta struct {
+	Description string            `json:"description,omitempty"` // Free text describing the group
+	Owner       string            `json:"owner,omitempty"`       // Principal or team owning the group
+	Labels      map[string]string `json:"labels,omitempty"`      // Labels by name
+	CreatedAt   int64             `json:"createdAt,omitempty"`   // Creation time of the group in unix milliseconds
+	UpdatedAt   int64             `json:"updatedAt,omitempty"`   // Last change of the metadata in unix milliseconds, the most recent replica wins
+}
+
+// Validate checks the sizes of the metadata of a group
+func (m GroupMeta) Validate() error {
+	if len(m.Description) > MaxGroupDescription {
+		return fmt.Errorf("%w: description is longer than %d bytes", ErrInvalidGroupMeta, MaxGroupDescription)
+	}
+	if len(m.Owner) > MaxGroupOwner {
+		return fmt.Errorf("%w: owner is longer than %d bytes", ErrInvalidGroupMeta, MaxGroupOwner)
+	}
+	if len(m.Labels) > MaxGroupLabels {
+		return fmt.Errorf("%w: more than %d labels", ErrInvalidGroupMeta, MaxGroupLabels)
+	}
+	for name, value := range m.Labels {
+		if name == "" || len(name) > MaxGroupLabel || len(value) > MaxGroupLabel {
+			return fmt.Errorf("%w: label names must be 1 to %d bytes and values at most %d bytes", ErrInvalidGroupMeta, MaxGroupLabel, MaxGroupLabel)
+		}
+		for _, r := range name {
+			if unicode.IsControl(r) || unicode.IsSpace(r) {
+				return fmt.Errorf("%w: label name %q", ErrInvalidGroupMeta, name)
+			}
+		}
+	}
+	return nil
+}
+
+// GroupListing is the listing of a group answered with the metadata of the group
+type GroupListing struct {
+	Group    GroupMeta `json:"group"`    // Metadata of the group
+	Elements []Record  `json:"elements"` // Elements of the group
+}
+
+// GroupSummary is a group with its element count and total size
+type GroupSummary struct {
+	GroupId  string `json:"groupId"`  // Group identifier
+	Elements int    `json:"elements"` // Number of elements in the group
+	Size     int64  `json:"size"`     // Total size of the elements in bytes
+}
+
+// GroupsPage is a page of groups sorted by group id
+type GroupsPage struct {
+	Groups      []GroupSummary `json:"groups"`                // Groups of the page
+	Next        string         `json:"next,omitempty"`        // Cursor of the next page, empty on the last page
+	Partial     []string       `json:"partial,omitempty"`     // Vaults that answered with an unusable listing
+	Unavailable []string       `json:"unavailable,omitempty"` // Vaults that did not answer
+}
+
+// ParseGroupsLimit parses the limit of a page of groups, bounded by MaxGroupsLimit
+func ParseGroupsLimit(value string) (int, error) {
+	if value == "" {
+		return DefaultGroupsLimit, nil
+	}
+
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit <= 0 {
+		return 0, ErrInvalidLimit
+	}
+
+	return min(limit, MaxGroupsLimit), nil
+}
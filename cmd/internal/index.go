@@ -1,9 +1,13 @@
 package internal
 
-// Index is a data structure that stores records and allows for fast search
+import "sync"
+
+// Index is a data structure that stores records and allows for fast search, it is safe for concurrent use
 type Index struct {
 	Index InvertedIndex
 	Meta  MetaIndex
+
+	mu *sync.RWMutex
 }
 
 // InvertedIndex is a map of attributes to values to record ids
@@ -20,6 +24,9 @@ type Record struct {
 
 // Add adds a record to the index
 func (i Index) Add(r Record) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
 	// Add record to the index
 	for k, v := range r.Attributes {
 		if _, ok := i.Index[k]; !ok {
@@ -37,6 +44,9 @@ func (i Index) Add(r Record) {
 
 // Remove removes a record from the index
 func (i Index) Remove(r Record) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
 	for k, v := range r.Attributes {
 		delete(i.Index[k][v], r.Id)
 		if len(i.Index[k][v]) == 0 {
@@ -52,6 +62,9 @@ func (i Index) Remove(r Record) {
 
 // Get returns a record from the index by id
 func (i Index) Get(id string) Record {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
 	return Record{
 		Id:         id,
 		Attributes: i.Meta[id],
@@ -60,23 +73,41 @@ func (i Index) Get(id string) Record {
 
 // GetAttributes returns the attributes of a record
 func (i Index) GetAttributes(id string) map[string]string {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
 	return i.Meta[id]
 }
 
 // SearchEvery returns a list of records that match all key-value in the query
 func (i Index) SearchEvery(query map[string]string) []Record {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
 	result := make([]Record, 0)
+
+	// Start from the smallest set of ids and keep the ids matching every other pair
+	var smallest map[string]bool
 	for attr, value := range query {
-		// If the attribute is not in the index, return an empty result
-		if _, ok := i.Index[attr]; !ok {
+		ids, ok := i.Index[attr][value]
+		// If the attribute or the value is not in the index, return an empty result
+		if !ok {
 			return result
 		}
-		// If the value is not in the index, return an empty result
-		if _, ok := i.Index[attr][value]; !ok {
-			return result
+		if smallest == nil || len(ids) < len(smallest) {
+			smallest = ids
 		}
-		// For each record id in the index, add the record to the result
-		for id := range i.Index[attr][value] {
+	}
+
+	for id := range smallest {
+		matches := true
+		for attr, value := range query {
+			if !i.Index[attr][value][id] {
+				matches = false
+				break
+			}
+		}
+		if matches {
 			result = append(result, Record{
 				Id:         id,
 				Attributes: i.Meta[id],
@@ -89,6 +120,9 @@ func (i Index) SearchEvery(query map[string]string) []Record {
 
 // SearchAny returns a list of records that match any key-value in the query
 func (i Index) SearchAny(query map[string]string) []Record {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
 	result := make([]Record, 0)
 	for attr, value := range query {
 		if _, ok := i.Index[attr]; !ok {
@@ -109,6 +143,9 @@ func (i Index) SearchAny(query map[string]string) []Record {
 
 // SearchAll returns a list of records that match all key in the query
 func (i Index) SearchAll(query []string) []Record {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
 	result := make([]Record, 0)
 	for _, attr := range query {
 		if _, ok := i.Index[attr]; !ok {
@@ -127,10 +164,19 @@ func (i Index) SearchAll(query []string) []Record {
 	return result
 }
 
+// Len returns the number of records in the index
+func (i Index) Len() int {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	return len(i.Meta)
+}
+
 // NewIndex creates a new InvertedIndex
 func NewIndex() Index {
 	return Index{
 		Index: make(InvertedIndex),
 		Meta:  make(MetaIndex),
+		mu:    &sync.RWMutex{},
 	}
 }
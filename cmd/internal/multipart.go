@@ -2,8 +2,10 @@ package internal
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"mime/multipart"
+	"os"
 	"path/filepath"
 	"strings"
 	"sync"
@@ -12,6 +14,15 @@ import (
 	"github.com/google/uuid"
 )
 
+// ErrElementExists is returned when an element is uploaded with the id of an existing element
+var ErrElementExists = errors.New("element already exists")
+
+// PresetParam is the query parameter marking uploads whose metadata is assigned by the gatekeeper
+//
+// Vaults only read the meta part of uploads carrying it, so that clients
+// cannot choose the ids, paths or retention of their elements.
+const PresetParam = "preset"
+
 // Meta is the metadata for a file
 type Meta struct {
 	FileId        string `json:"fileId"`
@@ -21,64 +32,108 @@ type Meta struct {
 	FileSize      string `json:"fileSize"`
 	ReceivedTime  string `json:"receivedTime"`
 	GroupId       string `json:"groupId"`
+	Namespace     string `json:"namespace"`
+	Checksum      string `json:"checksum"`
+	ExpiresAt     string `json:"expiresAt,omitempty"`
+	Key           string `json:"key,omitempty"`
+	Path          string `json:"path,omitempty"`
+	Retention
 }
 
 // ProcessMultipartFiles processes multiple files in parallel
-func ProcessMultipartFiles(files []*multipart.FileHeader, groupId, root string) ([]Meta, error) {
+//
+// preset optionally carries the file id, received time, expiry, key, path and retention of each
+// file, in the order of files, so that replicas of a group store elements under
+// the same id and expire and version them together.
+func ProcessMultipartFiles(files []*multipart.FileHeader, namespace, groupId, root string, preset []Meta) ([]Meta, error) {
+	if preset != nil && len(preset) != len(files) {
+		return nil, fmt.Errorf("expected metadata for %d files, got %d", len(files), len(preset))
+	}
 
 	var wg sync.WaitGroup
 	wg.Add(len(files))
 
 	var metadata = make([]Meta, len(files))
-	errs := make(chan error)
+	errs := make(chan error, len(files))
 	for i, fileHeader := range files {
-		go ProcessFile(fileHeader, groupId, root, &wg, errs, &metadata[i])
+		var fileMeta Meta
+		if preset != nil {
+			fileMeta = preset[i]
+		}
+		go ProcessFile(fileHeader, namespace, groupId, root, fileMeta, &wg, errs, &metadata[i])
 	}
 
 	wg.Wait()
 	close(errs)
 
 	for err := range errs {
+		// Remove the files that were saved before the failure
+		for _, meta := range metadata {
+			if meta.FileId == "" {
+				continue
+			}
+			DeleteFile(root, GroupDir(namespace, groupId), meta.FileId+meta.FileExtension)
+			DeleteFile(root, GroupDir(namespace, groupId), meta.FileId+"._meta")
+		}
 		return nil, err
 	}
 
 	return metadata, nil
 }
 
-// ProcessFile processes a single file
-func ProcessFile(file *multipart.FileHeader, groupId, root string, wg *sync.WaitGroup, errs chan error, responseMeta *Meta) {
+// ProcessFile processes a single file, the file id, received time, expiry and key are taken from preset when set
+func ProcessFile(file *multipart.FileHeader, namespace, groupId, root string, preset Meta, wg *sync.WaitGroup, errs chan error, responseMeta *Meta) {
 	defer wg.Done()
 
-	fileId := strings.ReplaceAll(uuid.New().String(), "-", "")
+	dir := GroupDir(namespace, groupId)
+	fileId := preset.FileId
+	if fileId == "" {
+		fileId = strings.ReplaceAll(uuid.New().String(), "-", "")
+	} else if _, err := os.Stat(filepath.Join(root, dir, fileId+"._meta")); err == nil {
+		errs <- fmt.Errorf("%w: %s", ErrElementExists, fileId)
+		return
+	}
+	receivedTime := preset.ReceivedTime
+	if receivedTime == "" {
+		receivedTime = fmt.Sprintf("%d", time.Now().UnixMilli())
+	}
 	filetype := file.Header.Get("Content-Type")
 	filename := file.Filename
 	filesize := file.Size
 	extension := filepath.Ext(filename)
 
+	var err error
 	var metadata Meta
 	metadata.FileId = fileId
 	metadata.FileType = filetype
 	metadata.FileName = filename
 	metadata.FileExtension = extension
 	metadata.FileSize = fmt.Sprintf("%d", filesize)
-	metadata.ReceivedTime = fmt.Sprintf("%d", time.Now().UnixMilli())
+	metadata.ReceivedTime = receivedTime
 	metadata.GroupId = groupId
-
-	// Save file to disk
-	metaBytes, err := json.Marshal(metadata)
+	metadata.Namespace = namespace
+	metadata.ExpiresAt = preset.ExpiresAt
+	metadata.Key = preset.Key
+	metadata.Path = preset.Path
+	metadata.Retention = preset.Retention
+
+	// Save file to disk, the meta file is written last so it only exists for complete files
+	metadata.Checksum, err = SaveMultipartToFile(root, dir, fileId+extension, file)
 	if err != nil {
 		errs <- err
 		return
 	}
 
-	err = SaveBytesToFile(root, groupId, fileId+"._meta", metaBytes)
+	metaBytes, err := json.Marshal(metadata)
 	if err != nil {
+		DeleteFile(root, dir, fileId+extension)
 		errs <- err
 		return
 	}
 
-	err = SaveMultipartToFile(root, groupId, fileId+extension, file)
+	err = SaveBytesToFile(root, dir, fileId+"._meta", metaBytes)
 	if err != nil {
+		DeleteFile(root, dir, fileId+extension)
 		errs <- err
 		return
 	}
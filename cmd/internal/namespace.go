@@ -0,0 +1,76 @@
+package internal
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	DefaultNamespace = "default"     // Namespace of requests that do not name one
+	NamespaceHeader  = "X-Namespace" // Header naming the namespace of a request
+	NamespaceParam   = "namespace"   // Query parameter naming the namespace of a request
+
+	// namespaceDirPrefix marks namespace folders in the vault root, it cannot start a group id
+	namespaceDirPrefix = "@"
+)
+
+// ErrInvalidNamespace is returned when a request names an invalid or ambiguous namespace
+var ErrInvalidNamespace = errors.New("invalid namespace")
+
+var namespacePattern = regexp.MustCompile(`^[a-zA-Z0-9_\-]+$`) // only allow alphanumeric, underscore and hyphen
+
+// RequestNamespace returns the namespace named by the header or the query of a request
+func RequestNamespace(r *http.Request) (string, error) {
+	header := r.Header.Get(NamespaceHeader)
+	param := r.URL.Query().Get(NamespaceParam)
+	if header != "" && param != "" && header != param {
+		return "", ErrInvalidNamespace
+	}
+
+	namespace := header
+	if namespace == "" {
+		namespace = param
+	}
+	if namespace == "" {
+		return DefaultNamespace, nil
+	}
+
+	return namespace, ValidateNamespace(namespace)
+}
+
+// ValidateNamespace checks that a namespace name can be used
+func ValidateNamespace(namespace string) error {
+	if !namespacePattern.MatchString(namespace) {
+		return ErrInvalidNamespace
+	}
+	return nil
+}
+
+// QueryNamespace returns the namespace of a query, the default namespace when it has none
+func QueryNamespace(query url.Values) string {
+	if namespace := query.Get(NamespaceParam); namespace != "" {
+		return namespace
+	}
+	return DefaultNamespace
+}
+
+// GroupDir returns the folder of a group relative to the vault root
+//
+// Groups of the default namespace live directly in the root, so that vaults
+// created before namespaces keep their layout. Other namespaces have a folder
+// named after them with a prefix that group ids cannot start with.
+func GroupDir(namespace, groupId string) string {
+	if namespace == DefaultNamespace || namespace == "" {
+		return groupId
+	}
+	return filepath.Join(namespaceDirPrefix+namespace, groupId)
+}
+
+// NamespaceOfDir returns the namespace of a folder in the vault root, and whether it is a namespace folder
+func NamespaceOfDir(name string) (string, bool) {
+	return strings.CutPrefix(name, namespaceDirPrefix)
+}
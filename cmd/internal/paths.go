@@ -0,0 +1,87 @@
+package internal
+
+import (
+	"errors"
+	"sort"
+	"strings"
+)
+
+// Query parameters of the virtual paths of elements
+const (
+	FolderParam    = "folder"    // Virtual folder the uploaded files are stored in
+	PrefixParam    = "prefix"    // Path prefix of the listed elements
+	DelimiterParam = "delimiter" // Groups the paths continuing past the prefix into folders up to the delimiter
+)
+
+// ErrInvalidPath is returned when a virtual path is absolute, climbs with ".." or holds control characters
+var ErrInvalidPath = errors.New("invalid path")
+
+// ElementPath returns the virtual path of an element, its file name when it was stored without folder
+func ElementPath(attributes map[string]string) string {
+	if elementPath := attributes["path"]; elementPath != "" {
+		return elementPath
+	}
+	return attributes["fileName"]
+}
+
+// JoinPath returns the virtual path of a file stored in a folder, the folder may be empty
+func JoinPath(folder, name string) (string, error) {
+	if folder != "" {
+		name = folder + "/" + name
+	}
+	joined, err := ArchiveEntryPath(name)
+	if err != nil {
+		return "", ErrInvalidPath
+	}
+	return joined, nil
+}
+
+// FilterPrefix returns the records whose virtual path starts with prefix
+func FilterPrefix(records []Record, prefix string) []Record {
+	if prefix == "" {
+		return records
+	}
+	filtered := make([]Record, 0, len(records))
+	for _, record := range records {
+		if strings.HasPrefix(ElementPath(record.Attributes), prefix) {
+			filtered = append(filtered, record)
+		}
+	}
+	return filtered
+}
+
+// FolderListing is the content of a virtual folder of a group
+type FolderListing struct {
+	Prefix    string     `json:"prefix"`          // Prefix of the listed paths
+	Delimiter string     `json:"delimiter"`       // Delimiter ending the folders
+	Elements  []Record   `json:"elements"`        // Elements whose path holds no delimiter past the prefix, sorted by path
+	Folders   []string   `json:"folders"`         // Distinct prefixes of the other paths up to and including the delimiter, sorted
+	Group     *GroupMeta `json:"group,omitempty"` // Metadata of the group, when requested
+}
+
+// ListFolder browses records as a tree of folders, like a file system listing of prefix
+func ListFolder(records []Record, prefix, delimiter string) FolderListing {
+	listing := FolderListing{Prefix: prefix, Delimiter: delimiter, Elements: make([]Record, 0), Folders: make([]string, 0)}
+	folders := make(map[string]bool)
+	for _, record := range FilterPrefix(records, prefix) {
+		rest := strings.TrimPrefix(ElementPath(record.Attributes), prefix)
+		if i := strings.Index(rest, delimiter); i >= 0 {
+			folders[prefix+rest[:i+len(delimiter)]] = true
+			continue
+		}
+		listing.Elements = append(listing.Elements, record)
+	}
+
+	for folder := range folders {
+		listing.Folders = append(listing.Folders, folder)
+	}
+	sort.Strings(listing.Folders)
+	sort.Slice(listing.Elements, func(i, j int) bool {
+		pi, pj := ElementPath(listing.Elements[i].Attributes), ElementPath(listing.Elements[j].Attributes)
+		if pi != pj {
+			return pi < pj
+		}
+		return listing.Elements[i].Id < listing.Elements[j].Id
+	})
+	return listing
+}
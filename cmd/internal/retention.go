@@ -0,0 +1,151 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Query parameters setting the retention of groups and elements
+const (
+	RetainUntilParam   = "retainUntil"   // RFC 3339 time until which elements cannot be deleted or overwritten
+	RetentionModeParam = "retentionMode" // governance or compliance
+	LegalHoldParam     = "legalHold"     // "true" or "false"
+)
+
+// Retention modes
+const (
+	RetentionGovernance = "governance" // The retention date can be moved by clients allowed to manage retention
+	RetentionCompliance = "compliance" // The retention date can only be extended until it has passed
+)
+
+// RollbackParam is the query parameter carrying the token of a gatekeeper request storing elements
+//
+// A purge carrying the same token removes the elements stored by the request
+// despite their retention, so that a write missing its quorum is rolled back.
+const RollbackParam = "rollback"
+
+// RetentionFile is the file of a group folder holding the retention of the group
+const RetentionFile = "._retention"
+
+// ErrRetentionLocked is returned when a retention lock or a legal hold forbids a change
+var ErrRetentionLocked = errors.New("locked by retention")
+
+// ErrInvalidRetention is returned when retention parameters are malformed
+var ErrInvalidRetention = errors.New("retainUntil must be an RFC 3339 time, retentionMode governance or compliance and legalHold true or false")
+
+// Retention is the write-once lock of a group or an element
+//
+// Values are strings so that they are stored as is in the attributes of the index.
+type Retention struct {
+	RetainUntil   string `json:"retainUntil,omitempty"`   // Unix milliseconds until which deletes and overwrites are refused
+	RetentionMode string `json:"retentionMode,omitempty"` // governance or compliance
+	LegalHold     string `json:"legalHold,omitempty"`     // "true" refuses deletes and overwrites until the hold is released
+}
+
+// RetentionOf returns the retention stored in the attributes of an element
+func RetentionOf(attributes map[string]string) Retention {
+	return Retention{
+		RetainUntil:   attributes["retainUntil"],
+		RetentionMode: attributes["retentionMode"],
+		LegalHold:     attributes["legalHold"],
+	}
+}
+
+// retainUntil returns the retention date in unix milliseconds, 0 without retention
+func (r Retention) retainUntil() int64 {
+	until, _ := strconv.ParseInt(r.RetainUntil, 10, 64)
+	return until
+}
+
+// Retained reports whether the retention date is still ahead at now
+func (r Retention) Retained(now time.Time) bool {
+	return r.retainUntil() > now.UnixMilli()
+}
+
+// Locked reports whether the retention date or a legal hold forbids deletes and overwrites at now
+func (r Retention) Locked(now time.Time) bool {
+	return r.LegalHold == "true" || r.Retained(now)
+}
+
+// Validate checks that a retention holds a date in unix milliseconds, a known mode and a legal hold of true or false
+func (r Retention) Validate() error {
+	if _, err := strconv.ParseInt(r.RetainUntil, 10, 64); r.RetainUntil != "" && err != nil {
+		return ErrInvalidRetention
+	}
+	switch r.RetentionMode {
+	case "", RetentionGovernance, RetentionCompliance:
+	default:
+		return ErrInvalidRetention
+	}
+	switch r.LegalHold {
+	case "", "true", "false":
+	default:
+		return ErrInvalidRetention
+	}
+	return nil
+}
+
+// Update applies a change to a retention, empty fields of the change are kept
+//
+// A legal hold of "false" releases the hold. While a compliance retention is
+// in effect, its date can only be extended and its mode cannot change.
+func (r Retention) Update(change Retention, now time.Time) (Retention, error) {
+	updated := r
+	if change.RetainUntil != "" {
+		updated.RetainUntil = change.RetainUntil
+	}
+	if change.RetentionMode != "" {
+		updated.RetentionMode = change.RetentionMode
+	}
+	switch change.LegalHold {
+	case "true":
+		updated.LegalHold = "true"
+	case "false":
+		updated.LegalHold = ""
+	}
+	if updated.RetainUntil != "" && updated.RetentionMode == "" {
+		updated.RetentionMode = RetentionGovernance
+	}
+
+	if r.RetentionMode == RetentionCompliance && r.Retained(now) {
+		if updated.RetentionMode != RetentionCompliance {
+			return r, fmt.Errorf("%w: compliance mode cannot be changed before %s", ErrRetentionLocked, time.UnixMilli(r.retainUntil()).UTC().Format(time.RFC3339))
+		}
+		if updated.retainUntil() < r.retainUntil() {
+			return r, fmt.Errorf("%w: compliance retention cannot be shortened before %s", ErrRetentionLocked, time.UnixMilli(r.retainUntil()).UTC().Format(time.RFC3339))
+		}
+	}
+
+	return updated, nil
+}
+
+// ParseRetention returns the retention change requested by query parameters
+func ParseRetention(query url.Values) (Retention, error) {
+	var change Retention
+	if value := query.Get(RetainUntilParam); value != "" {
+		until, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return Retention{}, ErrInvalidRetention
+		}
+		change.RetainUntil = strconv.FormatInt(until.UnixMilli(), 10)
+	}
+
+	switch mode := query.Get(RetentionModeParam); mode {
+	case "", RetentionGovernance, RetentionCompliance:
+		change.RetentionMode = mode
+	default:
+		return Retention{}, ErrInvalidRetention
+	}
+
+	switch hold := query.Get(LegalHoldParam); hold {
+	case "", "true", "false":
+		change.LegalHold = hold
+	default:
+		return Retention{}, ErrInvalidRetention
+	}
+
+	return change, nil
+}
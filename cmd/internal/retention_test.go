@@ -0,0 +1,126 @@
+package internal
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestRetentionUpdate(t *testing.T) {
+	now := time.Now()
+	past := strconv.FormatInt(now.Add(-time.Hour).UnixMilli(), 10)
+	soon := strconv.FormatInt(now.Add(time.Hour).UnixMilli(), 10)
+	later := strconv.FormatInt(now.Add(2*time.Hour).UnixMilli(), 10)
+
+	tests := []struct {
+		name    string
+		current Retention
+		change  Retention
+		want    Retention
+		err     error
+	}{
+		{
+			name:   "date defaults to governance",
+			change: Retention{RetainUntil: soon},
+			want:   Retention{RetainUntil: soon, RetentionMode: RetentionGovernance},
+		},
+		{
+			name:    "empty change keeps the retention",
+			current: Retention{RetainUntil: soon, RetentionMode: RetentionCompliance, LegalHold: "true"},
+			want:    Retention{RetainUntil: soon, RetentionMode: RetentionCompliance, LegalHold: "true"},
+		},
+		{
+			name:   "legal hold is set",
+			change: Retention{LegalHold: "true"},
+			want:   Retention{LegalHold: "true"},
+		},
+		{
+			name:    "legal hold is released",
+			current: Retention{LegalHold: "true"},
+			change:  Retention{LegalHold: "false"},
+			want:    Retention{},
+		},
+		{
+			name:    "governance date is shortened",
+			current: Retention{RetainUntil: later, RetentionMode: RetentionGovernance},
+			change:  Retention{RetainUntil: soon},
+			want:    Retention{RetainUntil: soon, RetentionMode: RetentionGovernance},
+		},
+		{
+			name:    "governance turns into compliance",
+			current: Retention{RetainUntil: soon, RetentionMode: RetentionGovernance},
+			change:  Retention{RetentionMode: RetentionCompliance},
+			want:    Retention{RetainUntil: soon, RetentionMode: RetentionCompliance},
+		},
+		{
+			name:    "compliance date is extended",
+			current: Retention{RetainUntil: soon, RetentionMode: RetentionCompliance},
+			change:  Retention{RetainUntil: later},
+			want:    Retention{RetainUntil: later, RetentionMode: RetentionCompliance},
+		},
+		{
+			name:    "compliance date cannot be shortened",
+			current: Retention{RetainUntil: later, RetentionMode: RetentionCompliance},
+			change:  Retention{RetainUntil: soon},
+			want:    Retention{RetainUntil: later, RetentionMode: RetentionCompliance},
+			err:     ErrRetentionLocked,
+		},
+		{
+			name:    "compliance mode cannot change",
+			current: Retention{RetainUntil: soon, RetentionMode: RetentionCompliance},
+			change:  Retention{RetentionMode: RetentionGovernance},
+			want:    Retention{RetainUntil: soon, RetentionMode: RetentionCompliance},
+			err:     ErrRetentionLocked,
+		},
+		{
+			name:    "expired compliance can change",
+			current: Retention{RetainUntil: past, RetentionMode: RetentionCompliance},
+			change:  Retention{RetainUntil: soon, RetentionMode: RetentionGovernance},
+			want:    Retention{RetainUntil: soon, RetentionMode: RetentionGovernance},
+		},
+		{
+			name:    "legal hold is kept under compliance",
+			current: Retention{RetainUntil: soon, RetentionMode: RetentionCompliance},
+			change:  Retention{LegalHold: "true"},
+			want:    Retention{RetainUntil: soon, RetentionMode: RetentionCompliance, LegalHold: "true"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := test.current.Update(test.change, now)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("error %v, want %v", err, test.err)
+			}
+			if got != test.want {
+				t.Errorf("retention %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestRetentionValidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		retention Retention
+		err       error
+	}{
+		{name: "no retention"},
+		{name: "governance date", retention: Retention{RetainUntil: "1700000000000", RetentionMode: RetentionGovernance}},
+		{name: "compliance date with hold", retention: Retention{RetainUntil: "1700000000000", RetentionMode: RetentionCompliance, LegalHold: "true"}},
+		{name: "released hold", retention: Retention{LegalHold: "false"}},
+		{name: "date not in milliseconds", retention: Retention{RetainUntil: "2030-01-01T00:00:00Z"}, err: ErrInvalidRetention},
+		{name: "unknown mode", retention: Retention{RetainUntil: "1700000000000", RetentionMode: "forever"}, err: ErrInvalidRetention},
+		{name: "unknown hold", retention: Retention{LegalHold: "yes"}, err: ErrInvalidRetention},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.retention.Validate()
+			if !errors.Is(err, test.err) {
+				t.Errorf("error %v, want %v", err, test.err)
+			}
+		})
+	}
+}
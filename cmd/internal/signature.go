@@ -0,0 +1,110 @@
+package internal
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	SignatureHeader = "X-Datavault-Signature" // Header carrying the HMAC of a gatekeeper request
+	TimestampHeader = "X-Datavault-Timestamp" // Header carrying the signing time in unix seconds
+	NonceHeader     = "X-Datavault-Nonce"     // Header carrying a random value making every signature unique
+)
+
+var (
+	ErrMissingSignature  = errors.New("request is not signed")
+	ErrInvalidSignature  = errors.New("request signature is invalid")
+	ErrExpiredSignature  = errors.New("request signature has expired")
+	ErrReplayedSignature = errors.New("request signature was already used")
+)
+
+// requestSignature computes the HMAC-SHA256 of the method, path, query, timestamp and nonce of a request
+//
+// The body is not signed so that uploads can be streamed, signatures are
+// single-use instead so that a captured request cannot be sent again.
+func requestSignature(secret []byte, r *http.Request, timestamp, nonce string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(r.Method + "\n" + r.URL.EscapedPath() + "\n" + r.URL.RawQuery + "\n" + timestamp + "\n" + nonce))
+	return mac.Sum(nil)
+}
+
+// SignRequest signs a request with a shared secret, every call gives the request a new signature
+func SignRequest(r *http.Request, secret string) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	random := make([]byte, 16)
+	rand.Read(random)
+	nonce := hex.EncodeToString(random)
+	r.Header.Set(TimestampHeader, timestamp)
+	r.Header.Set(NonceHeader, nonce)
+	r.Header.Set(SignatureHeader, hex.EncodeToString(requestSignature([]byte(secret), r, timestamp, nonce)))
+}
+
+// VerifyRequest checks the signature of a request and that it was signed within maxAge
+//
+// Signatures are single-use when seen is not nil, it records the verified
+// signatures until they expire.
+func VerifyRequest(r *http.Request, secret string, maxAge time.Duration, seen *SignatureCache) error {
+	timestamp := r.Header.Get(TimestampHeader)
+	nonce := r.Header.Get(NonceHeader)
+	signature, err := hex.DecodeString(r.Header.Get(SignatureHeader))
+	if timestamp == "" || nonce == "" || len(signature) == 0 {
+		return ErrMissingSignature
+	}
+	if err != nil || !hmac.Equal(signature, requestSignature([]byte(secret), r, timestamp, nonce)) {
+		return ErrInvalidSignature
+	}
+
+	signed, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	age := time.Since(time.Unix(signed, 0))
+	if age > maxAge || age < -maxAge {
+		return ErrExpiredSignature
+	}
+
+	if seen != nil {
+		return seen.use(string(signature), time.Unix(signed, 0).Add(maxAge))
+	}
+	return nil
+}
+
+// SignatureCache records the request signatures verified until they expire
+type SignatureCache struct {
+	mu      sync.Mutex
+	expires map[string]time.Time // Expiry of the verified signatures
+	limit   int                  // Size of the cache at which expired signatures are dropped
+}
+
+// use records a signature until it expires, or returns ErrReplayedSignature when it is already recorded
+func (c *SignatureCache) use(signature string, expires time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if c.expires == nil {
+		c.expires = make(map[string]time.Time)
+	}
+	if expiry, ok := c.expires[signature]; ok && now.Before(expiry) {
+		return ErrReplayedSignature
+	}
+
+	// Expired signatures are dropped as the cache grows, their timestamp is refused anyway
+	if len(c.expires) >= c.limit {
+		for recorded, expiry := range c.expires {
+			if !now.Before(expiry) {
+				delete(c.expires, recorded)
+			}
+		}
+		c.limit = max(2*len(c.expires), 1024)
+	}
+	c.expires[signature] = expires
+	return nil
+}
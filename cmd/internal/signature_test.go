@@ -0,0 +1,77 @@
+package internal
+
+import (
+	"encoding/hex"
+	"errors"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestVerifyRequest(t *testing.T) {
+	seen := &SignatureCache{}
+
+	r := httptest.NewRequest("GET", "/file?groupId=g&id=a", nil)
+	SignRequest(r, "secret")
+	if err := VerifyRequest(r, "secret", time.Minute, seen); err != nil {
+		t.Fatalf("signed request refused: %v", err)
+	}
+	if err := VerifyRequest(r, "secret", time.Minute, seen); !errors.Is(err, ErrReplayedSignature) {
+		t.Errorf("replayed request: error %v, want %v", err, ErrReplayedSignature)
+	}
+
+	// The same request signed again gets a new signature
+	SignRequest(r, "secret")
+	if err := VerifyRequest(r, "secret", time.Minute, seen); err != nil {
+		t.Errorf("request signed again refused: %v", err)
+	}
+
+	r = httptest.NewRequest("GET", "/file?groupId=g&id=a", nil)
+	if err := VerifyRequest(r, "secret", time.Minute, seen); !errors.Is(err, ErrMissingSignature) {
+		t.Errorf("unsigned request: error %v, want %v", err, ErrMissingSignature)
+	}
+
+	SignRequest(r, "secret")
+	r.URL.RawQuery = "groupId=g&id=b"
+	if err := VerifyRequest(r, "secret", time.Minute, seen); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("altered request: error %v, want %v", err, ErrInvalidSignature)
+	}
+
+	SignRequest(r, "other")
+	if err := VerifyRequest(r, "secret", time.Minute, seen); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("request signed with another secret: error %v, want %v", err, ErrInvalidSignature)
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	r.Header.Set(TimestampHeader, timestamp)
+	r.Header.Set(SignatureHeader, hex.EncodeToString(requestSignature([]byte("secret"), r, timestamp, r.Header.Get(NonceHeader))))
+	if err := VerifyRequest(r, "secret", time.Minute, seen); !errors.Is(err, ErrExpiredSignature) {
+		t.Errorf("old request: error %v, want %v", err, ErrExpiredSignature)
+	}
+}
+
+func TestSignatureCache(t *testing.T) {
+	cache := &SignatureCache{}
+	now := time.Now()
+
+	if err := cache.use("a", now.Add(time.Minute)); err != nil {
+		t.Fatalf("new signature refused: %v", err)
+	}
+	if err := cache.use("a", now.Add(time.Minute)); !errors.Is(err, ErrReplayedSignature) {
+		t.Errorf("used signature: error %v, want %v", err, ErrReplayedSignature)
+	}
+
+	// Expired signatures are dropped once the cache grows
+	cache.use("b", now.Add(-time.Second))
+	for i := 0; len(cache.expires) < 1024; i++ {
+		cache.use(strconv.Itoa(i), now.Add(time.Minute))
+	}
+	cache.use("c", now.Add(time.Minute))
+	if _, ok := cache.expires["b"]; ok {
+		t.Errorf("expired signature was kept")
+	}
+	if _, ok := cache.expires["a"]; !ok {
+		t.Errorf("valid signature was dropped")
+	}
+}
@@ -0,0 +1,214 @@
+package internal
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// TLSConfig is the TLS configuration of a listener or of the client of internal hops
+type TLSConfig struct {
+	Cert           string `json:"cert"`            // PEM certificate file, presented to peers
+	Key            string `json:"key"`             // PEM private key file of the certificate
+	CA             string `json:"ca"`              // PEM bundle of the authorities peers are verified against, empty uses the system roots
+	ClientAuth     bool   `json:"client_auth"`     // Require clients to present a certificate signed by the CA (listeners only)
+	ServerName     string `json:"server_name"`     // Name expected in server certificates instead of the host (clients only)
+	MinVersion     string `json:"min_version"`     // Minimum TLS version, "1.2" (default) or "1.3"
+	ReloadInterval int    `json:"reload_interval"` // Interval between checks for renewed certificates in seconds, defaults to 30
+}
+
+// minVersion parses the minimum TLS version
+func (c *TLSConfig) minVersion() (uint16, error) {
+	switch c.MinVersion {
+	case "", "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("unsupported TLS version %q", c.MinVersion)
+	}
+}
+
+// certPool reads the CA bundle, nil when none is configured
+func (c *TLSConfig) certPool() (*x509.CertPool, error) {
+	if c.CA == "" {
+		return nil, nil
+	}
+
+	pemBytes, err := os.ReadFile(c.CA)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pemBytes) {
+		return nil, fmt.Errorf("no certificate found in %s", c.CA)
+	}
+	return pool, nil
+}
+
+// ServerConfig builds the TLS configuration of a listener, its certificate is reloaded when renewed
+func (c *TLSConfig) ServerConfig() (*tls.Config, error) {
+	if c.Cert == "" || c.Key == "" {
+		return nil, errors.New("TLS listeners need a certificate and a key")
+	}
+
+	version, err := c.minVersion()
+	if err != nil {
+		return nil, err
+	}
+	pool, err := c.certPool()
+	if err != nil {
+		return nil, err
+	}
+	reloader, err := NewCertReloader(c.Cert, c.Key, c.ReloadInterval)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{
+		MinVersion:     version,
+		GetCertificate: reloader.GetCertificate,
+		ClientCAs:      pool,
+	}
+	if c.ClientAuth {
+		if pool == nil {
+			return nil, errors.New("client authentication needs a CA")
+		}
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return config, nil
+}
+
+// ClientConfig builds the TLS configuration of a client, presenting its certificate when one is configured
+func (c *TLSConfig) ClientConfig() (*tls.Config, error) {
+	version, err := c.minVersion()
+	if err != nil {
+		return nil, err
+	}
+	pool, err := c.certPool()
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{
+		MinVersion: version,
+		RootCAs:    pool,
+		ServerName: c.ServerName,
+	}
+	if c.Cert != "" || c.Key != "" {
+		reloader, err := NewCertReloader(c.Cert, c.Key, c.ReloadInterval)
+		if err != nil {
+			return nil, err
+		}
+		config.GetClientCertificate = reloader.GetClientCertificate
+	}
+
+	return config, nil
+}
+
+// CertReloader serves a certificate and reloads it when its files change
+type CertReloader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time // Latest modification time of the files when they were loaded
+}
+
+// NewCertReloader loads a certificate and starts watching its files
+func NewCertReloader(certFile, keyFile string, interval int) (*CertReloader, error) {
+	r := &CertReloader{certFile: certFile, keyFile: keyFile}
+	err := r.reload()
+	if err != nil {
+		return nil, err
+	}
+
+	if interval <= 0 {
+		interval = 30
+	}
+	go r.watch(time.Duration(interval) * time.Second)
+
+	return r, nil
+}
+
+// filesModTime returns the latest modification time of the certificate and key files
+func (r *CertReloader) filesModTime() (time.Time, error) {
+	var latest time.Time
+	for _, name := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(name)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// reload reads the certificate and key files
+func (r *CertReloader) reload() error {
+	modTime, err := r.filesModTime()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cert = &cert
+	r.modTime = modTime
+	return nil
+}
+
+// watch reloads the certificate whenever its files are modified, a broken pair keeps the previous certificate
+func (r *CertReloader) watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		modTime, err := r.filesModTime()
+		if err != nil {
+			log.Printf("Error checking certificate %s: %v\n", r.certFile, err)
+			continue
+		}
+
+		r.mu.RLock()
+		changed := modTime.After(r.modTime)
+		r.mu.RUnlock()
+		if !changed {
+			continue
+		}
+
+		err = r.reload()
+		if err != nil {
+			log.Printf("Error reloading certificate %s, keeping the previous one: %v\n", r.certFile, err)
+			continue
+		}
+		log.Printf("Reloaded certificate %s\n", r.certFile)
+	}
+}
+
+// GetCertificate returns the current certificate to a TLS client
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// GetClientCertificate returns the current certificate to a TLS server
+func (r *CertReloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
@@ -0,0 +1,28 @@
+package internal
+
+// TrashDir is the folder of the vault root holding deleted groups and elements, group ids cannot start with a dot
+const TrashDir = "._trash"
+
+// Kinds of trashed items
+const (
+	TrashGroup   = "group"   // A whole group was deleted
+	TrashElement = "element" // A single element was deleted
+)
+
+// TrashItem is a deleted group or element kept in the trash until its retention expires
+type TrashItem struct {
+	Id        string   `json:"id"`        // Trash item identifier, shared by the replicas of the deleted group
+	Kind      string   `json:"kind"`      // What was deleted: group or element
+	Namespace string   `json:"namespace"` // Namespace of the group
+	GroupId   string   `json:"groupId"`   // Group of the deleted elements
+	Elements  []string `json:"elements"`  // Ids of the deleted elements
+	Size      int64    `json:"size"`      // Total size of the deleted elements in bytes
+	DeletedAt int64    `json:"deletedAt"` // Deletion time in unix milliseconds
+	PurgeAt   int64    `json:"purgeAt"`   // Time the item is deleted for good in unix milliseconds
+}
+
+// Query parameters of deletes
+const (
+	TrashParam = "trashId" // Id of the trash item a delete creates, shared by the replicas
+	PurgeParam = "purge"   // "true" deletes for good instead of moving to the trash
+)
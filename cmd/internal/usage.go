@@ -0,0 +1,18 @@
+package internal
+
+// Usage is the storage held by a group or a namespace
+type Usage struct {
+	Bytes int64 `json:"bytes"` // Total size of the elements in bytes
+	Files int64 `json:"files"` // Number of elements
+}
+
+// Add returns the sum of two usages
+func (u Usage) Add(other Usage) Usage {
+	return Usage{Bytes: u.Bytes + other.Bytes, Files: u.Files + other.Files}
+}
+
+// NamespaceUsage is the storage held by a namespace and by each of its groups
+type NamespaceUsage struct {
+	Usage  Usage            `json:"usage"`  // Usage of the whole namespace
+	Groups map[string]Usage `json:"groups"` // Usage of each group of the namespace
+}
@@ -0,0 +1,45 @@
+package internal
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"unicode"
+)
+
+// Query parameters addressing the versions of an element
+const (
+	KeyParam     = "key"       // Logical key shared by the versions of an element
+	VersionParam = "versionId" // Element id of one version of a key
+)
+
+// MaxKeyLength is the longest logical key in bytes
+const MaxKeyLength = 1024
+
+// ErrInvalidKey is returned when a logical key is empty, too long or holds control characters
+var ErrInvalidKey = errors.New("invalid key")
+
+// ValidateKey checks that a logical key can be stored
+func ValidateKey(key string) error {
+	if key == "" || len(key) > MaxKeyLength {
+		return ErrInvalidKey
+	}
+	for _, r := range key {
+		if unicode.IsControl(r) {
+			return ErrInvalidKey
+		}
+	}
+	return nil
+}
+
+// SortVersions sorts the versions of a key newest first, by received time then by id
+func SortVersions(records []Record) {
+	sort.Slice(records, func(i, j int) bool {
+		ti, _ := strconv.ParseInt(records[i].Attributes["receivedTime"], 10, 64)
+		tj, _ := strconv.ParseInt(records[j].Attributes["receivedTime"], 10, 64)
+		if ti != tj {
+			return ti > tj
+		}
+		return records[i].Id > records[j].Id
+	})
+}
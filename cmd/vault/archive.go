@@ -0,0 +1,200 @@
+package vault
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"datavault/cmd/internal"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// ArchiveSelection returns the live elements of a group to download, oldest first
+//
+// The elements can be limited to a list of ids and to the records matching
+// every filter attribute. Only the newest version of each key is selected,
+// unless allVersions is set.
+func ArchiveSelection(namespace, groupId string, elementIds []string, filters map[string]string, allVersions bool) []internal.Record {
+	query := map[string]string{"namespace": namespace, "groupId": groupId}
+	for attribute, value := range filters {
+		if _, ok := query[attribute]; !ok {
+			query[attribute] = value
+		}
+	}
+
+	now := time.Now()
+	newest := make(map[string]internal.Record)
+	selected := make([]internal.Record, 0)
+	for _, record := range VaultConfig.Index.SearchEvery(query) {
+		if Expired(record, now) || (len(elementIds) > 0 && !slices.Contains(elementIds, record.Id)) {
+			continue
+		}
+		key := record.Attributes["key"]
+		if key == "" || allVersions {
+			selected = append(selected, record)
+			continue
+		}
+		if current, ok := newest[key]; ok {
+			versions := []internal.Record{current, record}
+			internal.SortVersions(versions)
+			record = versions[0]
+		}
+		newest[key] = record
+	}
+	for _, record := range newest {
+		selected = append(selected, record)
+	}
+
+	sort.Slice(selected, func(i, j int) bool {
+		ti, _ := strconv.ParseInt(selected[i].Attributes["receivedTime"], 10, 64)
+		tj, _ := strconv.ParseInt(selected[j].Attributes["receivedTime"], 10, 64)
+		if ti != tj {
+			return ti < tj
+		}
+		return selected[i].Id < selected[j].Id
+	})
+	return selected
+}
+
+// archiveWriter adds files to an archive being streamed
+type archiveWriter interface {
+	Add(name string, modified time.Time, size int64, content io.Reader) error
+	Close() error
+}
+
+// zipArchive streams a ZIP archive
+type zipArchive struct {
+	writer *zip.Writer
+}
+
+// Add deflates a file into the archive
+func (a zipArchive) Add(name string, modified time.Time, size int64, content io.Reader) error {
+	entry, err := a.writer.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modified})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(entry, content)
+	return err
+}
+
+// Close writes the central directory of the archive
+func (a zipArchive) Close() error {
+	return a.writer.Close()
+}
+
+// tarArchive streams a tar archive
+type tarArchive struct {
+	writer *tar.Writer
+}
+
+// Add copies a file into the archive
+func (a tarArchive) Add(name string, modified time.Time, size int64, content io.Reader) error {
+	err := a.writer.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0644, Size: size, ModTime: modified, Format: tar.FormatPAX})
+	if err != nil {
+		return err
+	}
+	_, err = io.CopyN(a.writer, content, size)
+	return err
+}
+
+// Close writes the trailer of the archive
+func (a tarArchive) Close() error {
+	return a.writer.Close()
+}
+
+// WriteArchive streams the files of records of a group as an archive, named after their original file names
+//
+// Elements extracted from an uploaded archive keep their relative path.
+// Elements deleted since they were selected are skipped.
+func WriteArchive(w io.Writer, format, namespace, groupId string, records []internal.Record) error {
+	var archive archiveWriter = zipArchive{writer: zip.NewWriter(w)}
+	if format == internal.ArchiveTar {
+		archive = tarArchive{writer: tar.NewWriter(w)}
+	}
+
+	names := internal.NewArchiveNames()
+	dir := filepath.Join(VaultConfig.Root, internal.GroupDir(namespace, groupId))
+	for _, record := range records {
+		file, err := os.Open(filepath.Join(dir, record.Id+record.Attributes["fileExtension"]))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		info, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return err
+		}
+
+		received, _ := strconv.ParseInt(record.Attributes["receivedTime"], 10, 64)
+		name := record.Attributes["path"]
+		if name == "" {
+			name = record.Attributes["fileName"]
+		}
+		name = names.Name(name, record.Id+record.Attributes["fileExtension"])
+		err = archive.Add(name, time.UnixMilli(received), info.Size(), file)
+		file.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return archive.Close()
+}
+
+// HandlerGroupArchive streams the elements of a group as a ZIP or tar archive built from the index
+//
+// Elements are selected by id with repeated elementId parameters, by
+// attribute with repeated filter=attribute:value parameters and by path
+// prefix.
+func HandlerGroupArchive(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	groupId := query.Get("groupId")
+	if !validateString(groupId) {
+		http.Error(w, "Invalid Group ID", http.StatusBadRequest)
+		return
+	}
+	namespace, err := internal.RequestNamespace(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	format := query.Get(internal.ArchiveFormatParam)
+	if format == "" {
+		format = internal.ArchiveZip
+	}
+	if format != internal.ArchiveZip && format != internal.ArchiveTar {
+		http.Error(w, internal.ErrInvalidArchive.Error(), http.StatusBadRequest)
+		return
+	}
+	filters, err := internal.ParseArchiveFilters(query[internal.ArchiveFilterParam])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	records := ArchiveSelection(namespace, groupId, query["elementId"], filters, query.Get(internal.ArchiveVersionsParam) == "all")
+	records = internal.FilterPrefix(records, query.Get(internal.PrefixParam))
+	if len(records) == 0 {
+		http.Error(w, "no element matches", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", internal.ArchiveContentType(format))
+	w.Header().Set("Content-Disposition", `attachment; filename="`+groupId+"."+format+`"`)
+	err = WriteArchive(w, format, namespace, groupId, records)
+	if err != nil {
+		// The archive is partly sent, the connection is aborted so that the client sees it truncated
+		log.Printf("Error streaming archive of group %s: %v\n", groupId, err)
+		panic(http.ErrAbortHandler)
+	}
+}
@@ -0,0 +1,61 @@
+package vault
+
+import (
+	"datavault/cmd/internal"
+	"net/http"
+	"time"
+)
+
+// usedSignatures records the gatekeeper signatures accepted by the vault
+var usedSignatures internal.SignatureCache
+
+// RequireGatekeeper rejects requests that are not signed by a trusted gatekeeper
+//
+// Signatures are only checked when a gatekeeper secret is configured. The
+// health check stays open so that the vault can be monitored without the secret.
+// A signature is accepted once, a replayed request is rejected until it expires.
+func RequireGatekeeper(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if VaultConfig.GatekeeperSecret == "" || r.URL.Path == "/ping" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		maxAge := time.Duration(VaultConfig.SignatureMaxAge) * time.Second
+		err := internal.VerifyRequest(r, VaultConfig.GatekeeperSecret, maxAge, &usedSignatures)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		internal.AuditPrincipal(r, "gatekeeper")
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequirePresignedURL rejects requests carrying a pre-signed URL that does not match their scope or has expired
+//
+// Pre-signed URLs are minted by the gatekeeper and forwarded with the request,
+// they are only checked when the URL secret is configured.
+func RequirePresignedURL(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if VaultConfig.URLSecret != "" && internal.IsPresigned(r) {
+			// The rollback token and preset marker are added by the gatekeeper and are not part of the signed URL
+			signed := r
+			if query := r.URL.Query(); query.Has(internal.RollbackParam) || query.Has(internal.PresetParam) {
+				query.Del(internal.RollbackParam)
+				query.Del(internal.PresetParam)
+				signed = r.Clone(r.Context())
+				signed.URL.RawQuery = query.Encode()
+			}
+			err := internal.VerifyPresignedURL(signed, VaultConfig.URLSecret)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
+			}
+			internal.AuditPrincipal(r, "presigned")
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
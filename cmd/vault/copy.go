@@ -0,0 +1,307 @@
+package vault
+
+import (
+	"datavault/cmd/internal"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Errors of copies
+var (
+	ErrInvalidCopy  = errors.New("invalid copy request")          // The copy request is malformed
+	ErrPeerTransfer = errors.New("transfer from vault failed")    // The vault holding the source could not be read
+	ErrUnknownPeer  = errors.New("vault is not a cluster member") // The source of a transfer is not a known vault
+)
+
+var (
+	peerClientOnce sync.Once
+	peerClient     *http.Client // Client of the transfers from other vaults
+	peerClientErr  error
+)
+
+// peerHTTPClient returns the client of the transfers from other vaults, configured from the peer TLS settings
+func peerHTTPClient() (*http.Client, error) {
+	peerClientOnce.Do(func() {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		if VaultConfig.PeerTLS != nil {
+			transport.TLSClientConfig, peerClientErr = VaultConfig.PeerTLS.ClientConfig()
+		}
+		peerClient = &http.Client{Transport: transport, Timeout: time.Duration(VaultConfig.PeerTimeout) * time.Second}
+	})
+	return peerClient, peerClientErr
+}
+
+// knownPeer reports whether an address belongs to a vault of the cluster
+//
+// Vaults are known from the configured peers, the stored ring record and the
+// live gossip members, so that copies cannot make the vault request arbitrary
+// addresses.
+func knownPeer(address string) bool {
+	if slices.Contains(VaultConfig.Peers, address) {
+		return true
+	}
+
+	data, err := LoadRingRecord()
+	if err == nil && data != nil {
+		var record struct {
+			Vaults []struct {
+				Address string `json:"address"`
+			} `json:"vaults"`
+		}
+		if json.Unmarshal(data, &record) == nil {
+			for _, vault := range record.Vaults {
+				if vault.Address == address {
+					return true
+				}
+			}
+		}
+	}
+
+	if VaultConfig.Membership != nil {
+		for _, member := range VaultConfig.Membership.Members() {
+			if member.Role == "vault" && member.Service == address && (member.State == internal.MemberAlive || member.State == internal.MemberSuspect) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// fetchPeerElement opens the content of an element held by another vault
+//
+// The request is signed with the gatekeeper secret, which the vaults of a
+// cluster share.
+func fetchPeerElement(address, namespace, groupId, elementId string) (io.ReadCloser, error) {
+	if !knownPeer(address) {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownPeer, address)
+	}
+	client, err := peerHTTPClient()
+	if err != nil {
+		return nil, err
+	}
+	scheme := "http"
+	if VaultConfig.PeerTLS != nil {
+		scheme = "https"
+	}
+	query := url.Values{"namespace": {namespace}, "groupId": {groupId}, "elementId": {elementId}}
+	req, err := http.NewRequest(http.MethodGet, scheme+"://"+address+"/group/element?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if VaultConfig.GatekeeperSecret != "" {
+		internal.SignRequest(req, VaultConfig.GatekeeperSecret)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrPeerTransfer, err)
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%w: %s on vault %s", ErrRecordNotFound, elementId, address)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%w: vault %s answered %s for element %s", ErrPeerTransfer, address, resp.Status, elementId)
+	}
+	return resp.Body, nil
+}
+
+// copyElementFile writes the file of a copy, linked from the local source or fetched from the vault holding it
+func copyElementFile(request internal.CopyRequest, element internal.CopyElement, dir string) error {
+	name := element.Meta.FileId + element.Meta.FileExtension
+	if element.Source == "" {
+		source, err := FilterByGroupElement(request.SourceNamespace, request.SourceGroupId, element.SourceId)
+		if err != nil {
+			return err
+		}
+		return internal.LinkFile(VaultConfig.Root, internal.GroupDir(request.SourceNamespace, request.SourceGroupId), source.Id+source.Attributes["fileExtension"], dir, name)
+	}
+
+	content, err := fetchPeerElement(element.Source, request.SourceNamespace, request.SourceGroupId, element.SourceId)
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+
+	checksum, err := internal.SaveReaderToFile(VaultConfig.Root, dir, name, content)
+	if err != nil {
+		return err
+	}
+	if element.Meta.Checksum != "" && checksum != element.Meta.Checksum {
+		internal.DeleteFile(VaultConfig.Root, dir, name)
+		return fmt.Errorf("%w: element %s fetched from vault %s", internal.ErrChecksumMismatch, element.SourceId, element.Source)
+	}
+	return nil
+}
+
+// CopyElements copies elements into a group, all of them or none
+//
+// Elements held by the vault are hard linked, the others are fetched from the
+// vault named by their source. The copies are indexed once every file and
+// meta file is written, along with the metadata of the group if the request
+// carries it.
+func CopyElements(namespace, groupId string, request internal.CopyRequest) ([]internal.Meta, error) {
+	preset := make([]internal.Meta, len(request.Elements))
+	for i, element := range request.Elements {
+		if !validateString(element.SourceId) || !validateString(element.Meta.FileId) {
+			return nil, fmt.Errorf("%w: invalid element id", ErrInvalidCopy)
+		}
+		if path, err := internal.JoinPath("", element.Meta.Path); element.Meta.Path != "" && (err != nil || path != element.Meta.Path) {
+			return nil, fmt.Errorf("%w: invalid path", ErrInvalidCopy)
+		}
+		preset[i] = element.Meta
+	}
+	err := checkKeysOverwritable(namespace, groupId, preset, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	dir := internal.GroupDir(namespace, groupId)
+	err = os.MkdirAll(filepath.Join(VaultConfig.Root, dir), os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata := make([]internal.Meta, 0, len(request.Elements))
+	rollback := func() {
+		for _, meta := range metadata {
+			internal.DeleteFile(VaultConfig.Root, dir, meta.FileId+"._meta")
+			internal.DeleteFile(VaultConfig.Root, dir, meta.FileId+meta.FileExtension)
+		}
+	}
+	for _, element := range request.Elements {
+		meta := element.Meta
+		meta.Namespace = namespace
+		meta.GroupId = groupId
+		if err := checkElementId(dir, meta.FileId); err != nil {
+			rollback()
+			return nil, err
+		}
+
+		// The meta file is written last so it only exists for complete files
+		err = copyElementFile(request, element, dir)
+		if err != nil {
+			rollback()
+			return nil, err
+		}
+		metaBytes, err := json.Marshal(meta)
+		if err == nil {
+			err = internal.SaveBytesToFile(VaultConfig.Root, dir, meta.FileId+"._meta", metaBytes)
+		}
+		if err != nil {
+			internal.DeleteFile(VaultConfig.Root, dir, meta.FileId+meta.FileExtension)
+			rollback()
+			return nil, err
+		}
+		metadata = append(metadata, meta)
+	}
+	if request.Group != nil {
+		err = SetGroupMeta(namespace, groupId, *request.Group)
+		if err != nil {
+			rollback()
+			return nil, err
+		}
+	}
+
+	keys := make(map[string]bool)
+	for _, meta := range metadata {
+		AddRecord(metaRecord(meta))
+		if meta.Key != "" {
+			keys[meta.Key] = true
+		}
+	}
+	for key := range keys {
+		err := PruneVersions(namespace, groupId, key, KeptVersions(namespace, groupId))
+		if err != nil {
+			log.Printf("Error pruning versions of key %s: %v\n", key, err)
+		}
+	}
+
+	return metadata, nil
+}
+
+// HandlerGroupCopy copies elements of another group, or of another vault, into a group
+//
+// The destination is named by the query and the elements by a CopyRequest
+// body. The ids of the copies are assigned by the gatekeeper so that every
+// replica stores them under the same id.
+func HandlerGroupCopy(w http.ResponseWriter, r *http.Request) {
+	groupId := r.URL.Query().Get("groupId")
+	if !validateString(groupId) {
+		http.Error(w, "Invalid Group ID", http.StatusBadRequest)
+		return
+	}
+	namespace, err := internal.RequestNamespace(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var request internal.CopyRequest
+	err = json.NewDecoder(r.Body).Decode(&request)
+	if err != nil || !validateString(request.SourceGroupId) || (len(request.Elements) == 0 && request.Group == nil) {
+		http.Error(w, ErrInvalidCopy.Error(), http.StatusBadRequest)
+		return
+	}
+	if request.SourceNamespace == "" {
+		request.SourceNamespace = internal.DefaultNamespace
+	}
+
+	readOnly, err := CheckWatermarks()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if readOnly {
+		http.Error(w, "vault is read-only", http.StatusInsufficientStorage)
+		return
+	}
+
+	metadata, err := CopyElements(namespace, groupId, request)
+	for _, meta := range metadata {
+		internal.AuditElements(r, meta.FileId)
+		rememberRollback(r, namespace, groupId, meta.FileId)
+	}
+	switch {
+	case errors.Is(err, ErrInvalidCopy), errors.Is(err, ErrUnknownPeer):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, ErrRecordNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, internal.ErrElementExists):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case errors.Is(err, internal.ErrRetentionLocked):
+		http.Error(w, err.Error(), http.StatusLocked)
+		return
+	case errors.Is(err, internal.ErrInsufficientStorage):
+		CheckWatermarks()
+		http.Error(w, err.Error(), http.StatusInsufficientStorage)
+		return
+	case errors.Is(err, internal.ErrChecksumMismatch), errors.Is(err, ErrPeerTransfer):
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(metadata)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
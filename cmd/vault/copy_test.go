@@ -0,0 +1,119 @@
+package vault
+
+import (
+	"crypto/sha256"
+	"datavault/cmd/internal"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testPeer starts a vault serving content for every element and makes it a known peer
+func testPeer(t *testing.T, content string) string {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/group/element" || r.URL.Query().Get("elementId") == "" {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(content))
+	}))
+	t.Cleanup(server.Close)
+
+	address := server.Listener.Addr().String()
+	VaultConfig.Peers = []string{address}
+	t.Cleanup(func() { VaultConfig.Peers = nil })
+	return address
+}
+
+// copyRequest returns a request copying an element of group g into a copy named c1
+func copyRequest(source, sourceId, checksum string) internal.CopyRequest {
+	return internal.CopyRequest{
+		SourceNamespace: internal.DefaultNamespace,
+		SourceGroupId:   "g",
+		Elements: []internal.CopyElement{{
+			SourceId: sourceId,
+			Source:   source,
+			Meta:     internal.Meta{FileId: "c1", FileName: "a.txt", FileExtension: ".txt", Checksum: checksum},
+		}},
+	}
+}
+
+func TestCopyElementsLocal(t *testing.T) {
+	testVault(t)
+	ids := uploadIds(t, "g", "a.txt")
+
+	_, err := CopyElements(internal.DefaultNamespace, "h", copyRequest("", ids[0], ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groupRecords("h")) != 1 || len(groupRecords("g")) != 1 {
+		t.Fatalf("%d elements in the copy, %d in the source", len(groupRecords("h")), len(groupRecords("g")))
+	}
+
+	// The vault holding the source links the file instead of copying it
+	source, err := os.Stat(filepath.Join(VaultConfig.Root, "g", ids[0]+".txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	copied, err := os.Stat(filepath.Join(VaultConfig.Root, "h", "c1.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(source, copied) {
+		t.Error("copy does not share the file of its source")
+	}
+}
+
+func TestCopyElementsPeer(t *testing.T) {
+	content := "content of a.txt"
+	sum := sha256.Sum256([]byte(content))
+	checksum := hex.EncodeToString(sum[:])
+
+	tests := []struct {
+		name     string
+		peer     bool
+		checksum string
+		err      error
+	}{
+		{name: "fetched from a peer", peer: true, checksum: checksum},
+		{name: "checksum mismatch", peer: true, checksum: "0000", err: internal.ErrChecksumMismatch},
+		{name: "unknown vault", checksum: checksum, err: ErrUnknownPeer},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			testVault(t)
+			address := testPeer(t, content)
+			if !test.peer {
+				VaultConfig.Peers = nil
+			}
+
+			_, err := CopyElements(internal.DefaultNamespace, "h", copyRequest(address, "a1", test.checksum))
+			if !errors.Is(err, test.err) {
+				t.Fatalf("error %v, want %v", err, test.err)
+			}
+			if test.err != nil {
+				if len(groupRecords("h")) != 0 {
+					t.Error("failed copy indexed")
+				}
+				if entries, _ := os.ReadDir(filepath.Join(VaultConfig.Root, "h")); len(entries) != 0 {
+					t.Errorf("failed copy left %d files", len(entries))
+				}
+				return
+			}
+
+			copied, err := os.ReadFile(filepath.Join(VaultConfig.Root, "h", "c1.txt"))
+			if err != nil || string(copied) != content {
+				t.Errorf("copied content %q (%v), want %q", copied, err, content)
+			}
+			if len(groupRecords("h")) != 1 {
+				t.Error("fetched copy not indexed")
+			}
+		})
+	}
+}
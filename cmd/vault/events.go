@@ -0,0 +1,67 @@
+package vault
+
+import (
+	"datavault/cmd/internal"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// emitElementEvent records the creation or deletion of an element
+func emitElementEvent(eventType string, record internal.Record) {
+	event := internal.Event{
+		Type:      eventType,
+		Time:      time.Now().UnixMilli(),
+		Namespace: record.Attributes["namespace"],
+		GroupId:   record.Attributes["groupId"],
+		ElementId: record.Id,
+	}
+	if eventType == internal.EventElementCreated {
+		event.Attributes = record.Attributes
+	}
+	VaultConfig.Events.Append(event)
+}
+
+// emitGroupDeleted records the deletion of a group
+func emitGroupDeleted(namespace, groupId string) {
+	VaultConfig.Events.Append(internal.Event{
+		Type:      internal.EventGroupDeleted,
+		Time:      time.Now().UnixMilli(),
+		Namespace: namespace,
+		GroupId:   groupId,
+	})
+}
+
+// HandlerEvents returns the events following a cursor, oldest first
+//
+// Without cursor the batch is empty and carries the cursor of the last event.
+func HandlerEvents(w http.ResponseWriter, r *http.Request) {
+	limit := 1000
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			http.Error(w, "limit must be a positive number", http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
+
+	batch, _, err := VaultConfig.Events.Since(r.URL.Query().Get("cursor"), limit)
+	if errors.Is(err, internal.ErrInvalidCursor) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(batch)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,42 @@
+package vault
+
+import (
+	"datavault/cmd/internal"
+	"log"
+	"os"
+)
+
+// StartGossip announces the vault to the gossip membership so that gatekeepers add it to their ring
+func StartGossip() (*internal.GossipNode, error) {
+	if VaultConfig.Advertise == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, err
+		}
+		VaultConfig.Advertise = hostname + ":" + VaultConfig.Port
+	}
+
+	if VaultConfig.Gossip.Name == "" {
+		VaultConfig.Gossip.Name = VaultConfig.Id
+	}
+
+	node, err := internal.NewGossipNode(*VaultConfig.Gossip, internal.Member{
+		Role:    "vault",
+		Service: VaultConfig.Advertise,
+		Labels: map[string]string{
+			"zone": VaultConfig.Zone,
+			"rack": VaultConfig.Rack,
+			"host": VaultConfig.Host,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = node.Join(VaultConfig.Gossip.Seeds)
+	if err != nil {
+		log.Printf("Error joining gossip seeds: %v\n", err)
+	}
+
+	return node, nil
+}
@@ -0,0 +1,259 @@
+package vault
+
+import (
+	"datavault/cmd/internal"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// ErrGroupExists is returned when a group is renamed to a group that already exists
+var ErrGroupExists = errors.New("group already exists")
+
+// GroupMetaOf returns the metadata of a group, read from its folder
+//
+// A group without a stored creation time is reported created with its oldest
+// element.
+func GroupMetaOf(namespace, groupId string) (internal.GroupMeta, error) {
+	var meta internal.GroupMeta
+	content, _, err := internal.ReadBytesFromFile(VaultConfig.Root, internal.GroupDir(namespace, groupId), internal.GroupMetaFile)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return internal.GroupMeta{}, err
+	}
+	if err == nil {
+		err = json.Unmarshal(content, &meta)
+		if err != nil {
+			return internal.GroupMeta{}, err
+		}
+	}
+
+	if meta.CreatedAt == 0 {
+		for _, record := range FilterByGroup(namespace, groupId) {
+			received, _ := strconv.ParseInt(record.Attributes["receivedTime"], 10, 64)
+			if received > 0 && (meta.CreatedAt == 0 || received < meta.CreatedAt) {
+				meta.CreatedAt = received
+			}
+		}
+	}
+	return meta, nil
+}
+
+// SetGroupMeta stores the metadata of a group in its folder
+func SetGroupMeta(namespace, groupId string, meta internal.GroupMeta) error {
+	dir := internal.GroupDir(namespace, groupId)
+	err := os.MkdirAll(filepath.Join(VaultConfig.Root, dir), os.ModePerm)
+	if err != nil {
+		return err
+	}
+	content, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return internal.ReplaceFile(VaultConfig.Root, dir, internal.GroupMetaFile, content)
+}
+
+// rewriteGroupId sets the group id in the meta file of an element and returns its updated record
+func rewriteGroupId(namespace, dir, elementId, groupId string) (internal.Record, error) {
+	content, _, err := internal.ReadBytesFromFile(VaultConfig.Root, dir, elementId+"._meta")
+	if err != nil {
+		return internal.Record{}, err
+	}
+	var meta internal.Meta
+	err = json.Unmarshal(content, &meta)
+	if err != nil {
+		return internal.Record{}, err
+	}
+	if meta.Namespace == "" {
+		meta.Namespace = namespace
+	}
+	meta.GroupId = groupId
+	content, err = json.Marshal(meta)
+	if err != nil {
+		return internal.Record{}, err
+	}
+	err = internal.ReplaceFile(VaultConfig.Root, dir, elementId+"._meta", content)
+	if err != nil {
+		return internal.Record{}, err
+	}
+	return metaRecord(meta), nil
+}
+
+// RenameGroup moves the folder of a group to another group id and rewrites the group id of its elements
+//
+// The group keeps its metadata, retention and element ids. Groups holding a
+// locked element or locked themselves are not renamed, and neither are groups
+// renamed to a group holding files. The elements are reported deleted from the
+// group and created in the renamed one.
+func RenameGroup(namespace, groupId, targetGroupId string) error {
+	retentionUpdates.Lock()
+	defer retentionUpdates.Unlock()
+
+	dir := internal.GroupDir(namespace, groupId)
+	targetDir := internal.GroupDir(namespace, targetGroupId)
+	_, err := os.Stat(filepath.Join(VaultConfig.Root, dir))
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%w: group %s", ErrRecordNotFound, groupId)
+	}
+	if err != nil {
+		return err
+	}
+	err = checkGroupDeletable(namespace, groupId, time.Now())
+	if err != nil {
+		return err
+	}
+	entries, _ := os.ReadDir(filepath.Join(VaultConfig.Root, targetDir))
+	if len(entries) > 0 || len(VaultConfig.Index.SearchEvery(map[string]string{"namespace": namespace, "groupId": targetGroupId})) > 0 {
+		return fmt.Errorf("%w: %s", ErrGroupExists, targetGroupId)
+	}
+
+	err = os.MkdirAll(filepath.Dir(filepath.Join(VaultConfig.Root, targetDir)), os.ModePerm)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(filepath.Join(VaultConfig.Root, dir), filepath.Join(VaultConfig.Root, targetDir))
+	if err != nil {
+		return err
+	}
+
+	// Meta files are rewritten in the renamed folder, and restored if one of them fails
+	records := VaultConfig.Index.SearchEvery(map[string]string{"namespace": namespace, "groupId": groupId})
+	renamed := make([]internal.Record, 0, len(records))
+	for _, record := range records {
+		updated, err := rewriteGroupId(namespace, targetDir, record.Id, targetGroupId)
+		if err != nil {
+			for _, done := range renamed {
+				_, restoreErr := rewriteGroupId(namespace, targetDir, done.Id, groupId)
+				if restoreErr != nil {
+					log.Printf("Error restoring meta file of element %s: %v\n", done.Id, restoreErr)
+				}
+			}
+			restoreErr := os.Rename(filepath.Join(VaultConfig.Root, targetDir), filepath.Join(VaultConfig.Root, dir))
+			if restoreErr != nil {
+				log.Printf("Error restoring folder of group %s: %v\n", groupId, restoreErr)
+			}
+			return err
+		}
+		renamed = append(renamed, updated)
+	}
+
+	forgetGroupRetention(namespace, groupId)
+	forgetGroupRetention(namespace, targetGroupId)
+	unindexGroup(namespace, groupId)
+	emitGroupDeleted(namespace, groupId)
+	for _, record := range renamed {
+		AddRecord(record)
+	}
+	return nil
+}
+
+// HandlerGroupMeta returns the metadata of a group
+func HandlerGroupMeta(w http.ResponseWriter, r *http.Request) {
+	groupId := r.URL.Query().Get("groupId")
+	if !validateString(groupId) {
+		http.Error(w, "Invalid Group ID", http.StatusBadRequest)
+		return
+	}
+	namespace, err := internal.RequestNamespace(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	meta, err := GroupMetaOf(namespace, groupId)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(meta)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// HandlerGroupMetaPut stores the metadata of a group given as the JSON body of the request
+//
+// The creation and update times are set by the gatekeeper so that every
+// replica stores the same metadata.
+func HandlerGroupMetaPut(w http.ResponseWriter, r *http.Request) {
+	groupId := r.URL.Query().Get("groupId")
+	if !validateString(groupId) {
+		http.Error(w, "Invalid Group ID", http.StatusBadRequest)
+		return
+	}
+	namespace, err := internal.RequestNamespace(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var meta internal.GroupMeta
+	err = json.NewDecoder(r.Body).Decode(&meta)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = meta.Validate()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = SetGroupMeta(namespace, groupId, meta)
+	if errors.Is(err, internal.ErrInsufficientStorage) {
+		http.Error(w, err.Error(), http.StatusInsufficientStorage)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(meta)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// HandlerGroupRename renames a group held by the vault to the group named by targetGroupId
+func HandlerGroupRename(w http.ResponseWriter, r *http.Request) {
+	groupId := r.URL.Query().Get("groupId")
+	targetGroupId := r.URL.Query().Get(internal.TargetGroupParam)
+	if !validateString(groupId) || !validateString(targetGroupId) || groupId == targetGroupId {
+		http.Error(w, "Invalid Group ID", http.StatusBadRequest)
+		return
+	}
+	namespace, err := internal.RequestNamespace(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = RenameGroup(namespace, groupId, targetGroupId)
+	switch {
+	case errors.Is(err, ErrRecordNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, ErrGroupExists):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case errors.Is(err, internal.ErrRetentionLocked):
+		http.Error(w, err.Error(), http.StatusLocked)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
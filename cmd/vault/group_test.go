@@ -0,0 +1,77 @@
+package vault
+
+import (
+	"datavault/cmd/internal"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// metaGroupId returns the group id stored in the meta file of an element
+func metaGroupId(t *testing.T, groupId, elementId string) string {
+	t.Helper()
+	content, err := os.ReadFile(filepath.Join(VaultConfig.Root, groupId, elementId+"._meta"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var meta internal.Meta
+	err = json.Unmarshal(content, &meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return meta.GroupId
+}
+
+func TestRenameGroup(t *testing.T) {
+	testVault(t)
+	ids := uploadIds(t, "g", "a.txt", "b.txt")
+
+	err := RenameGroup(internal.DefaultNamespace, "g", "h")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groupRecords("g")) != 0 || len(groupRecords("h")) != len(ids) {
+		t.Fatalf("%d elements left in g, %d in h", len(groupRecords("g")), len(groupRecords("h")))
+	}
+	for _, id := range ids {
+		if groupId := metaGroupId(t, "h", id); groupId != "h" {
+			t.Errorf("meta file of %s names group %q, want h", id, groupId)
+		}
+	}
+
+	// A group is not renamed over a group holding elements
+	uploadIds(t, "g", "c.txt")
+	err = RenameGroup(internal.DefaultNamespace, "g", "h")
+	if err == nil {
+		t.Error("renamed a group over another one")
+	}
+}
+
+func TestRenameGroupRollback(t *testing.T) {
+	testVault(t)
+	ids := uploadIds(t, "g", "a.txt", "b.txt", "c.txt")
+
+	// An unreadable meta file makes the rename fail after the folder moved
+	broken := filepath.Join(VaultConfig.Root, "g", ids[1]+"._meta")
+	err := os.WriteFile(broken, []byte("{"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = RenameGroup(internal.DefaultNamespace, "g", "h")
+	if err == nil {
+		t.Fatal("renamed a group with a broken meta file")
+	}
+
+	if _, err := os.Stat(filepath.Join(VaultConfig.Root, "h")); !os.IsNotExist(err) {
+		t.Errorf("renamed folder left behind: %v", err)
+	}
+	if len(groupRecords("g")) != len(ids) || len(groupRecords("h")) != 0 {
+		t.Errorf("%d elements indexed in g, %d in h", len(groupRecords("g")), len(groupRecords("h")))
+	}
+	for _, id := range []string{ids[0], ids[2]} {
+		if groupId := metaGroupId(t, "g", id); groupId != "g" {
+			t.Errorf("meta file of %s names group %q after the rollback, want g", id, groupId)
+		}
+	}
+}
@@ -3,49 +3,106 @@ package vault
 import (
 	"datavault/cmd/internal"
 	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
+	"os"
+	"path/filepath"
 	"regexp"
+	"strconv"
+	"time"
 )
 
 // HandlerPing is a simple health check endpoint
 func HandlerPing(w http.ResponseWriter, r *http.Request) {
+	readOnly, err := CheckWatermarks()
+	if err != nil {
+		log.Printf("Error checking disk watermarks: %v\n", err)
+	}
+
 	response := map[string]string{
 		"id":       VaultConfig.Id,
 		"instance": "vault",
 		"extended": "",
+		"readOnly": strconv.FormatBool(readOnly),
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	err := json.NewEncoder(w).Encode(response)
+	err = json.NewEncoder(w).Encode(response)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 }
 
-// HandlerGroups returns a list of groups
+// HandlerStats returns the capacity and usage of the vault
+func HandlerStats(w http.ResponseWriter, r *http.Request) {
+	stats, err := GetStats()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(stats)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// HandlerGroups returns a page of the groups of a namespace, sorted by group id, after the optional "after" cursor
 func HandlerGroups(w http.ResponseWriter, r *http.Request) {
-	groups := GetGroups()
+	namespace, err := internal.RequestNamespace(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	limit, err := internal.ParseGroupsLimit(r.URL.Query().Get("limit"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	page := GetGroupsPage(namespace, r.URL.Query().Get("after"), limit)
 	w.Header().Set("Content-Type", "application/json")
-	err := json.NewEncoder(w).Encode(groups)
+	err = json.NewEncoder(w).Encode(page)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 }
 
-// HandlerGroup returns a list of records in a group
+// HandlerGroup returns a list of records in a group, those whose path starts with the prefix parameter
 func HandlerGroup(w http.ResponseWriter, r *http.Request) {
 	groupId := r.URL.Query().Get("groupId")
 	if !validateString(groupId) {
 		http.Error(w, "Invalid Group ID", http.StatusBadRequest)
 		return
 	}
+	namespace, err := internal.RequestNamespace(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	records := FilterByGroup(namespace, groupId)
+
+	// HEAD only reports whether the group exists in the vault
+	if r.Method == http.MethodHead {
+		if len(records) == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	records = internal.FilterPrefix(records, r.URL.Query().Get(internal.PrefixParam))
 
-	records := FilterByGroup(groupId)
 	w.Header().Set("Content-Type", "application/json")
-	err := json.NewEncoder(w).Encode(records)
+	err = json.NewEncoder(w).Encode(records)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -59,9 +116,57 @@ func HandlerGroupUpload(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid Group ID", http.StatusBadRequest)
 		return
 	}
+	namespace, err := internal.RequestNamespace(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	expiresAt, err := internal.ExpiresAt(r.URL.Query().Get(internal.TTLParam), time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	key := r.URL.Query().Get(internal.KeyParam)
+	if key != "" && internal.ValidateKey(key) != nil {
+		http.Error(w, internal.ErrInvalidKey.Error(), http.StatusBadRequest)
+		return
+	}
+	retention, err := internal.ParseRetention(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	retention, _ = internal.Retention{}.Update(retention, time.Now())
+	// Archives are extracted by the gatekeeper within its limits, the vault never stores them unextracted by mistake
+	if r.URL.Query().Get(internal.ExtractParam) == "true" {
+		http.Error(w, "archives are extracted by the gatekeeper, upload them through it", http.StatusBadRequest)
+		return
+	}
+
+	readOnly, err := CheckWatermarks()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if readOnly {
+		http.Error(w, "vault is read-only", http.StatusInsufficientStorage)
+		return
+	}
+
+	if r.ContentLength > 0 {
+		ok, err := HasFreeSpace(r.ContentLength)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			http.Error(w, "not enough free space for upload", http.StatusInsufficientStorage)
+			return
+		}
+	}
 
 	// Create group directory in the vault
-	err := internal.CreateDirectoryIfNotExists(VaultConfig.Root, groupId)
+	err = os.MkdirAll(filepath.Join(VaultConfig.Root, internal.GroupDir(namespace, groupId)), 0777)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -82,13 +187,135 @@ func HandlerGroupUpload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = PutGroup(groupId, files)
+	// Replicated uploads carry the ids assigned by the gatekeeper, client uploads streamed by it cannot choose them
+	var preset []internal.Meta
+	if metaValue := r.MultipartForm.Value["meta"]; len(metaValue) > 0 {
+		if r.URL.Query().Get(internal.PresetParam) != "true" {
+			http.Error(w, "element metadata is assigned by the gatekeeper", http.StatusBadRequest)
+			return
+		}
+		err = json.Unmarshal([]byte(metaValue[0]), &preset)
+		if err != nil {
+			http.Error(w, "Invalid metadata", http.StatusBadRequest)
+			return
+		}
+		for i, meta := range preset {
+			if !validateString(meta.FileId) {
+				http.Error(w, "Invalid Element ID", http.StatusBadRequest)
+				return
+			}
+			if path, err := internal.JoinPath("", meta.Path); meta.Path != "" && (err != nil || path != meta.Path) {
+				http.Error(w, internal.ErrInvalidPath.Error(), http.StatusBadRequest)
+				return
+			}
+			err = meta.Retention.Validate()
+			if err == nil {
+				preset[i].Retention, err = internal.Retention{}.Update(meta.Retention, time.Now())
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+	}
+
+	if key != "" && len(files) != 1 {
+		http.Error(w, "a key names a single file", http.StatusBadRequest)
+		return
+	}
+
+	// Files uploaded into a folder get a virtual path, replicated uploads carry their path in preset
+	if folder := r.URL.Query().Get(internal.FolderParam); folder != "" {
+		if preset == nil {
+			preset = make([]internal.Meta, len(files))
+		}
+		for i := range preset {
+			if preset[i].Path != "" {
+				continue
+			}
+			preset[i].Path, err = internal.JoinPath(folder, files[i].Filename)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+	}
+
+	// Elements uploaded with a TTL expire together, replicated uploads carry their expiry in preset
+	if expiresAt != "" {
+		if preset == nil {
+			preset = make([]internal.Meta, len(files))
+		}
+		for i := range preset {
+			if preset[i].ExpiresAt == "" {
+				preset[i].ExpiresAt = expiresAt
+			}
+		}
+	}
+
+	// Elements uploaded with a retention are locked together, replicated uploads carry their retention in preset
+	if retention != (internal.Retention{}) {
+		if preset == nil {
+			preset = make([]internal.Meta, len(files))
+		}
+		for i := range preset {
+			if preset[i].Retention == (internal.Retention{}) {
+				preset[i].Retention = retention
+			}
+		}
+	}
+
+	// Elements are keyed by the key parameter, or by path or file name in versioned groups
+	if key != "" || Versioned(namespace, groupId) {
+		if preset == nil {
+			preset = make([]internal.Meta, len(files))
+		}
+		for i := range preset {
+			switch {
+			case preset[i].Key != "":
+			case key != "":
+				preset[i].Key = key
+			case preset[i].Path != "":
+				preset[i].Key = preset[i].Path
+			default:
+				preset[i].Key = files[i].Filename
+			}
+			if internal.ValidateKey(preset[i].Key) != nil {
+				http.Error(w, internal.ErrInvalidKey.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+	}
+
+	metadata, err := PutGroup(namespace, groupId, files, preset)
+	for _, meta := range metadata {
+		internal.AuditElements(r, meta.FileId)
+		rememberRollback(r, namespace, groupId, meta.FileId)
+	}
+	if errors.Is(err, internal.ErrElementExists) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if errors.Is(err, internal.ErrRetentionLocked) {
+		http.Error(w, err.Error(), http.StatusLocked)
+		return
+	}
+	if errors.Is(err, internal.ErrInsufficientStorage) {
+		CheckWatermarks()
+		http.Error(w, err.Error(), http.StatusInsufficientStorage)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(metadata)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 }
 
 // HandlerGroupDelete deletes a group from the vault
@@ -98,35 +325,70 @@ func HandlerGroupDelete(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid Group ID", http.StatusBadRequest)
 		return
 	}
+	namespace, err := internal.RequestNamespace(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	// Delete group directory from the vault
-	err := internal.DeleteDirectory(VaultConfig.Root, groupId)
+	trashId, trash, err := trashRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if trash {
+		item, err := TrashGroup(namespace, groupId, trashId)
+		writeTrashItem(w, item, err)
+		return
+	}
+
+	// Delete group directory and records from the vault
+	err = DeleteGroup(namespace, groupId)
+	if errors.Is(err, internal.ErrRetentionLocked) {
+		http.Error(w, err.Error(), http.StatusLocked)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	// Delete group from the vault index
-	DeleteGroup(groupId)
 	w.WriteHeader(http.StatusOK)
 }
 
-// HandleElementGet retrieves an element from the vault
+// HandleElementGet retrieves an element from the vault, by id or by key and optional version
 func HandlerElementGet(w http.ResponseWriter, r *http.Request) {
-	//this is for API consistency between gatekeeper and vault
+	//the group scopes the element, an element of another group is not found
 	groupId := r.URL.Query().Get("groupId")
 	if !validateString(groupId) {
 		http.Error(w, "Invalid Group ID", http.StatusBadRequest)
 		return
 	}
+	namespace, err := internal.RequestNamespace(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	recordId := r.URL.Query().Get("elementId")
+	if key := r.URL.Query().Get(internal.KeyParam); key != "" && recordId == "" {
+		version, err := FindVersion(namespace, groupId, key, r.URL.Query().Get(internal.VersionParam))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		recordId = version.Id
+	}
 	if !validateString(recordId) {
 		http.Error(w, "Invalid Element ID", http.StatusBadRequest)
 		return
 	}
 
-	path, err := GetElement(recordId)
+	path, err := GetElement(namespace, groupId, recordId)
+	if errors.Is(err, ErrRecordNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -135,9 +397,118 @@ func HandlerElementGet(w http.ResponseWriter, r *http.Request) {
 	http.ServeFile(w, r, path)
 }
 
+// HandlerElementVersions returns the versions of a key, newest first
+func HandlerElementVersions(w http.ResponseWriter, r *http.Request) {
+	groupId := r.URL.Query().Get("groupId")
+	if !validateString(groupId) {
+		http.Error(w, "Invalid Group ID", http.StatusBadRequest)
+		return
+	}
+	key := r.URL.Query().Get(internal.KeyParam)
+	if internal.ValidateKey(key) != nil {
+		http.Error(w, internal.ErrInvalidKey.Error(), http.StatusBadRequest)
+		return
+	}
+	namespace, err := internal.RequestNamespace(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	versions := Versions(namespace, groupId, key)
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(versions)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// HandlerElementRestore stores a copy of a version of a key as its newest version
+//
+// The gatekeeper names the restored element with elementId and receivedTime,
+// so that every replica stores it under the same id.
+func HandlerElementRestore(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	groupId := query.Get("groupId")
+	if !validateString(groupId) {
+		http.Error(w, "Invalid Group ID", http.StatusBadRequest)
+		return
+	}
+	key := query.Get(internal.KeyParam)
+	if internal.ValidateKey(key) != nil {
+		http.Error(w, internal.ErrInvalidKey.Error(), http.StatusBadRequest)
+		return
+	}
+	versionId := query.Get(internal.VersionParam)
+	if !validateString(versionId) {
+		http.Error(w, "Invalid Version ID", http.StatusBadRequest)
+		return
+	}
+	elementId := query.Get("elementId")
+	if elementId != "" && !validateString(elementId) {
+		http.Error(w, "Invalid Element ID", http.StatusBadRequest)
+		return
+	}
+	receivedTime := query.Get("receivedTime")
+	if _, err := strconv.ParseInt(receivedTime, 10, 64); receivedTime != "" && err != nil {
+		http.Error(w, "Invalid received time", http.StatusBadRequest)
+		return
+	}
+	namespace, err := internal.RequestNamespace(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	readOnly, err := CheckWatermarks()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if readOnly {
+		http.Error(w, "vault is read-only", http.StatusInsufficientStorage)
+		return
+	}
+
+	meta, err := RestoreVersion(namespace, groupId, key, versionId, elementId, receivedTime)
+	if err == nil {
+		internal.AuditElements(r, meta.FileId)
+		rememberRollback(r, namespace, groupId, meta.FileId)
+	}
+	if errors.Is(err, ErrRecordNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, internal.ErrElementExists) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if errors.Is(err, internal.ErrRetentionLocked) {
+		http.Error(w, err.Error(), http.StatusLocked)
+		return
+	}
+	if errors.Is(err, internal.ErrInsufficientStorage) {
+		CheckWatermarks()
+		http.Error(w, err.Error(), http.StatusInsufficientStorage)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode([]internal.Meta{meta})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
 // HandleElementDelete deletes an element from the vault
 func HandleElementDelete(w http.ResponseWriter, r *http.Request) {
-	//this is for API consistency between gatekeeper and vault
+	//the group scopes the element, an element of another group is not found
 	groupId := r.URL.Query().Get("groupId")
 	if !validateString(groupId) {
 		http.Error(w, "Invalid Group ID", http.StatusBadRequest)
@@ -149,8 +520,156 @@ func HandleElementDelete(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid Element ID", http.StatusBadRequest)
 		return
 	}
+	namespace, err := internal.RequestNamespace(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	trashId, trash, err := trashRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	token := r.URL.Query().Get(internal.RollbackParam)
+	if trash && token == "" {
+		item, err := TrashElement(namespace, groupId, recordId, trashId)
+		writeTrashItem(w, item, err)
+		return
+	}
 
-	err := DeleteElement(recordId)
+	// Rollbacks of the gatekeeper remove the elements their request stored for good
+	if token != "" {
+		err = RollbackElement(namespace, groupId, recordId, token)
+	} else {
+		err = DeleteElement(namespace, groupId, recordId)
+	}
+	if errors.Is(err, ErrRecordNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, internal.ErrRetentionLocked) {
+		http.Error(w, err.Error(), http.StatusLocked)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// trashRequest returns the trash item id of a delete and whether it moves to the trash
+func trashRequest(r *http.Request) (string, bool, error) {
+	trashId := r.URL.Query().Get(internal.TrashParam)
+	if trashId != "" && !validateString(trashId) {
+		return "", false, errors.New("Invalid Trash ID")
+	}
+	return trashId, TrashEnabled() && r.URL.Query().Get(internal.PurgeParam) != "true", nil
+}
+
+// writeTrashItem writes the trash item of a delete or its error
+func writeTrashItem(w http.ResponseWriter, item internal.TrashItem, err error) {
+	if errors.Is(err, ErrRecordNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, ErrTrashItemExists) || errors.Is(err, internal.ErrElementExists) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if errors.Is(err, internal.ErrRetentionLocked) {
+		http.Error(w, err.Error(), http.StatusLocked)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// A group without folder on this vault was not trashed
+	if item.Id == "" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(item)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// HandlerTrash returns the trash items of a namespace, of one group when groupId is set, newest first
+func HandlerTrash(w http.ResponseWriter, r *http.Request) {
+	groupId := r.URL.Query().Get("groupId")
+	if groupId != "" && !validateString(groupId) {
+		http.Error(w, "Invalid Group ID", http.StatusBadRequest)
+		return
+	}
+	namespace, err := internal.RequestNamespace(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	items, err := ListTrash(namespace, groupId)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(items)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// trashItemRequest returns the trash item id, namespace and group of a request on a trash item
+func trashItemRequest(r *http.Request) (string, string, string, error) {
+	trashId := r.URL.Query().Get(internal.TrashParam)
+	if !validateString(trashId) {
+		return "", "", "", errors.New("Invalid Trash ID")
+	}
+	groupId := r.URL.Query().Get("groupId")
+	if !validateString(groupId) {
+		return "", "", "", errors.New("Invalid Group ID")
+	}
+	namespace, err := internal.RequestNamespace(r)
+	if err != nil {
+		return "", "", "", err
+	}
+	return trashId, namespace, groupId, nil
+}
+
+// HandlerTrashRestore moves the elements of a trash item back into their group
+func HandlerTrashRestore(w http.ResponseWriter, r *http.Request) {
+	trashId, namespace, groupId, err := trashItemRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	item, err := RestoreTrash(trashId, namespace, groupId)
+	writeTrashItem(w, item, err)
+}
+
+// HandlerTrashPurge deletes a trash item for good
+func HandlerTrashPurge(w http.ResponseWriter, r *http.Request) {
+	trashId, namespace, groupId, err := trashItemRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = PurgeTrash(trashId, namespace, groupId)
+	if errors.Is(err, ErrRecordNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -159,6 +678,134 @@ func HandleElementDelete(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
+// HandlerGroupRetention returns the retention of a group
+func HandlerGroupRetention(w http.ResponseWriter, r *http.Request) {
+	groupId := r.URL.Query().Get("groupId")
+	if !validateString(groupId) {
+		http.Error(w, "Invalid Group ID", http.StatusBadRequest)
+		return
+	}
+	namespace, err := internal.RequestNamespace(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(GroupRetention(namespace, groupId))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// HandlerGroupRetentionPut changes the retention date, mode or legal hold of a group
+func HandlerGroupRetentionPut(w http.ResponseWriter, r *http.Request) {
+	groupId := r.URL.Query().Get("groupId")
+	if !validateString(groupId) {
+		http.Error(w, "Invalid Group ID", http.StatusBadRequest)
+		return
+	}
+	namespace, err := internal.RequestNamespace(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	change, err := internal.ParseRetention(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	retention, err := SetGroupRetention(namespace, groupId, change)
+	if errors.Is(err, internal.ErrRetentionLocked) {
+		http.Error(w, err.Error(), http.StatusLocked)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(retention)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// HandlerElementRetentionPut changes the retention date, mode or legal hold of an element
+func HandlerElementRetentionPut(w http.ResponseWriter, r *http.Request) {
+	groupId := r.URL.Query().Get("groupId")
+	if !validateString(groupId) {
+		http.Error(w, "Invalid Group ID", http.StatusBadRequest)
+		return
+	}
+	elementId := r.URL.Query().Get("elementId")
+	if !validateString(elementId) {
+		http.Error(w, "Invalid Element ID", http.StatusBadRequest)
+		return
+	}
+	namespace, err := internal.RequestNamespace(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	change, err := internal.ParseRetention(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	record, err := SetElementRetention(namespace, groupId, elementId, change)
+	if errors.Is(err, ErrRecordNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, internal.ErrRetentionLocked) {
+		http.Error(w, err.Error(), http.StatusLocked)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(record)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// HandlerAudit returns the entries of the audit log of the vault, by time range, namespace and group
+func HandlerAudit(w http.ResponseWriter, r *http.Request) {
+	if VaultConfig.AuditLog == nil {
+		http.Error(w, "audit log is disabled", http.StatusNotFound)
+		return
+	}
+	query, err := internal.ParseAuditQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	entries, err := VaultConfig.AuditLog.Query(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(entries)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
 // validateString checks if the string is alphanumeric, underscore and hyphen
 func validateString(x string) bool {
 	re := regexp.MustCompile(`^[a-zA-Z0-9_\-]+$`) // only allow alphanumeric, underscore and hyphen
@@ -0,0 +1,78 @@
+package vault
+
+import (
+	"datavault/cmd/internal"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestUploadPreset(t *testing.T) {
+	compliance := `[{"fileId":"a1","retainUntil":"4102444800000","retentionMode":"compliance"}]`
+	tests := []struct {
+		name   string
+		query  url.Values
+		meta   string
+		status int
+	}{
+		{name: "client upload", query: url.Values{"groupId": {"g"}}, status: http.StatusOK},
+		{name: "client meta part", query: url.Values{"groupId": {"g"}}, meta: compliance, status: http.StatusBadRequest},
+		{name: "gatekeeper preset", query: url.Values{"groupId": {"g"}, internal.PresetParam: {"true"}}, meta: compliance, status: http.StatusOK},
+		{name: "preset path", query: url.Values{"groupId": {"g"}, internal.PresetParam: {"true"}}, meta: `[{"fileId":"a1","path":"docs/a.txt"}]`, status: http.StatusOK},
+		{name: "climbing path", query: url.Values{"groupId": {"g"}, internal.PresetParam: {"true"}}, meta: `[{"fileId":"a1","path":"../../x"}]`, status: http.StatusBadRequest},
+		{name: "unclean path", query: url.Values{"groupId": {"g"}, internal.PresetParam: {"true"}}, meta: `[{"fileId":"a1","path":"docs//a.txt"}]`, status: http.StatusBadRequest},
+		{name: "control character in path", query: url.Values{"groupId": {"g"}, internal.PresetParam: {"true"}}, meta: `[{"fileId":"a1","path":"docs/a\u0000.txt"}]`, status: http.StatusBadRequest},
+		{name: "malformed retention", query: url.Values{"groupId": {"g"}, internal.PresetParam: {"true"}}, meta: `[{"fileId":"a1","retentionMode":"forever"}]`, status: http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			testVault(t)
+			w := upload(t, test.query, test.meta, "a.txt")
+			if w.Code != test.status {
+				t.Fatalf("status %d, want %d: %s", w.Code, test.status, w.Body)
+			}
+			if w.Code != http.StatusOK {
+				return
+			}
+
+			var metadata []internal.Meta
+			err := json.NewDecoder(w.Body).Decode(&metadata)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.meta == "" && metadata[0].Retention != (internal.Retention{}) {
+				t.Errorf("client upload locked with %+v", metadata[0].Retention)
+			}
+			if test.meta == compliance && (metadata[0].FileId != "a1" || metadata[0].RetentionMode != internal.RetentionCompliance) {
+				t.Errorf("preset not applied: %+v", metadata[0])
+			}
+		})
+	}
+}
+
+func TestUploadTakenId(t *testing.T) {
+	testVault(t)
+	preset := `[{"fileId":"a1"}]`
+	w := upload(t, url.Values{"groupId": {"g"}, internal.PresetParam: {"true"}}, preset, "a.txt")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+
+	// Element ids are unique across the groups and namespaces of the vault
+	for _, query := range []url.Values{
+		{"groupId": {"g"}},
+		{"groupId": {"h"}},
+		{"groupId": {"g"}, "namespace": {"team"}},
+	} {
+		query.Set(internal.PresetParam, "true")
+		w := upload(t, query, preset, "b.txt")
+		if w.Code != http.StatusConflict {
+			t.Errorf("upload to %v: status %d, want %d", query, w.Code, http.StatusConflict)
+		}
+	}
+	if records := VaultConfig.Index.SearchAll([]string{"fileId"}); len(records) != 1 {
+		t.Errorf("%d elements indexed, want 1", len(records))
+	}
+}
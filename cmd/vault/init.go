@@ -5,12 +5,11 @@ import (
 	"datavault/configs"
 	"encoding/json"
 	"errors"
-	"fmt"
 	"log"
 	"os"
+	"path"
 	"path/filepath"
-
-	"github.com/google/uuid"
+	"sync/atomic"
 )
 
 // Config represents the configuration for the vault server
@@ -22,7 +21,40 @@ type Config struct {
 	IN_MEMORY_UPLOAD_SIZE int64 `json:"in_memory_upload_size"` // Maximum size of in-memory upload
 	MAX_UPLOAD_SIZE       int64 `json:"max_upload_size"`       // Maximum size of upload
 
+	HighWatermark float64 `json:"high_watermark"` // Disk usage in percent above which the vault turns read-only, 0 disables
+	LowWatermark  float64 `json:"low_watermark"`  // Disk usage in percent below which a read-only vault is writable again
+
+	Advertise string                 `json:"advertise"` // HTTP address announced to the gossip members (host:port)
+	Zone      string                 `json:"zone"`      // Availability zone of the vault, announced through gossip
+	Rack      string                 `json:"rack"`      // Rack of the vault, announced through gossip
+	Host      string                 `json:"host"`      // Physical host of the vault, announced through gossip
+	Gossip    *internal.GossipConfig `json:"gossip"`    // Gossip membership configuration, nil disables gossip
+
+	TLS     *internal.TLSConfig `json:"tls"`      // TLS configuration of the listener, nil serves plain HTTP
+	PeerTLS *internal.TLSConfig `json:"peer_tls"` // TLS configuration of transfers from other vaults, nil uses plain HTTP
+
+	Peers       []string `json:"peers"`        // Addresses of the other vaults copies may fetch from, besides the shared ring and gossip members
+	PeerTimeout int      `json:"peer_timeout"` // Seconds a transfer from another vault may take, defaults to 300
+
+	GatekeeperSecret string `json:"gatekeeper_secret"` // Secret shared with the gatekeepers, requests must be signed with it when set
+	SignatureMaxAge  int    `json:"signature_max_age"` // Seconds a signed request stays valid, defaults to 60
+	URLSecret        string `json:"url_secret"`        // Secret shared with the gatekeepers to verify pre-signed URLs, empty skips the check
+
+	Lifecycle      []LifecycleRule `json:"lifecycle"`       // Lifecycle rules of the groups, the first matching rule applies
+	ReapInterval   int             `json:"reap_interval"`   // Interval between runs of the reaper deleting expired elements in seconds, defaults to 60
+	TrashRetention int             `json:"trash_retention"` // Seconds deleted groups and elements are kept in the trash before being purged, 0 deletes them at once
+
+	Audit       *internal.AuditConfig `json:"audit"`        // Audit log of mutating requests, nil disables it
+	EventBuffer int                   `json:"event_buffer"` // Change events kept for the gatekeepers polling them, defaults to 10000
+
+	ReadOnly atomic.Bool // Whether the vault rejects uploads
+
 	Index internal.Index // Inverted index for the vault
+	Usage *UsageTracker  // Usage of the groups in the index
+
+	AuditLog   *internal.AuditLog    // Audit log of mutating requests, nil when disabled
+	Membership *internal.GossipNode  // Gossip membership of the vault, nil when disabled
+	Events     *internal.EventBuffer // Latest change events of the elements
 }
 
 var VaultConfig Config
@@ -42,6 +74,33 @@ func Init() {
 		log.Fatalf("Root folder for vault is not set\n")
 	}
 
+	if VaultConfig.SignatureMaxAge <= 0 {
+		VaultConfig.SignatureMaxAge = 60
+	}
+	if VaultConfig.PeerTimeout <= 0 {
+		VaultConfig.PeerTimeout = 300
+	}
+
+	//Validate lifecycle rules
+	if VaultConfig.ReapInterval <= 0 {
+		VaultConfig.ReapInterval = 60
+	}
+	for i, rule := range VaultConfig.Lifecycle {
+		_, namespaceErr := path.Match(rule.Namespace, "")
+		_, groupsErr := path.Match(rule.Groups, "")
+		if namespaceErr != nil || groupsErr != nil || rule.MaxAge < 0 || rule.MaxVersions < 0 {
+			log.Fatalf("Invalid lifecycle rule %d\n", i)
+		}
+	}
+
+	//Validate disk watermarks
+	if VaultConfig.LowWatermark <= 0 {
+		VaultConfig.LowWatermark = VaultConfig.HighWatermark
+	}
+	if VaultConfig.HighWatermark > 100 || VaultConfig.LowWatermark > VaultConfig.HighWatermark {
+		log.Fatalf("Invalid disk watermarks: high %.1f, low %.1f\n", VaultConfig.HighWatermark, VaultConfig.LowWatermark)
+	}
+
 	//Check if root folder exists, if not create it
 	if _, err := os.Stat(VaultConfig.Root); errors.Is(err, os.ErrNotExist) {
 		log.Println("Root folder for vault does not exist, creating it...")
@@ -51,11 +110,41 @@ func Init() {
 		}
 	}
 
+	//Initialize the change events, the index is rebuilt without emitting events
+	if VaultConfig.EventBuffer <= 0 {
+		VaultConfig.EventBuffer = 10000
+	}
+	VaultConfig.Events = internal.NewEventBuffer(VaultConfig.EventBuffer)
+
 	//Initialize inverted index
 	VaultConfig.Index, err = generateVaultIndex(VaultConfig.Root)
 	if err != nil {
 		log.Fatalf("Error reconstructing index: %v\n", err)
 	}
+	VaultConfig.Usage = NewUsageTracker(VaultConfig.Index)
+
+	//Open the audit log
+	if VaultConfig.Audit != nil {
+		VaultConfig.AuditLog, err = internal.OpenAuditLog(*VaultConfig.Audit, "vault "+VaultConfig.Id)
+		if err != nil {
+			log.Fatalf("Error opening audit log: %v\n", err)
+		}
+	}
+
+	//Read the version of the shared ring record
+	err = InitRingRecord()
+	if err != nil {
+		log.Fatalf("Error reading ring record: %v\n", err)
+	}
+
+	//Initialize read-only state
+	readOnly, err := CheckWatermarks()
+	if err != nil {
+		log.Printf("Error checking disk watermarks: %v\n", err)
+	}
+	if readOnly {
+		log.Println("Vault starts in read-only mode")
+	}
 }
 
 // generateVaultIndex reconstructs the inverted index from the vault root folder
@@ -68,57 +157,100 @@ func generateVaultIndex(root string) (internal.Index, error) {
 	}
 
 	for _, dir := range dirs {
-		if !dir.IsDir() {
+		if !dir.IsDir() || dir.Name() == internal.TrashDir {
 			continue
 		}
 
-		groupPath := filepath.Join(root, dir.Name())
-		groupDirs, err := os.ReadDir(groupPath)
+		// Groups of the default namespace are in the root, other namespaces have their own folder
+		namespace, ok := internal.NamespaceOfDir(dir.Name())
+		if !ok {
+			err = indexGroup(index, filepath.Join(root, dir.Name()), internal.DefaultNamespace)
+			if err != nil {
+				return index, err
+			}
+			continue
+		}
+
+		namespaceDirs, err := os.ReadDir(filepath.Join(root, dir.Name()))
 		if err != nil {
 			return index, err
 		}
-
-		filesList := make([]string, 0)
-		for _, groupFile := range groupDirs {
-			if groupFile.IsDir() {
-				return index, fmt.Errorf("unexpected directory in group folder: %s", groupFile.Name())
+		for _, groupDir := range namespaceDirs {
+			if !groupDir.IsDir() {
+				continue
 			}
+			err = indexGroup(index, filepath.Join(root, dir.Name(), groupDir.Name()), namespace)
+			if err != nil {
+				return index, err
+			}
+		}
+	}
+	return index, err
+}
 
-			recordPath := filepath.Join(groupPath, groupFile.Name())
-			filesList = append(filesList, recordPath)
+// indexGroup adds the records of a group folder to the index
+func indexGroup(index internal.Index, groupPath, namespace string) error {
+	groupDirs, err := os.ReadDir(groupPath)
+	if err != nil {
+		return err
+	}
+
+	filesList := make([]string, 0)
+	for _, groupFile := range groupDirs {
+		// Elements are stored flat, their folders are virtual paths kept in their meta file
+		if groupFile.IsDir() {
+			log.Printf("Skipping directory in group folder: %s\n", filepath.Join(groupPath, groupFile.Name()))
+			continue
 		}
 
-		matchedFiles := make(map[string]string, 0)
+		recordPath := filepath.Join(groupPath, groupFile.Name())
+		filesList = append(filesList, recordPath)
+	}
+
+	matchedFiles := make(map[string]string, 0)
 
-		for _, recordPath := range filesList {
-			if filepath.Ext(recordPath) != "._meta" {
-				metaPath := recordPath[:len(recordPath)-len(filepath.Ext(recordPath))] + "._meta"
-				if _, err := os.Stat(metaPath); errors.Is(err, os.ErrNotExist) {
-					return index, fmt.Errorf("missing meta file for record: %s", recordPath)
-				}
-				matchedFiles[recordPath] = metaPath
+	for _, recordPath := range filesList {
+		if filepath.Ext(recordPath) != "._meta" {
+			metaPath := recordPath[:len(recordPath)-len(filepath.Ext(recordPath))] + "._meta"
+			if _, err := os.Stat(metaPath); errors.Is(err, os.ErrNotExist) {
+				// Meta files are written last, a missing one means the upload was interrupted
+				log.Printf("Skipping record without meta file: %s\n", recordPath)
+				continue
 			}
+			matchedFiles[recordPath] = metaPath
 		}
+	}
 
-		for _, metaPath := range matchedFiles {
-			//create record from reading recordPath filename with extension, and content from metaPath file
-			record := internal.Record{}
-			metaFile, err := os.ReadFile(metaPath)
-			if err != nil {
-				return index, err
-			}
+	for _, metaPath := range matchedFiles {
+		//create record from reading recordPath filename with extension, and content from metaPath file
+		record, err := readMetaRecord(metaPath, namespace)
+		if err != nil {
+			return err
+		}
 
-			var attributes map[string]string
-			err = json.Unmarshal(metaFile, &attributes)
-			if err != nil {
-				return index, err
-			}
+		index.Add(record)
+	}
+
+	return nil
+}
 
-			record.Id = uuid.New().String()
-			record.Attributes = attributes
+// readMetaRecord reads the index record of an element from its meta file
+func readMetaRecord(metaPath, namespace string) (internal.Record, error) {
+	metaFile, err := os.ReadFile(metaPath)
+	if err != nil {
+		return internal.Record{}, err
+	}
 
-			index.Add(record)
-		}
+	var attributes map[string]string
+	err = json.Unmarshal(metaFile, &attributes)
+	if err != nil {
+		return internal.Record{}, err
 	}
-	return index, err
+
+	// Meta files written before namespaces existed belong to the default namespace
+	if attributes["namespace"] == "" {
+		attributes["namespace"] = namespace
+	}
+
+	return internal.Record{Id: attributes["fileId"], Attributes: attributes}, nil
 }
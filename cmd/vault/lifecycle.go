@@ -0,0 +1,202 @@
+package vault
+
+import (
+	"datavault/cmd/internal"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// LifecycleRule expires and versions the elements of the groups matching a pattern
+type LifecycleRule struct {
+	Namespace   string `json:"namespace"`    // Namespace or pattern ("*", "team-*"), defaults to the default namespace
+	Groups      string `json:"groups"`       // Group id or pattern ("*", "tmp-*"), defaults to every group
+	MaxAge      int64  `json:"max_age"`      // Seconds after their received time elements are deleted, 0 keeps them
+	DeleteEmpty bool   `json:"delete_empty"` // Delete the folder of a group once it holds no element
+	Versioning  bool   `json:"versioning"`   // Keep previous versions of keyed elements, elements are keyed by file name by default
+	MaxVersions int    `json:"max_versions"` // Versions kept per key when versioning, 0 keeps every version
+}
+
+// Matches reports whether the rule applies to a group of a namespace
+func (l LifecycleRule) Matches(namespace, groupId string) bool {
+	namespacePattern := l.Namespace
+	if namespacePattern == "" {
+		namespacePattern = internal.DefaultNamespace
+	}
+	if matched, _ := path.Match(namespacePattern, namespace); !matched {
+		return false
+	}
+
+	pattern := l.Groups
+	if pattern == "" {
+		pattern = "*"
+	}
+	matched, _ := path.Match(pattern, groupId)
+	return matched
+}
+
+// LifecycleRuleOf returns the first lifecycle rule matching a group, nil when none does
+func LifecycleRuleOf(namespace, groupId string) *LifecycleRule {
+	for i, rule := range VaultConfig.Lifecycle {
+		if rule.Matches(namespace, groupId) {
+			return &VaultConfig.Lifecycle[i]
+		}
+	}
+	return nil
+}
+
+// Expired reports whether a record is past its TTL or past the maximum age of the lifecycle rule of its group
+//
+// Elements locked by retention do not expire until the lock is lifted.
+func Expired(record internal.Record, now time.Time) bool {
+	if ElementLocked(record, now) {
+		return false
+	}
+	if internal.IsExpired(record.Attributes, now) {
+		return true
+	}
+
+	rule := LifecycleRuleOf(record.Attributes["namespace"], record.Attributes["groupId"])
+	if rule == nil || rule.MaxAge <= 0 {
+		return false
+	}
+	receivedTime, err := strconv.ParseInt(record.Attributes["receivedTime"], 10, 64)
+	if err != nil {
+		return false
+	}
+	return receivedTime+rule.MaxAge*1000 <= now.UnixMilli()
+}
+
+// ReapExpired deletes the expired elements of the vault and returns how many were deleted
+func ReapExpired(now time.Time) int {
+	reaped := 0
+	for _, record := range VaultConfig.Index.SearchAll([]string{"groupId"}) {
+		if !Expired(record, now) {
+			continue
+		}
+
+		err := DeleteElement(record.Attributes["namespace"], record.Attributes["groupId"], record.Id)
+		if err != nil {
+			log.Printf("Error reaping element %s: %v\n", record.Id, err)
+			continue
+		}
+		reaped++
+	}
+
+	return reaped
+}
+
+// ReapEmptyGroups deletes the folders of the groups left empty whose rule asks for it and returns how many were deleted
+//
+// Folders modified less than grace ago are kept, so that a group created by an
+// upload in progress is not removed before its first element is written.
+func ReapEmptyGroups(now time.Time, grace time.Duration) int {
+	reaped := 0
+	for _, group := range groupFolders() {
+		rule := LifecycleRuleOf(group.namespace, group.groupId)
+		if rule == nil || !rule.DeleteEmpty {
+			continue
+		}
+
+		folder := filepath.Join(VaultConfig.Root, internal.GroupDir(group.namespace, group.groupId))
+		info, err := os.Stat(folder)
+		if err != nil || now.Sub(info.ModTime()) < grace {
+			continue
+		}
+		entries, err := os.ReadDir(folder)
+		if err != nil {
+			continue
+		}
+		// A group left with its metadata or a retention that has passed is empty as well
+		if onlyGroupFiles(entries) && !GroupRetention(group.namespace, group.groupId).Locked(now) {
+			for _, entry := range entries {
+				os.Remove(filepath.Join(folder, entry.Name()))
+			}
+			forgetGroupRetention(group.namespace, group.groupId)
+			entries, _ = os.ReadDir(folder)
+		}
+		if len(entries) > 0 {
+			continue
+		}
+
+		// Remove only deletes empty folders, an element written meanwhile keeps the group
+		err = os.Remove(folder)
+		if err != nil {
+			continue
+		}
+		reaped++
+	}
+
+	return reaped
+}
+
+// onlyGroupFiles reports whether the entries of a group folder are only the files of the group itself, its retention and metadata
+func onlyGroupFiles(entries []os.DirEntry) bool {
+	for _, entry := range entries {
+		if entry.Name() != internal.RetentionFile && entry.Name() != internal.GroupMetaFile {
+			return false
+		}
+	}
+	return len(entries) > 0
+}
+
+// groupFolder is a group folder found in the vault root
+type groupFolder struct {
+	namespace string
+	groupId   string
+}
+
+// groupFolders lists the group folders of every namespace in the vault root
+func groupFolders() []groupFolder {
+	folders := make([]groupFolder, 0)
+	dirs, err := os.ReadDir(VaultConfig.Root)
+	if err != nil {
+		return folders
+	}
+
+	for _, dir := range dirs {
+		if !dir.IsDir() || dir.Name() == internal.TrashDir {
+			continue
+		}
+		namespace, ok := internal.NamespaceOfDir(dir.Name())
+		if !ok {
+			folders = append(folders, groupFolder{namespace: internal.DefaultNamespace, groupId: dir.Name()})
+			continue
+		}
+
+		groupDirs, err := os.ReadDir(filepath.Join(VaultConfig.Root, dir.Name()))
+		if err != nil {
+			continue
+		}
+		for _, groupDir := range groupDirs {
+			if groupDir.IsDir() {
+				folders = append(folders, groupFolder{namespace: namespace, groupId: groupDir.Name()})
+			}
+		}
+	}
+
+	return folders
+}
+
+// Reaper enforces TTLs, lifecycle rules and trash retention every reap interval
+func Reaper() {
+	interval := time.Duration(VaultConfig.ReapInterval) * time.Second
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		now := time.Now()
+		if reaped := ReapExpired(now); reaped > 0 {
+			log.Printf("Reaped %d expired elements\n", reaped)
+		}
+		if reaped := ReapEmptyGroups(now, interval); reaped > 0 {
+			log.Printf("Reaped %d empty groups\n", reaped)
+		}
+		if purged := PurgeExpiredTrash(now); purged > 0 {
+			log.Printf("Purged %d trash items\n", purged)
+		}
+	}
+}
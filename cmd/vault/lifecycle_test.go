@@ -0,0 +1,75 @@
+package vault
+
+import (
+	"datavault/cmd/internal"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestReapExpired(t *testing.T) {
+	testVault(t)
+	VaultConfig.Lifecycle = []LifecycleRule{{Groups: "tmp-*", MaxAge: 60}}
+	uploadIds(t, "tmp-1", "a.txt")
+	uploadIds(t, "keep", "a.txt")
+	held := uploadIds(t, "tmp-2", "a.txt")
+	_, err := SetElementRetention(internal.DefaultNamespace, "tmp-2", held[0], internal.Retention{LegalHold: "true"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := upload(t, url.Values{"groupId": {"ttl"}, internal.TTLParam: {"30"}}, "", "a.txt")
+	if w.Code != http.StatusOK {
+		t.Fatalf("upload status %d: %s", w.Code, w.Body)
+	}
+
+	if reaped := ReapExpired(time.Now()); reaped != 0 {
+		t.Fatalf("reaped %d fresh elements", reaped)
+	}
+
+	// The TTL and the maximum age of the rule have passed, the legal hold keeps its element
+	if reaped := ReapExpired(time.Now().Add(2 * time.Minute)); reaped != 2 {
+		t.Errorf("reaped %d elements, want 2", reaped)
+	}
+	for groupId, want := range map[string]int{"tmp-1": 0, "ttl": 0, "keep": 1, "tmp-2": 1} {
+		if records := VaultConfig.Index.SearchEvery(map[string]string{"groupId": groupId}); len(records) != want {
+			t.Errorf("%d elements left in %s, want %d", len(records), groupId, want)
+		}
+	}
+}
+
+func TestReapEmptyGroups(t *testing.T) {
+	testVault(t)
+	VaultConfig.Lifecycle = []LifecycleRule{{Groups: "tmp-*", DeleteEmpty: true}}
+	for _, groupId := range []string{"tmp-1", "tmp-2", "tmp-3", "keep"} {
+		err := os.MkdirAll(filepath.Join(VaultConfig.Root, groupId), os.ModePerm)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	uploadIds(t, "tmp-2", "a.txt")
+	until := strconv.FormatInt(time.Now().Add(3*time.Hour).UnixMilli(), 10)
+	_, err := SetGroupRetention(internal.DefaultNamespace, "tmp-3", internal.Retention{RetainUntil: until})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Folders within the grace period are kept
+	if reaped := ReapEmptyGroups(time.Now(), time.Hour); reaped != 0 {
+		t.Fatalf("reaped %d groups within the grace period", reaped)
+	}
+
+	// Only the empty folder matching the rule goes, a retained group is not empty
+	if reaped := ReapEmptyGroups(time.Now().Add(2*time.Hour), time.Hour); reaped != 1 {
+		t.Errorf("reaped %d groups, want 1", reaped)
+	}
+	for groupId, kept := range map[string]bool{"tmp-1": false, "tmp-2": true, "tmp-3": true, "keep": true} {
+		_, err := os.Stat(filepath.Join(VaultConfig.Root, groupId))
+		if (err == nil) != kept {
+			t.Errorf("folder of %s kept %v, want %v", groupId, err == nil, kept)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package vault
+
+import (
+	"datavault/cmd/internal"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// noncesFile is the file in the vault root holding the nonces of the one-off upload URLs claimed through the vault
+const noncesFile = "._nonces"
+
+// ErrNonceUsed is returned when the nonce of a one-off upload URL is already claimed or used
+var ErrNonceUsed = errors.New("pre-signed upload URL was already used")
+
+// nonceClaim is the state of the nonce of a one-off upload URL
+type nonceClaim struct {
+	Expires int64 `json:"expires"` // Expiry of the URL in unix seconds, after which the claim is forgotten
+	Used    bool  `json:"used"`    // The upload was committed, false while it is in progress
+}
+
+var (
+	noncesMu sync.Mutex
+	nonces   map[string]nonceClaim // Claimed nonces, loaded from the nonces file on first use
+)
+
+// loadNonces reads the claimed nonces and drops the expired ones, noncesMu must be held
+func loadNonces() error {
+	if nonces == nil {
+		nonces = make(map[string]nonceClaim)
+		content, _, err := internal.ReadBytesFromFile(VaultConfig.Root, "", noncesFile)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			nonces = nil
+			return err
+		}
+		if err == nil {
+			json.Unmarshal(content, &nonces)
+		}
+	}
+
+	now := time.Now().Unix()
+	for nonce, claim := range nonces {
+		if claim.Expires < now {
+			delete(nonces, nonce)
+		}
+	}
+	return nil
+}
+
+// saveNonces writes the claimed nonces to the nonces file, noncesMu must be held
+func saveNonces() error {
+	content, err := json.Marshal(nonces)
+	if err != nil {
+		return err
+	}
+	return internal.ReplaceFile(VaultConfig.Root, "", noncesFile, content)
+}
+
+// updateNonce applies a change to the claim of a nonce and stores it, the change returns false to leave the claims unchanged
+func updateNonce(change func() (bool, error)) error {
+	noncesMu.Lock()
+	defer noncesMu.Unlock()
+
+	err := loadNonces()
+	if err != nil {
+		return err
+	}
+	changed, err := change()
+	if err != nil || !changed {
+		return err
+	}
+	return saveNonces()
+}
+
+// ClaimNonce records an upload in progress for a nonce, unless it is already claimed or used
+func ClaimNonce(nonce string, expires int64) error {
+	return updateNonce(func() (bool, error) {
+		if _, ok := nonces[nonce]; ok {
+			return false, ErrNonceUsed
+		}
+		nonces[nonce] = nonceClaim{Expires: expires}
+		return true, nil
+	})
+}
+
+// CommitNonce marks a nonce used once its upload is committed
+func CommitNonce(nonce string, expires int64) error {
+	return updateNonce(func() (bool, error) {
+		nonces[nonce] = nonceClaim{Expires: expires, Used: true}
+		return true, nil
+	})
+}
+
+// ReleaseNonce forgets the claim of a nonce whose upload failed, a used nonce stays used
+func ReleaseNonce(nonce string) error {
+	return updateNonce(func() (bool, error) {
+		claim, ok := nonces[nonce]
+		if !ok || claim.Used {
+			return false, nil
+		}
+		delete(nonces, nonce)
+		return true, nil
+	})
+}
+
+// HandlerNonce claims (POST), commits (PUT) or releases (DELETE) the nonce of a one-off upload URL
+func HandlerNonce(w http.ResponseWriter, r *http.Request) {
+	nonce := r.URL.Query().Get(internal.NonceParam)
+	if !validateString(nonce) {
+		http.Error(w, "Invalid nonce", http.StatusBadRequest)
+		return
+	}
+	expires, err := strconv.ParseInt(r.URL.Query().Get(internal.ExpiresParam), 10, 64)
+	if err != nil && r.Method != http.MethodDelete {
+		http.Error(w, "Invalid expiry", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		err = ClaimNonce(nonce, expires)
+	case http.MethodPut:
+		err = CommitNonce(nonce, expires)
+	default:
+		err = ReleaseNonce(nonce)
+	}
+	if errors.Is(err, ErrNonceUsed) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
@@ -2,87 +2,223 @@ package vault
 
 import (
 	"datavault/cmd/internal"
+	"errors"
 	"fmt"
+	"log"
 	"mime/multipart"
+	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
 )
 
-// GetGroups returns list of all groups in the vault
-func GetGroups() []string {
+// ErrRecordNotFound is returned when a record is not in the vault index
+var ErrRecordNotFound = errors.New("record not found")
+
+// CountGroups returns the number of groups in the vault, across namespaces
+func CountGroups() int {
 	records := VaultConfig.Index.SearchAll([]string{"groupId"})
 	groups := make(map[string]struct{}, 0)
 	for _, record := range records {
-		groups[record.Attributes["groupId"]] = struct{}{}
+		groups[internal.GroupDir(record.Attributes["namespace"], record.Attributes["groupId"])] = struct{}{}
+	}
+
+	return len(groups)
+}
+
+// GetGroupsPage returns the groups of a namespace after a cursor, sorted by group id, with their element counts and sizes
+func GetGroupsPage(namespace, after string, limit int) internal.GroupsPage {
+	records := VaultConfig.Index.SearchEvery(map[string]string{"namespace": namespace})
+	summaries := make(map[string]*internal.GroupSummary)
+	for _, record := range records {
+		groupId := record.Attributes["groupId"]
+		if groupId <= after {
+			continue
+		}
+
+		summary, ok := summaries[groupId]
+		if !ok {
+			summary = &internal.GroupSummary{GroupId: groupId}
+			summaries[groupId] = summary
+		}
+		summary.Elements++
+		size, _ := strconv.ParseInt(record.Attributes["fileSize"], 10, 64)
+		summary.Size += size
 	}
 
-	groupsList := make([]string, 0)
-	for group := range groups {
-		groupsList = append(groupsList, group)
+	page := internal.GroupsPage{Groups: make([]internal.GroupSummary, 0, min(len(summaries), limit))}
+	for _, summary := range summaries {
+		page.Groups = append(page.Groups, *summary)
 	}
+	sort.Slice(page.Groups, func(i, j int) bool {
+		return page.Groups[i].GroupId < page.Groups[j].GroupId
+	})
 
-	return groupsList
+	if len(page.Groups) > limit {
+		page.Groups = page.Groups[:limit]
+		page.Next = page.Groups[limit-1].GroupId
+	}
+
+	return page
 }
 
-// PutGroup uploads records into the vault
-func PutGroup(groupId string, files []*multipart.FileHeader) error {
-	metadata, err := internal.ProcessMultipartFiles(files, groupId, VaultConfig.Root)
+// PutGroup uploads records into a group of a namespace, preset optionally fixes the ids of the elements
+func PutGroup(namespace, groupId string, files []*multipart.FileHeader, preset []internal.Meta) ([]internal.Meta, error) {
+	for _, meta := range preset {
+		if meta.FileId == "" {
+			continue
+		}
+		err := checkElementId(internal.GroupDir(namespace, groupId), meta.FileId)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err := checkKeysOverwritable(namespace, groupId, preset, time.Now())
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	metadata, err := internal.ProcessMultipartFiles(files, namespace, groupId, VaultConfig.Root, preset)
+	if err != nil {
+		return nil, err
 	}
 
 	//create records
+	keys := make(map[string]bool)
 	for _, meta := range metadata {
-		record := internal.Record{
-			Id: meta.FileId,
-			Attributes: map[string]string{
-				"fileId":        meta.FileId,
-				"fileName":      meta.FileName,
-				"fileExtension": meta.FileExtension,
-				"fileType":      meta.FileType,
-				"fileSize":      meta.FileSize,
-				"receivedTime":  meta.ReceivedTime,
-				"groupId":       groupId,
-			},
+		AddRecord(metaRecord(meta))
+		if meta.Key != "" {
+			keys[meta.Key] = true
 		}
-		VaultConfig.Index.Add(record)
 	}
 
+	// Drop the versions of the uploaded keys the group does not keep
+	for key := range keys {
+		err := PruneVersions(namespace, groupId, key, KeptVersions(namespace, groupId))
+		if err != nil {
+			log.Printf("Error pruning versions of key %s: %v\n", key, err)
+		}
+	}
+
+	return metadata, nil
+}
+
+// checkElementId returns ErrElementExists when an element id is taken, by a meta file of the group folder or by an indexed element of any group
+func checkElementId(dir, elementId string) error {
+	_, err := os.Stat(filepath.Join(VaultConfig.Root, dir, elementId+"._meta"))
+	if err == nil || VaultConfig.Index.GetAttributes(elementId) != nil {
+		return fmt.Errorf("%w: %s", internal.ErrElementExists, elementId)
+	}
 	return nil
 }
 
-// FilterByGroup returns list of all records in the vault
-func FilterByGroup(groupId string) []internal.Record {
-	records := VaultConfig.Index.SearchAny(map[string]string{"groupId": groupId})
-	return records
+// metaRecord returns the index record of an element from its metadata
+func metaRecord(meta internal.Meta) internal.Record {
+	record := internal.Record{
+		Id: meta.FileId,
+		Attributes: map[string]string{
+			"fileId":        meta.FileId,
+			"fileName":      meta.FileName,
+			"fileExtension": meta.FileExtension,
+			"fileType":      meta.FileType,
+			"fileSize":      meta.FileSize,
+			"receivedTime":  meta.ReceivedTime,
+			"groupId":       meta.GroupId,
+			"namespace":     meta.Namespace,
+			"checksum":      meta.Checksum,
+		},
+	}
+	if meta.ExpiresAt != "" {
+		record.Attributes["expiresAt"] = meta.ExpiresAt
+	}
+	if meta.Key != "" {
+		record.Attributes["key"] = meta.Key
+	}
+	if meta.Path != "" {
+		record.Attributes["path"] = meta.Path
+	}
+	if meta.RetainUntil != "" {
+		record.Attributes["retainUntil"] = meta.RetainUntil
+		record.Attributes["retentionMode"] = meta.RetentionMode
+	}
+	if meta.LegalHold != "" {
+		record.Attributes["legalHold"] = meta.LegalHold
+	}
+	return record
+}
+
+// FilterByGroup returns list of all records of a group in a namespace, expired records are left out
+func FilterByGroup(namespace, groupId string) []internal.Record {
+	records := VaultConfig.Index.SearchEvery(map[string]string{"namespace": namespace, "groupId": groupId})
+	now := time.Now()
+	live := make([]internal.Record, 0, len(records))
+	for _, record := range records {
+		if !Expired(record, now) {
+			live = append(live, record)
+		}
+	}
+	return live
+}
+
+// DeleteGroup deletes the folder of a group and its records, unless the group or one of its elements is locked by retention
+func DeleteGroup(namespace, groupId string) error {
+	retentionUpdates.Lock()
+	defer retentionUpdates.Unlock()
+
+	err := checkGroupDeletable(namespace, groupId, time.Now())
+	if err != nil {
+		return err
+	}
+
+	err = internal.DeleteDirectory(VaultConfig.Root, internal.GroupDir(namespace, groupId))
+	if err != nil {
+		return err
+	}
+	forgetGroupRetention(namespace, groupId)
+	unindexGroup(namespace, groupId)
+	emitGroupDeleted(namespace, groupId)
+	return nil
 }
 
-// DeleteGroup deletes a group and all its records from the vault
-func DeleteGroup(groupId string) {
-	records := VaultConfig.Index.SearchAny(map[string]string{"groupId": groupId})
+// unindexGroup removes all the records of a group from the vault index
+func unindexGroup(namespace, groupId string) {
+	records := VaultConfig.Index.SearchEvery(map[string]string{"namespace": namespace, "groupId": groupId})
 	for _, record := range records {
-		VaultConfig.Index.Remove(internal.Record{
-			Id:         record.Id,
-			Attributes: record.Attributes,
-		})
+		RemoveRecord(record)
 	}
 }
 
-// FilterByGroupElement returns a record from a group-element pair
-func FilterByGroupElement(groupId, elementId string) (internal.Record, error) {
-	records := VaultConfig.Index.SearchAny(map[string]string{"groupId": groupId, "fileId": elementId})
+// findElement returns a record from a group-element pair of a namespace, expired or not
+func findElement(namespace, groupId, elementId string) (internal.Record, error) {
+	records := VaultConfig.Index.SearchEvery(map[string]string{"namespace": namespace, "groupId": groupId, "fileId": elementId})
 	if len(records) == 0 {
-		return internal.Record{}, fmt.Errorf("record not found")
+		return internal.Record{}, ErrRecordNotFound
 	}
 	return records[0], nil
 }
 
-// GetElement return a file associated with a record
-func GetElement(recordId string) (string, error) {
+// FilterByGroupElement returns a record from a group-element pair of a namespace, an expired record is not found
+func FilterByGroupElement(namespace, groupId, elementId string) (internal.Record, error) {
+	record, err := findElement(namespace, groupId, elementId)
+	if err != nil {
+		return internal.Record{}, err
+	}
+	if Expired(record, time.Now()) {
+		return internal.Record{}, ErrRecordNotFound
+	}
+	return record, nil
+}
 
-	attributes := VaultConfig.Index.GetAttributes(recordId)
+// GetElement return a file associated with a record of a group
+func GetElement(namespace, groupId, recordId string) (string, error) {
+	record, err := FilterByGroupElement(namespace, groupId, recordId)
+	if err != nil {
+		return "", err
+	}
 
-	dirId := attributes["groupId"]
-	fileId := attributes["fileId"] + attributes["fileExtension"]
+	dirId := internal.GroupDir(namespace, groupId)
+	fileId := record.Attributes["fileId"] + record.Attributes["fileExtension"]
 
 	path, err := filepath.Abs(filepath.Join(VaultConfig.Root, dirId, fileId))
 	if err != nil {
@@ -92,16 +228,29 @@ func GetElement(recordId string) (string, error) {
 	return path, nil
 }
 
-// DeleteElement deletes a record from the vault
-func DeleteElement(recordId string) error {
-	record := VaultConfig.Index.Get(recordId)
-	if record.Id == "" {
-		return fmt.Errorf("record not found")
+// DeleteElement deletes a record of a group from the vault, unless it is locked by retention
+func DeleteElement(namespace, groupId, recordId string) error {
+	retentionUpdates.Lock()
+	defer retentionUpdates.Unlock()
+
+	record, err := findElement(namespace, groupId, recordId)
+	if err != nil {
+		return err
 	}
+	err = checkElementDeletable(record, time.Now())
+	if err != nil {
+		return err
+	}
+	return removeElement(namespace, groupId, record)
+}
 
-	VaultConfig.Index.Remove(record)
+// removeElement removes a record and its files from the vault, retentionUpdates must be held
+func removeElement(namespace, groupId string, record internal.Record) error {
+	if !RemoveRecord(record) {
+		return ErrRecordNotFound
+	}
 
-	dirId := record.Attributes["groupId"]
+	dirId := internal.GroupDir(namespace, groupId)
 	fileId := record.Attributes["fileId"] + record.Attributes["fileExtension"]
 	metafileId := record.Attributes["fileId"] + "._meta"
 
@@ -117,3 +266,37 @@ func DeleteElement(recordId string) error {
 
 	return nil
 }
+
+// Stats is the capacity and usage report of the vault
+type Stats struct {
+	Id       string `json:"id"`       // Vault identifier
+	Capacity uint64 `json:"capacity"` // Total size of the vault filesystem in bytes
+	Free     uint64 `json:"free"`     // Free bytes on the vault filesystem
+	Used     uint64 `json:"used"`     // Used bytes on the vault filesystem
+	Files    int    `json:"files"`    // Number of elements stored in the vault
+	Groups   int    `json:"groups"`   // Number of groups stored in the vault
+	ReadOnly bool   `json:"readOnly"` // Whether the vault rejects uploads
+}
+
+// GetStats returns the capacity and usage of the vault
+func GetStats() (Stats, error) {
+	usage, err := internal.GetDiskUsage(VaultConfig.Root)
+	if err != nil {
+		return Stats{}, err
+	}
+
+	readOnly, err := CheckWatermarks()
+	if err != nil {
+		return Stats{}, err
+	}
+
+	return Stats{
+		Id:       VaultConfig.Id,
+		Capacity: usage.Capacity,
+		Free:     usage.Free,
+		Used:     usage.Used,
+		Files:    VaultConfig.Index.Len(),
+		Groups:   CountGroups(),
+		ReadOnly: readOnly,
+	}, nil
+}
@@ -0,0 +1,42 @@
+package vault
+
+import (
+	"datavault/cmd/internal"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestGetGroupsPage(t *testing.T) {
+	testVault(t)
+	for _, query := range []url.Values{
+		{"groupId": {"g2"}},
+		{"groupId": {"g1"}},
+		{"groupId": {"g3"}},
+		{"groupId": {"g0"}, "namespace": {"team"}},
+	} {
+		w := upload(t, query, "", "a.txt", "bb.txt")
+		if w.Code != http.StatusOK {
+			t.Fatalf("upload status %d: %s", w.Code, w.Body)
+		}
+	}
+
+	page := GetGroupsPage(internal.DefaultNamespace, "", 2)
+	if len(page.Groups) != 2 || page.Groups[0].GroupId != "g1" || page.Groups[1].GroupId != "g2" || page.Next != "g2" {
+		t.Fatalf("first page %+v, want g1 and g2 with a cursor", page)
+	}
+	summary := page.Groups[0]
+	if summary.Elements != 2 || summary.Size != int64(len("content of a.txt")+len("content of bb.txt")) {
+		t.Errorf("summary %+v does not count the elements of the group", summary)
+	}
+
+	page = GetGroupsPage(internal.DefaultNamespace, page.Next, 2)
+	if len(page.Groups) != 1 || page.Groups[0].GroupId != "g3" || page.Next != "" {
+		t.Errorf("last page %+v, want g3 without a cursor", page)
+	}
+
+	page = GetGroupsPage("team", "", 2)
+	if len(page.Groups) != 1 || page.Groups[0].GroupId != "g0" {
+		t.Errorf("namespace page %+v, want g0 only", page)
+	}
+}
@@ -0,0 +1,169 @@
+package vault
+
+import (
+	"datavault/cmd/internal"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+var (
+	retentionMu      sync.Mutex
+	groupRetentions  = make(map[string]internal.Retention) // Retention of the groups read so far, by group folder
+	retentionUpdates sync.Mutex                            // Serializes retention updates with the deletes checking them
+)
+
+// GroupRetention returns the retention of a group, read from its folder once and then kept in memory
+func GroupRetention(namespace, groupId string) internal.Retention {
+	dir := internal.GroupDir(namespace, groupId)
+	retentionMu.Lock()
+	defer retentionMu.Unlock()
+
+	if retention, ok := groupRetentions[dir]; ok {
+		return retention
+	}
+
+	var retention internal.Retention
+	content, _, err := internal.ReadBytesFromFile(VaultConfig.Root, dir, internal.RetentionFile)
+	if err == nil {
+		json.Unmarshal(content, &retention)
+	}
+	groupRetentions[dir] = retention
+	return retention
+}
+
+// forgetGroupRetention drops the retention of a group kept in memory, after its folder was moved or removed
+func forgetGroupRetention(namespace, groupId string) {
+	retentionMu.Lock()
+	defer retentionMu.Unlock()
+	delete(groupRetentions, internal.GroupDir(namespace, groupId))
+}
+
+// ElementLocked reports whether the retention of an element or of its group forbids deleting or overwriting it
+func ElementLocked(record internal.Record, now time.Time) bool {
+	if internal.RetentionOf(record.Attributes).Locked(now) {
+		return true
+	}
+	return GroupRetention(record.Attributes["namespace"], record.Attributes["groupId"]).Locked(now)
+}
+
+// checkElementDeletable returns ErrRetentionLocked when an element cannot be deleted
+func checkElementDeletable(record internal.Record, now time.Time) error {
+	if ElementLocked(record, now) {
+		return fmt.Errorf("%w: element %s", internal.ErrRetentionLocked, record.Id)
+	}
+	return nil
+}
+
+// checkGroupDeletable returns ErrRetentionLocked when a group or one of its elements cannot be deleted
+func checkGroupDeletable(namespace, groupId string, now time.Time) error {
+	if GroupRetention(namespace, groupId).Locked(now) {
+		return fmt.Errorf("%w: group %s", internal.ErrRetentionLocked, groupId)
+	}
+	for _, record := range VaultConfig.Index.SearchEvery(map[string]string{"namespace": namespace, "groupId": groupId}) {
+		if err := checkElementDeletable(record, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkKeysOverwritable returns ErrRetentionLocked when an upload would replace a locked version of one of its keys
+//
+// Only groups keeping a single version replace the versions of a key, versioned
+// groups keep locked versions when pruning.
+func checkKeysOverwritable(namespace, groupId string, preset []internal.Meta, now time.Time) error {
+	if KeptVersions(namespace, groupId) != 1 {
+		return nil
+	}
+	for _, meta := range preset {
+		if meta.Key == "" {
+			continue
+		}
+		for _, version := range Versions(namespace, groupId, meta.Key) {
+			if ElementLocked(version, now) {
+				return fmt.Errorf("%w: key %s", internal.ErrRetentionLocked, meta.Key)
+			}
+		}
+	}
+	return nil
+}
+
+// SetGroupRetention applies a retention change to a group and stores it in the group folder
+func SetGroupRetention(namespace, groupId string, change internal.Retention) (internal.Retention, error) {
+	retentionUpdates.Lock()
+	defer retentionUpdates.Unlock()
+
+	updated, err := GroupRetention(namespace, groupId).Update(change, time.Now())
+	if err != nil {
+		return internal.Retention{}, err
+	}
+
+	dir := internal.GroupDir(namespace, groupId)
+	err = os.MkdirAll(filepath.Join(VaultConfig.Root, dir), os.ModePerm)
+	if err != nil {
+		return internal.Retention{}, err
+	}
+	content, err := json.Marshal(updated)
+	if err != nil {
+		return internal.Retention{}, err
+	}
+	err = internal.ReplaceFile(VaultConfig.Root, dir, internal.RetentionFile, content)
+	if err != nil {
+		return internal.Retention{}, err
+	}
+
+	retentionMu.Lock()
+	groupRetentions[dir] = updated
+	retentionMu.Unlock()
+	return updated, nil
+}
+
+// SetElementRetention applies a retention change to an element, rewriting its meta file and its index record
+func SetElementRetention(namespace, groupId, elementId string, change internal.Retention) (internal.Record, error) {
+	retentionUpdates.Lock()
+	defer retentionUpdates.Unlock()
+
+	record, err := findElement(namespace, groupId, elementId)
+	if err != nil {
+		return internal.Record{}, err
+	}
+	updated, err := internal.RetentionOf(record.Attributes).Update(change, time.Now())
+	if err != nil {
+		return internal.Record{}, err
+	}
+
+	dir := internal.GroupDir(namespace, groupId)
+	content, _, err := internal.ReadBytesFromFile(VaultConfig.Root, dir, elementId+"._meta")
+	if errors.Is(err, os.ErrNotExist) {
+		return internal.Record{}, ErrRecordNotFound
+	}
+	if err != nil {
+		return internal.Record{}, err
+	}
+	var meta internal.Meta
+	err = json.Unmarshal(content, &meta)
+	if err != nil {
+		return internal.Record{}, err
+	}
+	if meta.Namespace == "" {
+		meta.Namespace = namespace
+	}
+	meta.Retention = updated
+	content, err = json.Marshal(meta)
+	if err != nil {
+		return internal.Record{}, err
+	}
+	err = internal.ReplaceFile(VaultConfig.Root, dir, elementId+"._meta", content)
+	if err != nil {
+		return internal.Record{}, err
+	}
+
+	replaced := metaRecord(meta)
+	ReplaceRecord(record, replaced)
+	return replaced, nil
+}
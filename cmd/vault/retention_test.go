@@ -0,0 +1,89 @@
+package vault
+
+import (
+	"datavault/cmd/internal"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestTrashElementRetention(t *testing.T) {
+	testVault(t)
+	VaultConfig.TrashRetention = 3600
+	ids := uploadIds(t, "g", "a.txt")
+
+	_, err := SetElementRetention(internal.DefaultNamespace, "g", ids[0], internal.Retention{LegalHold: "true"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = TrashElement(internal.DefaultNamespace, "g", ids[0], "t1")
+	if !errors.Is(err, internal.ErrRetentionLocked) {
+		t.Fatalf("trashed an element under legal hold: %v", err)
+	}
+	if len(groupRecords("g")) != 1 {
+		t.Fatal("element under legal hold left the index")
+	}
+
+	// Lifting the hold lets the element go to the trash
+	_, err = SetElementRetention(internal.DefaultNamespace, "g", ids[0], internal.Retention{LegalHold: "false"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = TrashElement(internal.DefaultNamespace, "g", ids[0], "t1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groupRecords("g")) != 0 {
+		t.Error("trashed element still indexed")
+	}
+}
+
+func TestTrashGroupRetention(t *testing.T) {
+	testVault(t)
+	VaultConfig.TrashRetention = 3600
+	ids := uploadIds(t, "g", "a.txt")
+
+	until := strconv.FormatInt(time.Now().Add(time.Hour).UnixMilli(), 10)
+	_, err := SetGroupRetention(internal.DefaultNamespace, "g", internal.Retention{RetainUntil: until})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The retention of a group locks its elements too
+	_, err = TrashElement(internal.DefaultNamespace, "g", ids[0], "t1")
+	if !errors.Is(err, internal.ErrRetentionLocked) {
+		t.Errorf("trashed an element of a retained group: %v", err)
+	}
+	_, err = TrashGroup(internal.DefaultNamespace, "g", "t2")
+	if !errors.Is(err, internal.ErrRetentionLocked) {
+		t.Errorf("trashed a retained group: %v", err)
+	}
+	if len(groupRecords("g")) != 1 {
+		t.Error("element of a retained group left the index")
+	}
+}
+
+func TestPutGroupRetention(t *testing.T) {
+	testVault(t)
+	locked := url.Values{"groupId": {"g"}, internal.KeyParam: {"report"}, internal.LegalHoldParam: {"true"}}
+	w := upload(t, locked, "", "a.txt")
+	if w.Code != http.StatusOK {
+		t.Fatalf("upload status %d: %s", w.Code, w.Body)
+	}
+
+	// A single-version group cannot replace a locked version of a key
+	w = upload(t, url.Values{"groupId": {"g"}, internal.KeyParam: {"report"}}, "", "b.txt")
+	if w.Code != http.StatusLocked {
+		t.Errorf("overwrite status %d, want %d: %s", w.Code, http.StatusLocked, w.Body)
+	}
+	w = upload(t, url.Values{"groupId": {"g"}, internal.KeyParam: {"other"}}, "", "b.txt")
+	if w.Code != http.StatusOK {
+		t.Errorf("upload of another key status %d: %s", w.Code, w.Body)
+	}
+	if records := groupRecords("g"); len(records) != 2 {
+		t.Errorf("%d elements indexed, want 2", len(records))
+	}
+}
@@ -0,0 +1,154 @@
+package vault
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+)
+
+// ringRecordFile is the file in the vault root holding the ring record shared by the gatekeepers
+const ringRecordFile = "._ring"
+
+// ringMu serializes updates of the ring record
+var ringMu sync.Mutex
+
+// ringVersion is the version of the stored ring record, 0 when there is none
+var ringVersion uint64
+
+// ErrStaleRingRecord is returned when a ring record does not supersede the stored one
+var ErrStaleRingRecord = errors.New("ring record version is not newer than the stored one")
+
+// LoadRingRecord returns the stored ring record, or nil when there is none
+func LoadRingRecord() ([]byte, error) {
+	data, err := os.ReadFile(filepath.Join(VaultConfig.Root, ringRecordFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	return data, err
+}
+
+// InitRingRecord reads the version of the stored ring record
+func InitRingRecord() error {
+	data, err := LoadRingRecord()
+	if err != nil || data == nil {
+		return err
+	}
+
+	var record struct {
+		Version uint64 `json:"version"`
+	}
+	err = json.Unmarshal(data, &record)
+	if err != nil {
+		return err
+	}
+
+	ringMu.Lock()
+	defer ringMu.Unlock()
+	ringVersion = record.Version
+	return nil
+}
+
+// StoreRingRecord stores a ring record if its version is newer than the stored one
+//
+// The vault keeps the record opaque, only its version is compared.
+func StoreRingRecord(data []byte) error {
+	var record struct {
+		Version uint64 `json:"version"`
+	}
+	err := json.Unmarshal(data, &record)
+	if err != nil {
+		return err
+	}
+
+	ringMu.Lock()
+	defer ringMu.Unlock()
+
+	if record.Version <= ringVersion {
+		return ErrStaleRingRecord
+	}
+
+	// Write to a temporary file first so the record is replaced atomically
+	path := filepath.Join(VaultConfig.Root, ringRecordFile)
+	err = os.WriteFile(path+".tmp", data, 0644)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(path+".tmp", path)
+	if err != nil {
+		return err
+	}
+
+	ringVersion = record.Version
+	return nil
+}
+
+// CurrentRingVersion returns the version of the stored ring record
+func CurrentRingVersion() uint64 {
+	ringMu.Lock()
+	defer ringMu.Unlock()
+	return ringVersion
+}
+
+// HandlerRingGet returns the stored ring record
+func HandlerRingGet(w http.ResponseWriter, r *http.Request) {
+	data, err := LoadRingRecord()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if data == nil {
+		data = []byte(`{"version":0}`)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// HandlerRingPut stores a ring record that supersedes the stored one
+func HandlerRingPut(w http.ResponseWriter, r *http.Request) {
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = StoreRingRecord(data)
+	if errors.Is(err, ErrStaleRingRecord) {
+		w.Header().Set("X-Ring-Version", strconv.FormatUint(CurrentRingVersion(), 10))
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// RequireRingVersion rejects requests routed with a ring older than the stored ring record
+//
+// Gatekeepers send the version of their ring in the X-Ring-Version header,
+// requests without it are served. The ring record itself is always served so
+// that stale gatekeepers can catch up.
+func RequireRingVersion(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("X-Ring-Version")
+		if header != "" && r.URL.Path != "/ring" {
+			version, err := strconv.ParseUint(header, 10, 64)
+			current := CurrentRingVersion()
+			if err != nil || version < current {
+				w.Header().Set("X-Ring-Version", strconv.FormatUint(current, 10))
+				http.Error(w, "stale ring version", http.StatusServiceUnavailable)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
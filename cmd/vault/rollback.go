@@ -0,0 +1,73 @@
+package vault
+
+import (
+	"datavault/cmd/internal"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// rollbackWindow is how long the elements stored by a gatekeeper request can be rolled back despite their retention
+const rollbackWindow = 10 * time.Minute
+
+// rollbackEntry is an element stored by a gatekeeper request that may still be rolled back
+type rollbackEntry struct {
+	token   string    // Token of the request that stored the element
+	expires time.Time // Time after which the element can no longer be rolled back
+}
+
+var (
+	rollbackMu sync.Mutex
+	rollbacks  = make(map[string]rollbackEntry) // Elements that may be rolled back, by group folder and element id
+)
+
+// rememberRollback records the elements stored by a request carrying a rollback token
+func rememberRollback(r *http.Request, namespace, groupId string, elementIds ...string) {
+	token := r.URL.Query().Get(internal.RollbackParam)
+	if token == "" {
+		return
+	}
+
+	rollbackMu.Lock()
+	defer rollbackMu.Unlock()
+
+	now := time.Now()
+	for key, entry := range rollbacks {
+		if now.After(entry.expires) {
+			delete(rollbacks, key)
+		}
+	}
+	for _, elementId := range elementIds {
+		rollbacks[filepath.Join(internal.GroupDir(namespace, groupId), elementId)] = rollbackEntry{token: token, expires: now.Add(rollbackWindow)}
+	}
+}
+
+// RollbackElement deletes an element stored by the request of a rollback token, even when it is locked by retention
+//
+// Elements stored by another request, or longer ago than the rollback window,
+// are deleted only when their retention allows it.
+func RollbackElement(namespace, groupId, recordId, token string) error {
+	retentionUpdates.Lock()
+	defer retentionUpdates.Unlock()
+
+	record, err := findElement(namespace, groupId, recordId)
+	if err != nil {
+		return err
+	}
+
+	key := filepath.Join(internal.GroupDir(namespace, groupId), recordId)
+	rollbackMu.Lock()
+	entry, ok := rollbacks[key]
+	stored := ok && entry.token == token && time.Now().Before(entry.expires)
+	if stored {
+		delete(rollbacks, key)
+	}
+	rollbackMu.Unlock()
+
+	if !stored && ElementLocked(record, time.Now()) {
+		return fmt.Errorf("%w: element %s", internal.ErrRetentionLocked, record.Id)
+	}
+	return removeElement(namespace, groupId, record)
+}
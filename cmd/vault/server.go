@@ -1,6 +1,7 @@
 package vault
 
 import (
+	"datavault/cmd/internal"
 	"log"
 	"net/http"
 	"os"
@@ -10,25 +11,62 @@ import (
 func Server() {
 	mux := http.NewServeMux()
 
-	mux.HandleFunc("GET /ping", HandlerPing) // Ping the vault server
+	mux.HandleFunc("GET /ping", HandlerPing)     // Ping the vault server
+	mux.HandleFunc("GET /stats", HandlerStats)   // Get capacity and usage of the vault
+	mux.HandleFunc("GET /usage", HandlerUsage)   // Get the usage of every group
+	mux.HandleFunc("GET /audit", HandlerAudit)   // Get entries of the audit log
+	mux.HandleFunc("GET /events", HandlerEvents) // Get the change events following a cursor
+
+	mux.HandleFunc("GET /ring", HandlerRingGet) // Get the ring record shared by the gatekeepers
+	mux.HandleFunc("PUT /ring", HandlerRingPut) // Store a newer ring record
+
+	mux.HandleFunc("POST /nonces", HandlerNonce)   // Claim the nonce of a one-off upload URL
+	mux.HandleFunc("PUT /nonces", HandlerNonce)    // Mark the nonce of a committed upload used
+	mux.HandleFunc("DELETE /nonces", HandlerNonce) // Release the nonce of a failed upload
 
 	mux.HandleFunc("GET /groups", HandlerGroups)        // Get all groups
-	mux.HandleFunc("GET /group", HandlerGroup)          // Get all records in a group
+	mux.HandleFunc("GET /group", HandlerGroup)          // Get all records in a group (HEAD checks existence)
 	mux.HandleFunc("PUT /group", HandlerGroupUpload)    // Upload files into a group
 	mux.HandleFunc("DELETE /group", HandlerGroupDelete) // Delete a group
 
+	mux.HandleFunc("GET /group/archive", HandlerGroupArchive) // Download the elements of a group as a ZIP or tar archive
+	mux.HandleFunc("POST /group/copy", HandlerGroupCopy)      // Copy elements of another group or vault into a group
+	mux.HandleFunc("POST /group/rename", HandlerGroupRename)  // Rename a group held by the vault
+
+	mux.HandleFunc("GET /group/meta", HandlerGroupMeta)    // Get the metadata of a group
+	mux.HandleFunc("PUT /group/meta", HandlerGroupMetaPut) // Store the metadata of a group
+
 	mux.HandleFunc("GET /group/element", HandlerElementGet)      // Get an element
 	mux.HandleFunc("DELETE /group/element", HandleElementDelete) // Delete an element
 
+	mux.HandleFunc("GET /group/element/versions", HandlerElementVersions) // Get the versions of a key
+	mux.HandleFunc("POST /group/element/restore", HandlerElementRestore)  // Restore a version of a key as its newest version
+
+	mux.HandleFunc("GET /group/retention", HandlerGroupRetention)              // Get the retention of a group
+	mux.HandleFunc("PUT /group/retention", HandlerGroupRetentionPut)           // Change the retention or legal hold of a group
+	mux.HandleFunc("PUT /group/element/retention", HandlerElementRetentionPut) // Change the retention or legal hold of an element
+
+	mux.HandleFunc("GET /trash", HandlerTrash)                 // Get the deleted groups and elements kept in the trash
+	mux.HandleFunc("POST /trash/restore", HandlerTrashRestore) // Restore a trash item into its group
+	mux.HandleFunc("DELETE /trash", HandlerTrashPurge)         // Delete a trash item for good
+
 	// setup server
 	server := &http.Server{
 		Addr:     ":" + VaultConfig.Port,
-		Handler:  mux,
+		Handler:  internal.AuditRequests(VaultConfig.AuditLog, RequireGatekeeper(RequirePresignedURL(RequireRingVersion(mux)))),
 		ErrorLog: log.New(os.Stderr, "http: ", log.LstdFlags),
 	}
 
 	//Start the vault server
 	log.Println("Starting vault server...")
 	log.Println("Listening on port", VaultConfig.Port)
+	if VaultConfig.TLS != nil {
+		tlsConfig, err := VaultConfig.TLS.ServerConfig()
+		if err != nil {
+			log.Fatalf("Error configuring TLS: %v\n", err)
+		}
+		server.TLSConfig = tlsConfig
+		log.Fatal(server.ListenAndServeTLS("", ""))
+	}
 	log.Fatal(server.ListenAndServe())
 }
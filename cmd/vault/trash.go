@@ -0,0 +1,358 @@
+package vault
+
+import (
+	"datavault/cmd/internal"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrTrashItemExists is returned when a trash item id is already in use
+var ErrTrashItemExists = errors.New("trash item already exists")
+
+// trashMu serializes the moves in and out of the trash
+var trashMu sync.Mutex
+
+// TrashEnabled reports whether deletes move groups and elements to the trash instead of deleting them
+func TrashEnabled() bool {
+	return VaultConfig.TrashRetention > 0
+}
+
+// trashItemDir returns the folder of a trash item relative to the vault root
+func trashItemDir(trashId string) string {
+	return filepath.Join(internal.TrashDir, trashId)
+}
+
+// newTrashItem creates the folder of a trash item and returns its manifest
+//
+// The folder holds the manifest and a files folder with the deleted data and
+// meta files. An existing item is not replaced.
+func newTrashItem(trashId, kind, namespace, groupId string, records []internal.Record) (internal.TrashItem, error) {
+	if trashId == "" {
+		trashId = strings.ReplaceAll(uuid.New().String(), "-", "")
+	}
+
+	err := os.MkdirAll(filepath.Join(VaultConfig.Root, internal.TrashDir), os.ModePerm)
+	if err != nil {
+		return internal.TrashItem{}, err
+	}
+	err = os.Mkdir(filepath.Join(VaultConfig.Root, trashItemDir(trashId)), os.ModePerm)
+	if errors.Is(err, os.ErrExist) {
+		return internal.TrashItem{}, fmt.Errorf("%w: %s", ErrTrashItemExists, trashId)
+	}
+	if err != nil {
+		return internal.TrashItem{}, err
+	}
+
+	now := time.Now()
+	item := internal.TrashItem{
+		Id:        trashId,
+		Kind:      kind,
+		Namespace: namespace,
+		GroupId:   groupId,
+		Elements:  make([]string, 0, len(records)),
+		DeletedAt: now.UnixMilli(),
+		PurgeAt:   now.Add(time.Duration(VaultConfig.TrashRetention) * time.Second).UnixMilli(),
+	}
+	for _, record := range records {
+		size, _ := strconv.ParseInt(record.Attributes["fileSize"], 10, 64)
+		item.Elements = append(item.Elements, record.Id)
+		item.Size += size
+	}
+
+	return item, nil
+}
+
+// saveTrashManifest writes the manifest of a trash item
+func saveTrashManifest(item internal.TrashItem) error {
+	manifest, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	return internal.SaveBytesToFile(VaultConfig.Root, trashItemDir(item.Id), "manifest.json", manifest)
+}
+
+// TrashGroup moves a group and its elements to the trash
+//
+// trashId is assigned by the gatekeeper so that the replicas of the group share
+// it, it is generated when empty. A group without folder is not trashed and an
+// empty item is returned.
+func TrashGroup(namespace, groupId, trashId string) (internal.TrashItem, error) {
+	trashMu.Lock()
+	defer trashMu.Unlock()
+	retentionUpdates.Lock()
+	defer retentionUpdates.Unlock()
+
+	folder := filepath.Join(VaultConfig.Root, internal.GroupDir(namespace, groupId))
+	if _, err := os.Stat(folder); errors.Is(err, os.ErrNotExist) {
+		return internal.TrashItem{}, nil
+	}
+	err := checkGroupDeletable(namespace, groupId, time.Now())
+	if err != nil {
+		return internal.TrashItem{}, err
+	}
+
+	records := VaultConfig.Index.SearchEvery(map[string]string{"namespace": namespace, "groupId": groupId})
+	item, err := newTrashItem(trashId, internal.TrashGroup, namespace, groupId, records)
+	if err != nil {
+		return internal.TrashItem{}, err
+	}
+
+	// The manifest is written before the folder moves, so that trashed data is always listed and purged
+	err = saveTrashManifest(item)
+	if err == nil {
+		err = os.Rename(folder, filepath.Join(VaultConfig.Root, trashItemDir(item.Id), "files"))
+	}
+	if err != nil {
+		os.RemoveAll(filepath.Join(VaultConfig.Root, trashItemDir(item.Id)))
+		return internal.TrashItem{}, err
+	}
+
+	forgetGroupRetention(namespace, groupId)
+	unindexGroup(namespace, groupId)
+	emitGroupDeleted(namespace, groupId)
+	return item, nil
+}
+
+// TrashElement moves an element of a group to the trash
+func TrashElement(namespace, groupId, elementId, trashId string) (internal.TrashItem, error) {
+	trashMu.Lock()
+	defer trashMu.Unlock()
+	retentionUpdates.Lock()
+	defer retentionUpdates.Unlock()
+
+	record, err := findElement(namespace, groupId, elementId)
+	if err != nil {
+		return internal.TrashItem{}, err
+	}
+	err = checkElementDeletable(record, time.Now())
+	if err != nil {
+		return internal.TrashItem{}, err
+	}
+
+	item, err := newTrashItem(trashId, internal.TrashElement, namespace, groupId, []internal.Record{record})
+	if err != nil {
+		return internal.TrashItem{}, err
+	}
+	files := filepath.Join(VaultConfig.Root, trashItemDir(item.Id), "files")
+	err = saveTrashManifest(item)
+	if err == nil {
+		err = os.Mkdir(files, os.ModePerm)
+	}
+	if err != nil {
+		os.RemoveAll(filepath.Join(VaultConfig.Root, trashItemDir(item.Id)))
+		return internal.TrashItem{}, err
+	}
+
+	// The meta file is moved first so that a failure never leaves an indexed element without data,
+	// and the files moved are put back when the other one cannot move
+	folder := filepath.Join(VaultConfig.Root, internal.GroupDir(namespace, groupId))
+	moved := make([]string, 0, 2)
+	for _, name := range []string{record.Id + "._meta", record.Id + record.Attributes["fileExtension"]} {
+		err = os.Rename(filepath.Join(folder, name), filepath.Join(files, name))
+		if err != nil {
+			for _, back := range moved {
+				restoreErr := os.Rename(filepath.Join(files, back), filepath.Join(folder, back))
+				if restoreErr != nil {
+					log.Printf("Error moving %s back from the trash: %v\n", back, restoreErr)
+					return internal.TrashItem{}, err
+				}
+			}
+			os.RemoveAll(filepath.Join(VaultConfig.Root, trashItemDir(item.Id)))
+			return internal.TrashItem{}, err
+		}
+		moved = append(moved, name)
+	}
+
+	RemoveRecord(record)
+	return item, nil
+}
+
+// readTrashItem reads the manifest of a trash item
+func readTrashItem(trashId string) (internal.TrashItem, error) {
+	manifest, _, err := internal.ReadBytesFromFile(VaultConfig.Root, trashItemDir(trashId), "manifest.json")
+	if errors.Is(err, os.ErrNotExist) {
+		return internal.TrashItem{}, ErrRecordNotFound
+	}
+	if err != nil {
+		return internal.TrashItem{}, err
+	}
+
+	var item internal.TrashItem
+	err = json.Unmarshal(manifest, &item)
+	if err != nil {
+		return internal.TrashItem{}, err
+	}
+	return item, nil
+}
+
+// ListTrash returns the trash items of a namespace, of one group when groupId is not empty, newest first
+func ListTrash(namespace, groupId string) ([]internal.TrashItem, error) {
+	items := make([]internal.TrashItem, 0)
+	dirs, err := os.ReadDir(filepath.Join(VaultConfig.Root, internal.TrashDir))
+	if errors.Is(err, os.ErrNotExist) {
+		return items, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, dir := range dirs {
+		if !dir.IsDir() {
+			continue
+		}
+		// Items without manifest are being created
+		item, err := readTrashItem(dir.Name())
+		if err != nil {
+			continue
+		}
+		if item.Namespace != namespace || (groupId != "" && item.GroupId != groupId) {
+			continue
+		}
+		items = append(items, item)
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].DeletedAt > items[j].DeletedAt
+	})
+	return items, nil
+}
+
+// RestoreTrash moves the elements of a trash item back into their group and indexes them again
+//
+// The item must belong to the given group. Restoring fails when one of its
+// elements was stored again meanwhile. The retention and metadata of a group
+// recreated since the delete are kept, the copies in the item are dropped.
+// A failed restore moves the files back into the item, so that it can be retried.
+func RestoreTrash(trashId, namespace, groupId string) (internal.TrashItem, error) {
+	trashMu.Lock()
+	defer trashMu.Unlock()
+	retentionUpdates.Lock()
+	defer retentionUpdates.Unlock()
+
+	item, err := readTrashItem(trashId)
+	if err != nil {
+		return internal.TrashItem{}, err
+	}
+	if item.Namespace != namespace || item.GroupId != groupId {
+		return internal.TrashItem{}, ErrRecordNotFound
+	}
+	for _, elementId := range item.Elements {
+		if _, err := findElement(namespace, groupId, elementId); err == nil {
+			return internal.TrashItem{}, fmt.Errorf("%w: %s", internal.ErrElementExists, elementId)
+		}
+	}
+
+	files := filepath.Join(VaultConfig.Root, trashItemDir(trashId), "files")
+	entries, err := os.ReadDir(files)
+	if err != nil {
+		return internal.TrashItem{}, err
+	}
+	dir := internal.GroupDir(namespace, groupId)
+	err = os.MkdirAll(filepath.Join(VaultConfig.Root, dir), os.ModePerm)
+	if err != nil {
+		return internal.TrashItem{}, err
+	}
+
+	// Data files are moved before meta files, so an element is only indexed at restart once complete
+	sort.SliceStable(entries, func(i, j int) bool {
+		return !strings.HasSuffix(entries[i].Name(), "._meta") && strings.HasSuffix(entries[j].Name(), "._meta")
+	})
+	moved := make([]string, 0, len(entries))
+	indexed := make([]internal.Record, 0, len(item.Elements))
+	rollback := func() {
+		for _, record := range indexed {
+			RemoveRecord(record)
+		}
+		for i := len(moved) - 1; i >= 0; i-- {
+			err := os.Rename(filepath.Join(VaultConfig.Root, dir, moved[i]), filepath.Join(files, moved[i]))
+			if err != nil {
+				log.Printf("Error moving %s back into trash item %s: %v\n", moved[i], trashId, err)
+			}
+		}
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		target := filepath.Join(VaultConfig.Root, dir, entry.Name())
+		if entry.Name() == internal.RetentionFile || entry.Name() == internal.GroupMetaFile {
+			if _, err := os.Stat(target); err == nil {
+				continue
+			}
+		}
+		err = os.Rename(filepath.Join(files, entry.Name()), target)
+		if err != nil {
+			rollback()
+			return internal.TrashItem{}, err
+		}
+		moved = append(moved, entry.Name())
+		if !strings.HasSuffix(entry.Name(), "._meta") {
+			continue
+		}
+
+		record, err := readMetaRecord(filepath.Join(VaultConfig.Root, dir, entry.Name()), namespace)
+		if err != nil {
+			rollback()
+			return internal.TrashItem{}, err
+		}
+		AddRecord(record)
+		indexed = append(indexed, record)
+	}
+	forgetGroupRetention(namespace, groupId)
+
+	return item, os.RemoveAll(filepath.Join(VaultConfig.Root, trashItemDir(trashId)))
+}
+
+// PurgeTrash deletes a trash item of a group for good
+func PurgeTrash(trashId, namespace, groupId string) error {
+	trashMu.Lock()
+	defer trashMu.Unlock()
+
+	item, err := readTrashItem(trashId)
+	if err != nil {
+		return err
+	}
+	if item.Namespace != namespace || item.GroupId != groupId {
+		return ErrRecordNotFound
+	}
+	return os.RemoveAll(filepath.Join(VaultConfig.Root, trashItemDir(trashId)))
+}
+
+// PurgeExpiredTrash deletes the trash items past their retention and returns how many were deleted
+func PurgeExpiredTrash(now time.Time) int {
+	trashMu.Lock()
+	defer trashMu.Unlock()
+
+	dirs, err := os.ReadDir(filepath.Join(VaultConfig.Root, internal.TrashDir))
+	if err != nil {
+		return 0
+	}
+
+	purged := 0
+	for _, dir := range dirs {
+		item, err := readTrashItem(dir.Name())
+		if err != nil || item.PurgeAt > now.UnixMilli() {
+			continue
+		}
+
+		err = os.RemoveAll(filepath.Join(VaultConfig.Root, trashItemDir(dir.Name())))
+		if err != nil {
+			log.Printf("Error purging trash item %s: %v\n", dir.Name(), err)
+			continue
+		}
+		purged++
+	}
+
+	return purged
+}
@@ -0,0 +1,102 @@
+package vault
+
+import (
+	"datavault/cmd/internal"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// uploadIds uploads files to a group and returns the ids of the stored elements
+func uploadIds(t *testing.T, groupId string, files ...string) []string {
+	t.Helper()
+	w := upload(t, url.Values{"groupId": {groupId}}, "", files...)
+	if w.Code != http.StatusOK {
+		t.Fatalf("upload status %d: %s", w.Code, w.Body)
+	}
+	var metadata []internal.Meta
+	err := json.NewDecoder(w.Body).Decode(&metadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := make([]string, len(metadata))
+	for i, meta := range metadata {
+		ids[i] = meta.FileId
+	}
+	return ids
+}
+
+// groupRecords returns the indexed elements of a group of the default namespace
+func groupRecords(groupId string) []internal.Record {
+	return FilterByGroup(internal.DefaultNamespace, groupId)
+}
+
+func TestRestoreTrash(t *testing.T) {
+	testVault(t)
+	VaultConfig.TrashRetention = 3600
+	ids := uploadIds(t, "g", "a.txt", "b.txt")
+
+	item, err := TrashGroup(internal.DefaultNamespace, "g", "t1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(item.Elements) != 2 || len(groupRecords("g")) != 0 {
+		t.Fatalf("trashed %d elements, %d left indexed", len(item.Elements), len(groupRecords("g")))
+	}
+
+	_, err = RestoreTrash("t1", internal.DefaultNamespace, "g")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if records := groupRecords("g"); len(records) != len(ids) {
+		t.Errorf("%d elements restored, want %d", len(records), len(ids))
+	}
+	if items, _ := ListTrash(internal.DefaultNamespace, ""); len(items) != 0 {
+		t.Errorf("%d items left in the trash", len(items))
+	}
+}
+
+func TestRestoreTrashRollback(t *testing.T) {
+	testVault(t)
+	VaultConfig.TrashRetention = 3600
+	ids := uploadIds(t, "g", "a.txt", "b.txt")
+	_, err := TrashGroup(internal.DefaultNamespace, "g", "t1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := filepath.Join(VaultConfig.Root, trashItemDir("t1"), "files")
+	trashed, err := os.ReadDir(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A folder in the way of a meta file makes the restore fail halfway
+	blocker := filepath.Join(VaultConfig.Root, "g", ids[1]+"._meta")
+	err = os.MkdirAll(blocker, os.ModePerm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = RestoreTrash("t1", internal.DefaultNamespace, "g")
+	if err == nil {
+		t.Fatal("restore succeeded over a folder")
+	}
+	if records := groupRecords("g"); len(records) != 0 {
+		t.Errorf("%d elements indexed after a failed restore", len(records))
+	}
+	if left, _ := os.ReadDir(files); len(left) != len(trashed) {
+		t.Errorf("%d files left in the trash item, want %d", len(left), len(trashed))
+	}
+
+	// The item is restored in full once the folder is gone
+	os.Remove(blocker)
+	_, err = RestoreTrash("t1", internal.DefaultNamespace, "g")
+	if err != nil {
+		t.Fatalf("retried restore: %v", err)
+	}
+	if records := groupRecords("g"); len(records) != len(ids) {
+		t.Errorf("%d elements restored, want %d", len(records), len(ids))
+	}
+}
@@ -0,0 +1,125 @@
+package vault
+
+import (
+	"datavault/cmd/internal"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// UsageTracker keeps the usage of every group of the vault, updated as records enter and leave the index
+type UsageTracker struct {
+	mu     sync.Mutex
+	groups map[string]map[string]internal.Usage // Usage of every group, by namespace then group id
+}
+
+// NewUsageTracker computes the usage of the records of an index
+func NewUsageTracker(index internal.Index) *UsageTracker {
+	tracker := &UsageTracker{groups: make(map[string]map[string]internal.Usage)}
+	for _, record := range index.SearchAll([]string{"groupId"}) {
+		tracker.update(record, 1)
+	}
+	return tracker
+}
+
+// recordUsage returns the usage of a single record
+func recordUsage(record internal.Record) internal.Usage {
+	size, _ := strconv.ParseInt(record.Attributes["fileSize"], 10, 64)
+	return internal.Usage{Bytes: size, Files: 1}
+}
+
+// update adds (sign 1) or subtracts (sign -1) the usage of a record, the caller holds the lock
+func (t *UsageTracker) update(record internal.Record, sign int64) {
+	namespace := record.Attributes["namespace"]
+	groupId := record.Attributes["groupId"]
+	delta := recordUsage(record)
+
+	groups, ok := t.groups[namespace]
+	if !ok {
+		groups = make(map[string]internal.Usage)
+		t.groups[namespace] = groups
+	}
+	usage := groups[groupId]
+	usage.Bytes += sign * delta.Bytes
+	usage.Files += sign * delta.Files
+
+	if usage.Files <= 0 {
+		delete(groups, groupId)
+		if len(groups) == 0 {
+			delete(t.groups, namespace)
+		}
+		return
+	}
+	groups[groupId] = usage
+}
+
+// Snapshot returns the usage of one namespace, or of every namespace when namespace is empty
+func (t *UsageTracker) Snapshot(namespace string) map[string]internal.NamespaceUsage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	snapshot := make(map[string]internal.NamespaceUsage)
+	for name, groups := range t.groups {
+		if namespace != "" && name != namespace {
+			continue
+		}
+
+		namespaceUsage := internal.NamespaceUsage{Groups: make(map[string]internal.Usage, len(groups))}
+		for groupId, usage := range groups {
+			namespaceUsage.Groups[groupId] = usage
+			namespaceUsage.Usage = namespaceUsage.Usage.Add(usage)
+		}
+		snapshot[name] = namespaceUsage
+	}
+
+	return snapshot
+}
+
+// AddRecord adds a record to the vault index, accounts for its usage and emits its creation
+func AddRecord(record internal.Record) {
+	VaultConfig.Usage.mu.Lock()
+	defer VaultConfig.Usage.mu.Unlock()
+
+	if VaultConfig.Index.GetAttributes(record.Id) != nil {
+		return
+	}
+	VaultConfig.Index.Add(record)
+	VaultConfig.Usage.update(record, 1)
+	emitElementEvent(internal.EventElementCreated, record)
+}
+
+// RemoveRecord removes a record from the vault index, releases its usage and emits its deletion, it reports whether the record was indexed
+func RemoveRecord(record internal.Record) bool {
+	VaultConfig.Usage.mu.Lock()
+	defer VaultConfig.Usage.mu.Unlock()
+
+	if VaultConfig.Index.GetAttributes(record.Id) == nil {
+		return false
+	}
+	VaultConfig.Index.Remove(record)
+	VaultConfig.Usage.update(record, -1)
+	emitElementEvent(internal.EventElementDeleted, record)
+	return true
+}
+
+// ReplaceRecord swaps the index record of an element for an updated one of the same size
+func ReplaceRecord(old, updated internal.Record) {
+	VaultConfig.Usage.mu.Lock()
+	defer VaultConfig.Usage.mu.Unlock()
+
+	VaultConfig.Index.Remove(old)
+	VaultConfig.Index.Add(updated)
+}
+
+// HandlerUsage returns the usage of the groups of the vault, for the namespace of the query or for every namespace
+func HandlerUsage(w http.ResponseWriter, r *http.Request) {
+	snapshot := VaultConfig.Usage.Snapshot(r.URL.Query().Get(internal.NamespaceParam))
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(snapshot)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
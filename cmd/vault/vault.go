@@ -1,7 +1,17 @@
 package vault
 
+import "log"
+
 // Exec initializes and starts the vault server
 func Exec() {
 	Init()
+	if VaultConfig.Gossip != nil {
+		var err error
+		VaultConfig.Membership, err = StartGossip()
+		if err != nil {
+			log.Fatalf("Error starting gossip membership: %v\n", err)
+		}
+	}
+	go Reaper()
 	Server()
 }
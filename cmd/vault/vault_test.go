@@ -0,0 +1,64 @@
+package vault
+
+import (
+	"bytes"
+	"datavault/cmd/internal"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// testVault points the vault configuration at an empty root for the duration of a test
+func testVault(t *testing.T) {
+	t.Helper()
+	VaultConfig.Id = "test"
+	VaultConfig.Root = t.TempDir()
+	VaultConfig.IN_MEMORY_UPLOAD_SIZE = 1 << 20
+	VaultConfig.MAX_UPLOAD_SIZE = 1 << 20
+	VaultConfig.HighWatermark = 0
+	VaultConfig.LowWatermark = 0
+	VaultConfig.Lifecycle = nil
+	VaultConfig.TrashRetention = 0
+	VaultConfig.ReadOnly.Store(false)
+	VaultConfig.Index = internal.NewIndex()
+	VaultConfig.Usage = NewUsageTracker(VaultConfig.Index)
+	VaultConfig.Events = internal.NewEventBuffer(100)
+	retentionMu.Lock()
+	clear(groupRetentions)
+	retentionMu.Unlock()
+}
+
+// uploadRequest returns a multipart upload of files with the given names, with an optional meta part
+func uploadRequest(t *testing.T, query url.Values, meta string, files ...string) *http.Request {
+	t.Helper()
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	if meta != "" {
+		err := writer.WriteField("meta", meta)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range files {
+		part, err := writer.CreateFormFile("files", name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write([]byte("content of " + name))
+	}
+	writer.Close()
+
+	r := httptest.NewRequest(http.MethodPut, "/group?"+query.Encode(), body)
+	r.Header.Set("Content-Type", writer.FormDataContentType())
+	return r
+}
+
+// upload stores files in a group through the upload handler and returns the response
+func upload(t *testing.T, query url.Values, meta string, files ...string) *httptest.ResponseRecorder {
+	t.Helper()
+	w := httptest.NewRecorder()
+	HandlerGroupUpload(w, uploadRequest(t, query, meta, files...))
+	return w
+}
@@ -0,0 +1,141 @@
+package vault
+
+import (
+	"datavault/cmd/internal"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Versioned reports whether a group keeps the previous versions of its keyed elements
+func Versioned(namespace, groupId string) bool {
+	rule := LifecycleRuleOf(namespace, groupId)
+	return rule != nil && rule.Versioning
+}
+
+// KeptVersions returns how many versions of a key a group keeps, 0 keeps every version
+//
+// Groups without versioning keep the newest version only, an upload to an
+// existing key replaces it.
+func KeptVersions(namespace, groupId string) int {
+	rule := LifecycleRuleOf(namespace, groupId)
+	if rule == nil || !rule.Versioning {
+		return 1
+	}
+	return rule.MaxVersions
+}
+
+// Versions returns the live versions of a key in a group, newest first
+func Versions(namespace, groupId, key string) []internal.Record {
+	records := VaultConfig.Index.SearchEvery(map[string]string{"namespace": namespace, "groupId": groupId, "key": key})
+	now := time.Now()
+	versions := make([]internal.Record, 0, len(records))
+	for _, record := range records {
+		if !Expired(record, now) {
+			versions = append(versions, record)
+		}
+	}
+
+	internal.SortVersions(versions)
+	return versions
+}
+
+// FindVersion returns a version of a key, the newest one when versionId is empty
+func FindVersion(namespace, groupId, key, versionId string) (internal.Record, error) {
+	versions := Versions(namespace, groupId, key)
+	for _, version := range versions {
+		if versionId == "" || version.Id == versionId {
+			return version, nil
+		}
+	}
+	return internal.Record{}, ErrRecordNotFound
+}
+
+// PruneVersions deletes the versions of a key beyond the newest keep ones, keep 0 keeps every version
+//
+// Versions locked by retention are kept.
+func PruneVersions(namespace, groupId, key string, keep int) error {
+	if keep <= 0 {
+		return nil
+	}
+
+	versions := Versions(namespace, groupId, key)
+	now := time.Now()
+	for _, version := range versions[min(keep, len(versions)):] {
+		if ElementLocked(version, now) {
+			continue
+		}
+		err := DeleteElement(namespace, groupId, version.Id)
+		if err != nil && !errors.Is(err, ErrRecordNotFound) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RestoreVersion stores a copy of a version of a key as its newest version
+//
+// elementId and receivedTime are assigned by the gatekeeper so that every
+// replica stores the restored version under the same id, they are generated
+// when empty.
+func RestoreVersion(namespace, groupId, key, versionId, elementId, receivedTime string) (internal.Meta, error) {
+	version, err := FindVersion(namespace, groupId, key, versionId)
+	if err != nil {
+		return internal.Meta{}, err
+	}
+	err = checkKeysOverwritable(namespace, groupId, []internal.Meta{{Key: key}}, time.Now())
+	if err != nil {
+		return internal.Meta{}, err
+	}
+
+	if elementId == "" {
+		elementId = strings.ReplaceAll(uuid.New().String(), "-", "")
+	}
+	if receivedTime == "" {
+		receivedTime = fmt.Sprintf("%d", time.Now().UnixMilli())
+	}
+
+	dir := internal.GroupDir(namespace, groupId)
+	if err := checkElementId(dir, elementId); err != nil {
+		return internal.Meta{}, err
+	}
+
+	meta := internal.Meta{
+		FileId:        elementId,
+		FileType:      version.Attributes["fileType"],
+		FileName:      version.Attributes["fileName"],
+		FileExtension: version.Attributes["fileExtension"],
+		FileSize:      version.Attributes["fileSize"],
+		ReceivedTime:  receivedTime,
+		GroupId:       groupId,
+		Namespace:     namespace,
+		Checksum:      version.Attributes["checksum"],
+		Key:           key,
+		Path:          version.Attributes["path"],
+	}
+
+	// The meta file is written last so it only exists for complete files
+	err = internal.LinkFile(VaultConfig.Root, dir, version.Id+meta.FileExtension, dir, elementId+meta.FileExtension)
+	if err != nil {
+		return internal.Meta{}, err
+	}
+	metaBytes, err := json.Marshal(meta)
+	if err != nil {
+		internal.DeleteFile(VaultConfig.Root, dir, elementId+meta.FileExtension)
+		return internal.Meta{}, err
+	}
+	err = internal.SaveBytesToFile(VaultConfig.Root, dir, elementId+"._meta", metaBytes)
+	if err != nil {
+		internal.DeleteFile(VaultConfig.Root, dir, elementId+meta.FileExtension)
+		return internal.Meta{}, err
+	}
+
+	AddRecord(metaRecord(meta))
+
+	return meta, PruneVersions(namespace, groupId, key, KeptVersions(namespace, groupId))
+}
@@ -0,0 +1,62 @@
+package vault
+
+import (
+	"datavault/cmd/internal"
+	"log"
+)
+
+// usedPercent returns the share of the filesystem in use, in percent
+func usedPercent(usage internal.DiskUsage) float64 {
+	if usage.Capacity == 0 {
+		return 0
+	}
+	return float64(usage.Used) / float64(usage.Capacity) * 100
+}
+
+// CheckWatermarks updates and returns the read-only state of the vault
+//
+// The vault turns read-only once disk usage reaches the high watermark and
+// becomes writable again only when usage drops below the low watermark.
+// Watermarks set to 0 disable the check.
+func CheckWatermarks() (bool, error) {
+	if VaultConfig.HighWatermark <= 0 {
+		return false, nil
+	}
+
+	usage, err := internal.GetDiskUsage(VaultConfig.Root)
+	if err != nil {
+		return VaultConfig.ReadOnly.Load(), err
+	}
+
+	used := usedPercent(usage)
+	readOnly := VaultConfig.ReadOnly.Load()
+	switch {
+	case !readOnly && used >= VaultConfig.HighWatermark:
+		log.Printf("Disk usage %.1f%% reached the high watermark, vault is now read-only\n", used)
+		VaultConfig.ReadOnly.Store(true)
+	case readOnly && used < VaultConfig.LowWatermark:
+		log.Printf("Disk usage %.1f%% dropped below the low watermark, vault is writable again\n", used)
+		VaultConfig.ReadOnly.Store(false)
+	}
+
+	return VaultConfig.ReadOnly.Load(), nil
+}
+
+// HasFreeSpace checks that size bytes can be written without crossing the high watermark
+func HasFreeSpace(size int64) (bool, error) {
+	usage, err := internal.GetDiskUsage(VaultConfig.Root)
+	if err != nil {
+		return false, err
+	}
+
+	if size < 0 || uint64(size) > usage.Free {
+		return false, nil
+	}
+
+	if VaultConfig.HighWatermark <= 0 || usage.Capacity == 0 {
+		return true, nil
+	}
+
+	usage.Used += uint64(size)
+	return usedPercent(usage) < VaultConfig.HighWatermark, nil
+}
@@ -19,7 +19,7 @@ var Instance Configuration
 // Init initializes the configuration
 func (c *Configuration) Init() {
 	// Initialize the application
-	flag.StringVar(&c.Command, "cmd", "", "Command to run (required, options: 'keeper', 'vault')")
+	flag.StringVar(&c.Command, "cmd", "", "Command to run (required, options: 'gatekeeper', 'vault', 'audit-verify')")
 	flag.StringVar(&c.ConfigFilePath, "ref", "", "Reference file path to use for configuration (required)")
 	help := flag.Bool("help", false, "Show help")
 	flag.Parse()
@@ -29,7 +29,7 @@ func (c *Configuration) Init() {
 		os.Exit(0)
 	}
 
-	if c.Command == "" || (c.Command != "gatekeeper" && c.Command != "vault") {
+	if c.Command == "" || (c.Command != "gatekeeper" && c.Command != "vault" && c.Command != "audit-verify") {
 		log.Println("Valid Command is required")
 		CliHelper()
 		os.Exit(1)
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"datavault/cmd/audit"
 	"datavault/cmd/gatekeeper"
 	"datavault/cmd/vault"
 	"datavault/configs"
@@ -17,6 +18,8 @@ func main() {
 		gatekeeper.Exec()
 	case "vault":
 		vault.Exec()
+	case "audit-verify":
+		audit.Exec()
 	default:
 		log.Fatalln("Command not recognized")
 	}